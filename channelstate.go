@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tellytv/telly/internal/providers"
+)
+
+// channelStateExpiry is how long a channel can be missing from every source before its number is given away.
+const channelStateExpiry = 30 * 24 * time.Hour
+
+// channelStateEntry is the number a channel was last given.
+type channelStateEntry struct {
+	Number   providers.ChannelNumber
+	LastSeen time.Time
+}
+
+// channelNumberState remembers the number given to each channel so that it keeps it across scans and restarts.
+// A nil state is valid and never remembers anything.
+type channelNumberState struct {
+	path string
+
+	Channels map[string]channelStateEntry
+
+	// reserved holds the numbers of all remembered channels.
+	reserved map[providers.ChannelNumber]string
+}
+
+// loadChannelNumberState reads the state at path, starting empty if there is none yet.
+func loadChannelNumberState(path string) *channelNumberState {
+	state := &channelNumberState{
+		path:     path,
+		Channels: make(map[string]channelStateEntry),
+	}
+
+	data, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		if !os.IsNotExist(readErr) {
+			log.WithError(readErr).Warnf("unable to read channel number state %s, channels will be numbered from scratch", path)
+		}
+		state.index()
+		return state
+	}
+
+	if unmarshalErr := json.Unmarshal(data, state); unmarshalErr != nil {
+		log.WithError(unmarshalErr).Warnf("unable to parse channel number state %s, channels will be numbered from scratch", path)
+		state.Channels = make(map[string]channelStateEntry)
+	}
+
+	state.index()
+	return state
+}
+
+func (s *channelNumberState) index() {
+	s.reserved = make(map[providers.ChannelNumber]string)
+	for identity, entry := range s.Channels {
+		s.reserved[entry.Number] = identity
+	}
+}
+
+// get returns the number the channel with the given identity had before.
+func (s *channelNumberState) get(identity string) (providers.ChannelNumber, bool) {
+	if s == nil {
+		return providers.ChannelNumber{}, false
+	}
+	entry, ok := s.Channels[identity]
+	return entry.Number, ok
+}
+
+// isReserved reports whether the number belongs to a remembered channel other than identity.
+func (s *channelNumberState) isReserved(number providers.ChannelNumber, identity string) bool {
+	if s == nil {
+		return false
+	}
+	holder, ok := s.reserved[number]
+	return ok && holder != identity
+}
+
+// put remembers the number of a channel seen in this scan.
+func (s *channelNumberState) put(identity string, number providers.ChannelNumber) {
+	if s == nil {
+		return
+	}
+	if previous, ok := s.Channels[identity]; ok && s.reserved[previous.Number] == identity {
+		delete(s.reserved, previous.Number)
+	}
+	s.Channels[identity] = channelStateEntry{Number: number, LastSeen: time.Now()}
+	s.reserved[number] = identity
+}
+
+// save forgets channels that haven't been seen for a long time and writes the state to disk.
+func (s *channelNumberState) save() error {
+	if s == nil {
+		return nil
+	}
+
+	for identity, entry := range s.Channels {
+		if time.Since(entry.LastSeen) > channelStateExpiry {
+			delete(s.Channels, identity)
+		}
+	}
+	s.index()
+
+	data, marshalErr := json.MarshalIndent(s, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	if mkdirErr := os.MkdirAll(filepath.Dir(s.path), 0755); mkdirErr != nil {
+		return mkdirErr
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated state behind.
+	tmpPath := s.path + ".tmp"
+	if writeErr := ioutil.WriteFile(tmpPath, data, 0644); writeErr != nil {
+		return writeErr
+	}
+
+	return os.Rename(tmpPath, s.path)
+}
+
+// channelIdentities returns a key for every pending channel that stays the same across scans,
+// based on the tvg-id or, failing that or if the tvg-id is shared, on the name and the provider.
+func channelIdentities(pending []pendingChannel) []string {
+	identities := make([]string, len(pending))
+	seen := make(map[string]bool)
+
+	for idx, entry := range pending {
+		identity := ""
+		if tvgID := entry.channel.Track.Tags["tvg-id"]; tvgID != "" {
+			identity = fmt.Sprintf("%s/id/%s", entry.provider.Name(), tvgID)
+		}
+		if identity == "" || seen[identity] {
+			sum := sha1.Sum([]byte(entry.provider.Name() + "\x00" + entry.channel.Name))
+			identity = fmt.Sprintf("%s/name/%x", entry.provider.Name(), sum[:8])
+		}
+		// Identical channels in the same source are told apart by their order.
+		for base, copies := identity, 1; seen[identity]; copies++ {
+			identity = fmt.Sprintf("%s/%d", base, copies)
+		}
+		seen[identity] = true
+		identities[idx] = identity
+	}
+
+	return identities
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tellytv/telly/internal/providers"
+)
+
+func TestAssignChannelNumbersRemembersNumbers(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "telly")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+
+	source := testProvider(t, providers.Configuration{Name: "a"})
+	l := testLineup(source)
+	l.channelState = loadChannelNumberState(filepath.Join(dir, "channel-numbers.json"))
+
+	scans := []struct {
+		channels []testPending
+		expected map[string]string
+	}{
+		{[]testPending{{name: "A"}, {name: "B", tvgID: "b"}}, map[string]string{"A": "100", "B": "101"}},
+		// B keeps its number when the playlist order changes, and C doesn't get the number A is coming back for.
+		{[]testPending{{name: "C"}, {name: "B renamed", tvgID: "b"}}, map[string]string{"C": "102", "B renamed": "101"}},
+		{[]testPending{{name: "B", tvgID: "b"}, {name: "C"}, {name: "A"}}, map[string]string{"A": "100", "B": "101", "C": "102"}},
+		// A number from the playlist wins over a remembered one.
+		{[]testPending{{name: "A"}, {name: "D", number: "100"}}, map[string]string{"A": "103", "D": "100"}},
+	}
+
+	for idx, scan := range scans {
+		channels := l.assignChannelNumbers(testPendingChannels([]providers.Provider{source}, scan.channels))
+		if actual := channelNumbersByName(channels); fmt.Sprint(actual) != fmt.Sprint(scan.expected) {
+			t.Errorf("scan %d: expected %v, got %v", idx+1, scan.expected, actual)
+		}
+	}
+
+	// The state is read back the same way on restart.
+	l.channelState = loadChannelNumberState(filepath.Join(dir, "channel-numbers.json"))
+	channels := l.assignChannelNumbers(testPendingChannels([]providers.Provider{source}, []testPending{{name: "C"}, {name: "B", tvgID: "b"}}))
+	if actual := channelNumbersByName(channels); fmt.Sprint(actual) != fmt.Sprint(map[string]string{"B": "101", "C": "102"}) {
+		t.Errorf("expected the numbers to be remembered across restarts, got %v", actual)
+	}
+}
+
+func TestCollisionSkipsRememberedNumbers(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "telly")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+
+	source := testProvider(t, providers.Configuration{Name: "a"})
+	l := testLineup(source)
+	l.channelState = loadChannelNumberState(filepath.Join(dir, "channel-numbers.json"))
+
+	scans := []struct {
+		channels []testPending
+		expected map[string]string
+	}{
+		{[]testPending{{name: "A", number: "5"}, {name: "B", number: "6"}}, map[string]string{"A": "5", "B": "6"}},
+		// B is missing for a scan, so C has to step over the number B is coming back for.
+		{[]testPending{{name: "A", number: "5"}, {name: "C", number: "5"}}, map[string]string{"A": "5", "C": "7"}},
+		{[]testPending{{name: "A", number: "5"}, {name: "B", number: "6"}, {name: "C", number: "5"}}, map[string]string{"A": "5", "B": "6", "C": "7"}},
+	}
+
+	for idx, scan := range scans {
+		channels := l.assignChannelNumbers(testPendingChannels([]providers.Provider{source}, scan.channels))
+		if actual := channelNumbersByName(channels); fmt.Sprint(actual) != fmt.Sprint(scan.expected) {
+			t.Errorf("scan %d: expected %v, got %v", idx+1, scan.expected, actual)
+		}
+	}
+}
+
+func TestChannelNumberStateExpiry(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "telly")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "channel-numbers.json")
+	state := loadChannelNumberState(path)
+	state.put("a/id/recent", providers.ChannelNumber{Major: 100})
+	state.put("a/id/old", providers.ChannelNumber{Major: 101})
+	state.put("a/id/expired", providers.ChannelNumber{Major: 102})
+	state.Channels["a/id/old"] = channelStateEntry{Number: providers.ChannelNumber{Major: 101}, LastSeen: time.Now().Add(-channelStateExpiry + time.Hour)}
+	state.Channels["a/id/expired"] = channelStateEntry{Number: providers.ChannelNumber{Major: 102}, LastSeen: time.Now().Add(-channelStateExpiry - time.Hour)}
+
+	if saveErr := state.save(); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+
+	loaded := loadChannelNumberState(path)
+	tests := []struct {
+		identity string
+		number   providers.ChannelNumber
+		kept     bool
+	}{
+		{"a/id/recent", providers.ChannelNumber{Major: 100}, true},
+		{"a/id/old", providers.ChannelNumber{Major: 101}, true},
+		{"a/id/expired", providers.ChannelNumber{Major: 102}, false},
+	}
+
+	for _, test := range tests {
+		if number, ok := loaded.get(test.identity); ok != test.kept || (ok && number != test.number) {
+			t.Errorf("%s: expected kept to be %t with %s, got %t with %s", test.identity, test.kept, test.number, ok, number)
+		}
+		if reserved := loaded.isReserved(test.number, "a/id/other"); reserved != test.kept {
+			t.Errorf("%s: expected %s to be reserved to be %t", test.identity, test.number, test.kept)
+		}
+		if loaded.isReserved(test.number, test.identity) {
+			t.Errorf("%s: expected its own number not to be reserved against it", test.identity)
+		}
+	}
+}
+
+func TestChannelNumberStateSave(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "telly")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state", "channel-numbers.json")
+	state := loadChannelNumberState(path)
+	state.put("a/id/one", providers.ChannelNumber{Major: 100})
+	if saveErr := state.save(); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+	if _, statErr := os.Stat(path + ".tmp"); !os.IsNotExist(statErr) {
+		t.Errorf("expected the temporary file to be renamed, got %v", statErr)
+	}
+
+	// A save that can't be written must leave the previous state in place.
+	if mkdirErr := os.Mkdir(path+".tmp", 0755); mkdirErr != nil {
+		t.Fatal(mkdirErr)
+	}
+	state.put("a/id/one", providers.ChannelNumber{Major: 200})
+	if saveErr := state.save(); saveErr == nil {
+		t.Errorf("expected saving over a directory to fail")
+	}
+	if number, ok := loadChannelNumberState(path).get("a/id/one"); !ok || number != (providers.ChannelNumber{Major: 100}) {
+		t.Errorf("expected the previous state to survive, got %s", number)
+	}
+
+	// A broken state is started over rather than stopping the scan.
+	if writeErr := ioutil.WriteFile(path, []byte("{"), 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	if broken := loadChannelNumberState(path); len(broken.Channels) != 0 {
+		t.Errorf("expected a broken state to be started over, got %v", broken.Channels)
+	}
+
+	var nilState *channelNumberState
+	nilState.put("a/id/one", providers.ChannelNumber{Major: 100})
+	if _, ok := nilState.get("a/id/one"); ok || nilState.save() != nil {
+		t.Errorf("expected a nil state to remember nothing")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tellytv/telly/internal/xmltv"
+)
+
+// programmeKind is the broad type of a programme, which Plex uses to decide how recordings are organised.
+type programmeKind string
+
+const (
+	kindUnknown programmeKind = ""
+	kindSeries  programmeKind = "series"
+	kindMovie   programmeKind = "movie"
+	kindSports  programmeKind = "sports"
+	kindNews    programmeKind = "news"
+)
+
+// kindCategories are the category values Plex looks for, per kind.
+var kindCategories = map[programmeKind]string{
+	kindSeries: "Series",
+	kindMovie:  "Movie",
+	kindSports: "Sports",
+	kindNews:   "News",
+}
+
+var (
+	// Matches a release year like "(1999)", common at the start of movie descriptions.
+	releaseYearRegex = regexp.MustCompile(`\((?:19|20)\d{2}\)`)
+	newsRegex        = regexp.MustCompile(`(?i)\bnews\b`)
+	sportsRegex      = regexp.MustCompile(`(?i)\b(?:vs?\.?|at)\b.*\b(?:live|football|soccer|basketball|baseball|hockey|cricket|rugby|tennis|golf)\b|\b(?:nfl|nba|nhl|mlb|mls|ufc|premier league|grand prix|formula 1)\b`)
+)
+
+// movieMinimumLength is the shortest a programme can be to be guessed to be a movie from its description alone.
+const movieMinimumLength = 75 * time.Minute
+
+// classifyProgramme works out the kind of a programme, using its dd_progid when it has one, then its categories and
+// finally its title, sub-title and description.
+func classifyProgramme(programme *xmltv.Programme) programmeKind {
+	episode := programme.Episode()
+
+	if episode.ProgID != nil {
+		switch episode.ProgID.Type {
+		case "MV":
+			return kindMovie
+		case "SP":
+			return kindSports
+		case "EP", "SH":
+			// News shows are SH too, so let the categories have their say first.
+			if kind := kindFromCategories(programme.Categories); kind == kindNews {
+				return kindNews
+			}
+			return kindSeries
+		}
+	}
+
+	if kind := kindFromCategories(programme.Categories); kind != kindUnknown {
+		return kind
+	}
+
+	if episode.Season > 0 || episode.Episode > 0 {
+		return kindSeries
+	}
+
+	if _, ok := guessEpisode(programme); ok {
+		return kindSeries
+	}
+
+	for _, title := range programme.Titles {
+		if newsRegex.MatchString(title.Value) {
+			return kindNews
+		}
+		if sportsRegex.MatchString(title.Value) {
+			return kindSports
+		}
+	}
+
+	if len(programme.SecondaryTitles) == 0 && programmeLength(programme) >= movieMinimumLength {
+		for _, description := range programme.Descriptions {
+			if releaseYearRegex.MatchString(description.Value) {
+				return kindMovie
+			}
+		}
+	}
+
+	if len(programme.SecondaryTitles) > 0 {
+		// Only episodes of something have a sub-title.
+		return kindSeries
+	}
+
+	return kindUnknown
+}
+
+// kindFromCategories returns the kind implied by the first category that names one.
+func kindFromCategories(categories []xmltv.CommonElement) programmeKind {
+	for _, category := range categories {
+		switch strings.ToLower(strings.TrimSpace(category.Value)) {
+		case "movie", "movies", "film", "feature film", "tv movie":
+			return kindMovie
+		case "sports", "sport", "sports event", "sports non-event", "sports talk":
+			return kindSports
+		case "news", "newsmagazine", "news magazine", "current affairs":
+			return kindNews
+		case "series", "tvshow", "tv show", "episode", "sitcom", "soap", "drama series":
+			return kindSeries
+		}
+	}
+	return kindUnknown
+}
+
+// guessEpisode looks for an episode number in the title, sub-title and description of a programme.
+func guessEpisode(programme *xmltv.Programme) (xmltv.Episode, bool) {
+	for _, elements := range [][]xmltv.CommonElement{programme.Titles, programme.SecondaryTitles, programme.Descriptions} {
+		for _, element := range elements {
+			if episode, parseErr := xmltv.ParseOnScreen(element.Value); parseErr == nil && episode.Episode > 0 {
+				return episode, true
+			}
+		}
+	}
+	return xmltv.Episode{}, false
+}
+
+func programmeLength(programme *xmltv.Programme) time.Duration {
+	if programme.Start == nil || programme.Stop == nil {
+		return 0
+	}
+	return programme.Stop.Sub(programme.Start.Time)
+}
+
+// applyPlexConventions adds the category, episode numbering and new/previously-shown markers Plex uses to tell
+// movies, series, sports and news apart and to decide which airings to record.
+func applyPlexConventions(programme *xmltv.Programme, kind programmeKind) {
+	if kind == kindUnknown {
+		return
+	}
+
+	programme.Categories = appendUniqueElement(programme.Categories, xmltv.CommonElement{Lang: "en", Value: kindCategories[kind]})
+
+	if kind == kindMovie {
+		return
+	}
+
+	episode := programme.Episode()
+
+	// When the programme first aired, as Schedules Direct or an original-air-date episode number says, or else when
+	// the source says it was previously shown. The date element is often just the year it was made, so it can't tell
+	// a first airing from a repeat.
+	originalAirDate := episode.AirDate
+	if originalAirDate.IsZero() && programme.PreviouslyShown != nil {
+		originalAirDate, _ = programme.PreviouslyShown.StartTime()
+	}
+
+	if kind == kindSeries && episode.Episode == 0 {
+		if guessed, ok := guessEpisode(programme); ok {
+			episode = episode.Merge(guessed)
+		}
+	}
+
+	if !episode.HasNumbers() && episode.AirDate.IsZero() {
+		episode.AirDate = originalAirDate
+		// Sports and news are rarely repeated and without an episode number Plex can't tell airings apart,
+		// so they are identified by the day they air instead.
+		if episode.AirDate.IsZero() && (kind == kindSports || kind == kindNews) && programme.Start != nil {
+			episode.AirDate = programme.Start.Time
+		}
+	}
+
+	programme.SetEpisode(episode)
+
+	if programme.New != nil || programme.PreviouslyShown != nil || originalAirDate.IsZero() || programme.Start == nil {
+		return
+	}
+
+	// Air dates are usually just a day, which is compared with the day the programme starts on where it airs.
+	airDay, startDay := calendarDay(originalAirDate), calendarDay(programme.Start.Time)
+	if airDay.Equal(startDay) {
+		isNew := xmltv.ElementPresent(true)
+		programme.New = &isNew
+	} else if airDay.Before(startDay) {
+		programme.PreviouslyShown = &xmltv.PreviouslyShown{Start: originalAirDate.Format("20060102")}
+	}
+}
+
+// calendarDay returns midnight UTC of the day the time falls on in its own location.
+func calendarDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
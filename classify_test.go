@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tellytv/telly/internal/xmltv"
+)
+
+func TestApplyPlexConventionsNewAndRepeats(t *testing.T) {
+	start := time.Date(2018, time.August, 1, 20, 0, 0, 0, time.UTC)
+	// 21:00 in Chicago is already the next day in UTC.
+	chicagoStart := time.Date(2018, time.August, 1, 21, 0, 0, 0, time.FixedZone("CDT", -5*60*60))
+
+	tests := []struct {
+		name            string
+		start           time.Time
+		date            time.Time
+		originalAirDate time.Time
+		previouslyShown *xmltv.PreviouslyShown
+		expectNew       bool
+		expectRepeat    string
+		expectAirDate   time.Time
+	}{
+		{
+			name: "date alone says nothing",
+			date: start,
+		},
+		{
+			name:            "first aired today",
+			date:            start.AddDate(-1, 0, 0),
+			originalAirDate: start.Add(-2 * time.Hour),
+			expectNew:       true,
+			expectAirDate:   start.Add(-2 * time.Hour),
+		},
+		{
+			name:            "first aired before",
+			date:            start,
+			originalAirDate: time.Date(2017, time.March, 4, 0, 0, 0, 0, time.UTC),
+			expectRepeat:    "20170304",
+			expectAirDate:   time.Date(2017, time.March, 4, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:            "previously shown",
+			previouslyShown: &xmltv.PreviouslyShown{Start: "20170304200000 +0000"},
+			expectRepeat:    "20170304200000 +0000",
+			expectAirDate:   time.Date(2017, time.March, 4, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			name:            "first aired today where it airs",
+			start:           chicagoStart,
+			originalAirDate: time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC),
+			expectNew:       true,
+			expectAirDate:   time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:            "first airs tomorrow where it airs",
+			start:           chicagoStart,
+			originalAirDate: time.Date(2018, time.August, 2, 0, 0, 0, 0, time.UTC),
+			expectAirDate:   time.Date(2018, time.August, 2, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := start
+			if !tt.start.IsZero() {
+				start = tt.start
+			}
+			programme := &xmltv.Programme{
+				Titles:          []xmltv.CommonElement{{Value: "Show"}},
+				SecondaryTitles: []xmltv.CommonElement{{Value: "Episode"}},
+				Date:            xmltv.Date(tt.date),
+				PreviouslyShown: tt.previouslyShown,
+				Start:           &xmltv.Time{Time: start},
+				Stop:            &xmltv.Time{Time: start.Add(time.Hour)},
+			}
+			if !tt.originalAirDate.IsZero() {
+				programme.SetEpisode(xmltv.Episode{AirDate: tt.originalAirDate})
+			}
+
+			applyPlexConventions(programme, classifyProgramme(programme))
+
+			if isNew := programme.New != nil; isNew != tt.expectNew {
+				t.Errorf("expected new to be %t, got %t", tt.expectNew, isNew)
+			}
+			repeat := ""
+			if programme.PreviouslyShown != nil {
+				repeat = programme.PreviouslyShown.Start
+			}
+			if repeat != tt.expectRepeat {
+				t.Errorf("expected previously-shown %q, got %q", tt.expectRepeat, repeat)
+			}
+			if airDate := programme.Episode().AirDate; !airDate.Equal(tt.expectAirDate) {
+				t.Errorf("expected original air date %s, got %s", tt.expectAirDate, airDate)
+			}
+		})
+	}
+}
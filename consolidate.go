@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tellytv/telly/internal/providers"
+)
+
+// resolutionRanks orders resolutions from worst to best, unknown resolutions rank lowest.
+var resolutionRanks = map[string]int{
+	providers.QualitySD:  1,
+	providers.QualityHD:  2,
+	providers.QualityFHD: 3,
+	providers.QualityUHD: 4,
+}
+
+// channelStream is one of the streams a consolidated channel can be played from.
+type channelStream struct {
+	Provider string
+	Channel  string
+	Priority int
+	Healthy  bool
+	Quality  string `json:",omitempty"`
+
+	uri *url.URL
+	// Where the source is in the configuration, to break ties.
+	sourceIndex int
+}
+
+// minConsolidationNameLength is the fewest characters a reduced name needs to tell channels apart.
+const minConsolidationNameLength = 3
+
+// consolidationName reduces a channel name the way EPG matching does, after also removing quality and codec tokens
+// wherever they are, so that "UK: BBC One HD" and "BBC ONE" are seen as the same channel. Names that are reduced to
+// only digits or to a couple of characters say too little to merge channels by and are returned empty.
+func consolidationName(name string) string {
+	name = providers.NormaliseChannelName(providers.StripQualityTokens(name))
+	if utf8.RuneCountInString(name) < minConsolidationNameLength || strings.IndexFunc(name, func(r rune) bool { return !unicode.IsDigit(r) }) == -1 {
+		return ""
+	}
+	return name
+}
+
+// consolidateChannels merges channels carried by several sources into the first of them, by tvg-id or normalised name.
+// The merged channel keeps its own name, number and metadata, but is streamed from the best of the duplicates:
+// healthy streams first, then the one from the source with the highest Priority, then the best resolution.
+// Duplicates within a single source are left alone.
+func (l *lineup) consolidateChannels(pending []pendingChannel) []pendingChannel {
+	sourceIndexes := make(map[string]int)
+	for idx, provider := range l.Sources {
+		sourceIndexes[provider.Name()] = idx
+	}
+
+	groups := make([][]int, 0)
+	groupByKey := make(map[string]int)
+
+	for idx, entry := range pending {
+		keys := make([]string, 0, 2)
+		if tvgID := strings.ToLower(strings.TrimSpace(entry.channel.Track.Tags["tvg-id"])); tvgID != "" {
+			keys = append(keys, "id:"+tvgID)
+		}
+		if name := consolidationName(entry.channel.Name); name != "" {
+			keys = append(keys, "name:"+name)
+		}
+
+		group := -1
+		for _, key := range keys {
+			if candidate, ok := groupByKey[key]; ok && !groupHasProvider(pending, groups[candidate], entry.provider) {
+				group = candidate
+				break
+			}
+		}
+
+		if group == -1 {
+			group = len(groups)
+			groups = append(groups, nil)
+		}
+		groups[group] = append(groups[group], idx)
+
+		for _, key := range keys {
+			if _, ok := groupByKey[key]; !ok {
+				groupByKey[key] = group
+			}
+		}
+	}
+
+	consolidated := make([]pendingChannel, 0, len(groups))
+
+	for _, group := range groups {
+		entry := pending[group[0]]
+		if len(group) == 1 {
+			consolidated = append(consolidated, entry)
+			continue
+		}
+
+		streams := make([]channelStream, 0, len(group))
+		for _, idx := range group {
+			duplicate := pending[idx]
+			streams = append(streams, channelStream{
+				Provider:    duplicate.provider.Name(),
+				Channel:     duplicate.channel.Name,
+				Priority:    duplicate.provider.Configuration().Priority,
+				Healthy:     l.prober.healthy(duplicate.channel.StreamURL),
+				Quality:     duplicate.channel.Quality,
+				uri:         duplicate.channel.Track.URI,
+				sourceIndex: sourceIndexes[duplicate.provider.Name()],
+			})
+
+			// A duplicate can fill in the guide if the channel has none of its own.
+			if entry.channel.EPGChannel == nil && duplicate.channel.EPGChannel != nil {
+				entry.channel.EPGChannel = duplicate.channel.EPGChannel
+				entry.channel.EPGProgrammes = duplicate.channel.EPGProgrammes
+			}
+		}
+
+		sort.SliceStable(streams, func(i, j int) bool {
+			a, b := streams[i], streams[j]
+			if a.Healthy != b.Healthy {
+				return a.Healthy
+			}
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+			if resolutionRanks[a.Quality] != resolutionRanks[b.Quality] {
+				return resolutionRanks[a.Quality] > resolutionRanks[b.Quality]
+			}
+			return a.sourceIndex < b.sourceIndex
+		})
+
+		entry.streams = streams
+		consolidated = append(consolidated, entry)
+
+		log.Debugf("Channel %s is carried by %d sources, streaming it from %s", entry.channel.Name, len(streams), streams[0].Provider)
+	}
+
+	if merged := len(pending) - len(consolidated); merged > 0 {
+		log.Infof("Consolidated %d duplicate channels across sources", merged)
+	}
+
+	return consolidated
+}
+
+// consolidationReport lists the ranked streams of every consolidated channel, keyed by channel number since
+// different channels can share a name.
+func consolidationReport(channels map[providers.ChannelNumber]hdHomeRunLineupItem) map[string][]channelStream {
+	report := make(map[string][]channelStream)
+	for number, channel := range channels {
+		if len(channel.streams) > 0 {
+			report[number.String()] = channel.streams
+		}
+	}
+	return report
+}
+
+// groupHasProvider reports whether one of the pending channels in the group comes from the provider.
+func groupHasProvider(pending []pendingChannel, group []int, provider providers.Provider) bool {
+	for _, idx := range group {
+		if pending[idx].provider == provider {
+			return true
+		}
+	}
+	return false
+}
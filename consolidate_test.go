@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tellytv/telly/internal/providers"
+)
+
+func TestConsolidationName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"UK: BBC One HD", "bbcone"},
+		{"BBC ONE", "bbcone"},
+		{"BBC One H.265 FHD", "bbcone"},
+		{"BBC One |HD|", "bbcone"},
+		{"BBC One East", "bbcone"},
+		{"SHOWCASE", "showcase"},
+		{"Россия 1 HD", "россия1"},
+		{"Матч 1", "матч1"},
+		{"ČT1", "čt1"},
+		{"UK: 101", ""},
+		{"1 HD", ""},
+		{"E4", ""},
+		{"HD", ""},
+	}
+
+	for _, test := range tests {
+		if actual := consolidationName(test.name); actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestConsolidateChannelsRanking(t *testing.T) {
+	type testStream struct {
+		source  int
+		quality string
+		failed  bool
+	}
+
+	tests := []struct {
+		name       string
+		priorities []int
+		streams    []testStream
+		// expected are the sources of the streams, best first.
+		expected string
+	}{
+		{"configuration order", []int{0, 0, 0}, []testStream{{source: 1}, {source: 0}, {source: 2}}, "[a b c]"},
+		{"priority", []int{0, 5, 1}, []testStream{{source: 0}, {source: 1}, {source: 2}}, "[b c a]"},
+		{"resolution", []int{0, 0, 0}, []testStream{{source: 0, quality: providers.QualitySD}, {source: 1}, {source: 2, quality: providers.QualityFHD}}, "[c a b]"},
+		{"priority before resolution", []int{1, 0, 0}, []testStream{{source: 0, quality: providers.QualitySD}, {source: 1, quality: providers.QualityUHD}, {source: 2, quality: providers.QualityHD}}, "[a b c]"},
+		{"healthy first", []int{5, 0, 0}, []testStream{{source: 0, quality: providers.QualityUHD, failed: true}, {source: 1, quality: providers.QualitySD}, {source: 2, failed: true}}, "[b a c]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sources := make([]providers.Provider, 0, len(test.priorities))
+			for idx, priority := range test.priorities {
+				sources = append(sources, testProvider(t, providers.Configuration{Name: string(rune('a' + idx)), Priority: priority}))
+			}
+
+			l := testLineup(sources...)
+			l.prober = &streamProber{failed: make(map[string]bool)}
+
+			pending := make([]pendingChannel, 0, len(test.streams))
+			for idx, stream := range test.streams {
+				channel := testChannel(fmt.Sprintf("BBC One %d", idx), "bbc1.uk", "")
+				channel.StreamURL = fmt.Sprintf("http://%s.example.com/bbc1.ts", sources[stream.source].Name())
+				channel.Quality = stream.quality
+				l.prober.failed[channel.StreamURL] = stream.failed
+				pending = append(pending, pendingChannel{provider: sources[stream.source], channel: channel})
+			}
+
+			consolidated := l.consolidateChannels(pending)
+			if len(consolidated) != 1 {
+				t.Fatalf("expected a single channel, got %d", len(consolidated))
+			}
+			if consolidated[0].channel.Name != "BBC One 0" {
+				t.Errorf("expected the first channel to be kept, got %s", consolidated[0].channel.Name)
+			}
+
+			ranked := make([]string, 0, len(consolidated[0].streams))
+			for _, stream := range consolidated[0].streams {
+				ranked = append(ranked, stream.Provider)
+			}
+			if actual := fmt.Sprint(ranked); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestConsolidateChannelsGrouping(t *testing.T) {
+	a := testProvider(t, providers.Configuration{Name: "a"})
+	b := testProvider(t, providers.Configuration{Name: "b"})
+	l := testLineup(a, b)
+
+	pending := []pendingChannel{
+		{provider: a, channel: testChannel("UK: BBC One HD", "bbc1.uk", "1")},
+		{provider: a, channel: testChannel("BBC One", "", "")},
+		{provider: b, channel: testChannel("BBC ONE", "", "")},
+		{provider: b, channel: testChannel("BBC 1", "BBC1.UK", "")},
+		{provider: b, channel: testChannel("BBC Two", "bbc2.uk", "2")},
+		{provider: a, channel: testChannel("Россия 1", "", "")},
+		{provider: b, channel: testChannel("Матч 1", "", "")},
+		{provider: a, channel: testChannel("101", "", "")},
+		{provider: b, channel: testChannel("#101", "", "")},
+	}
+
+	consolidated := l.consolidateChannels(pending)
+
+	// Duplicates within a source stay apart, so a's second BBC One and b's second bbc1.uk are left alone.
+	// Names that reduce to digits only, like 101 and #101, say too little to merge channels by.
+	expected := map[string]int{"UK: BBC One HD": 2, "BBC One": 0, "BBC 1": 0, "BBC Two": 0, "Россия 1": 0, "Матч 1": 0, "101": 0, "#101": 0}
+	if len(consolidated) != len(expected) {
+		t.Fatalf("expected %d channels, got %d", len(expected), len(consolidated))
+	}
+	for _, entry := range consolidated {
+		if streams, ok := expected[entry.channel.Name]; !ok || len(entry.streams) != streams {
+			t.Errorf("%s: expected %d streams, got %d", entry.channel.Name, streams, len(entry.streams))
+		}
+	}
+
+	channels := l.assignChannelNumbers(consolidated)
+	report := consolidationReport(channels)
+	if len(report) != 1 || len(report["1"]) != 2 || report["1"][0].Channel != "UK: BBC One HD" {
+		t.Errorf("expected the report to list the consolidated channels by number, got %v", report)
+	}
+}
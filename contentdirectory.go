@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tellytv/telly/internal/providers"
+)
+
+// Object IDs of the ContentDirectory are paths, so the parent of every object is the path without its last part.
+// Channels are listed by source, by group-title and among the favorites, under their channel number.
+const (
+	cdRootID      = "0"
+	cdSourcesID   = "0/sources"
+	cdGroupsID    = "0/groups"
+	cdFavoritesID = "0/favorites"
+
+	cdUngrouped = "Ungrouped"
+)
+
+type didlLite struct {
+	XMLName    xml.Name        `xml:"DIDL-Lite"`
+	Xmlns      string          `xml:"xmlns,attr"`
+	XmlnsDC    string          `xml:"xmlns:dc,attr"`
+	XmlnsUPNP  string          `xml:"xmlns:upnp,attr"`
+	Containers []didlContainer `xml:"container"`
+	Items      []didlItem      `xml:"item"`
+}
+
+type didlContainer struct {
+	ID         string `xml:"id,attr"`
+	ParentID   string `xml:"parentID,attr"`
+	Restricted int    `xml:"restricted,attr"`
+	ChildCount int    `xml:"childCount,attr"`
+	Title      string `xml:"dc:title"`
+	Class      string `xml:"upnp:class"`
+}
+
+type didlItem struct {
+	ID          string  `xml:"id,attr"`
+	ParentID    string  `xml:"parentID,attr"`
+	Restricted  int     `xml:"restricted,attr"`
+	Title       string  `xml:"dc:title"`
+	Class       string  `xml:"upnp:class"`
+	ChannelNr   string  `xml:"upnp:channelNr,omitempty"`
+	ChannelName string  `xml:"upnp:channelName,omitempty"`
+	AlbumArtURI string  `xml:"upnp:albumArtURI,omitempty"`
+	Icon        string  `xml:"upnp:icon,omitempty"`
+	Res         didlRes `xml:"res"`
+}
+
+type didlRes struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	URL          string `xml:",chardata"`
+}
+
+// cdObject is a container or an item.
+type cdObject struct {
+	container *didlContainer
+	item      *didlItem
+}
+
+// contentDirectory answers the ContentDirectory actions, exposing the lineup as browsable containers.
+func contentDirectory(live *liveLineup) upnpActionHandler {
+	return func(action string, args map[string]string) ([]upnpArg, *upnpError) {
+		lineup := live.load()
+		updateID := strconv.FormatUint(uint64(uint32(lineup.LastScan.Unix())), 10)
+
+		switch action {
+		case "GetSearchCapabilities":
+			return []upnpArg{{"SearchCaps", ""}}, nil
+		case "GetSortCapabilities":
+			return []upnpArg{{"SortCaps", ""}}, nil
+		case "GetSystemUpdateID":
+			return []upnpArg{{"Id", updateID}}, nil
+		case "Browse":
+		default:
+			return nil, upnpInvalidAction
+		}
+
+		objectID := args["ObjectID"]
+		start, startErr := strconv.Atoi(defaultString(args["StartingIndex"], "0"))
+		count, countErr := strconv.Atoi(defaultString(args["RequestedCount"], "0"))
+		if startErr != nil || countErr != nil || start < 0 || count < 0 {
+			return nil, upnpInvalidArgs
+		}
+
+		var objects []cdObject
+		total := 0
+
+		switch args["BrowseFlag"] {
+		case "BrowseMetadata":
+			object, ok := lineup.cdObject(objectID)
+			if !ok {
+				return nil, upnpNoSuchObject
+			}
+			objects, total = []cdObject{object}, 1
+		case "BrowseDirectChildren":
+			children, ok := lineup.cdChildren(objectID)
+			if !ok {
+				return nil, upnpNoSuchObject
+			}
+			total = len(children)
+			if start > len(children) {
+				start = len(children)
+			}
+			end := len(children)
+			if count > 0 && start+count < end {
+				end = start + count
+			}
+			objects = children[start:end]
+		default:
+			return nil, upnpInvalidArgs
+		}
+
+		result, marshalErr := marshalDIDL(objects)
+		if marshalErr != nil {
+			log.WithError(marshalErr).Errorln("error marshalling ContentDirectory result")
+			return nil, upnpActionFailed
+		}
+
+		return []upnpArg{
+			{"Result", result},
+			{"NumberReturned", strconv.Itoa(len(objects))},
+			{"TotalMatches", strconv.Itoa(total)},
+			{"UpdateID", updateID},
+		}, nil
+	}
+}
+
+func defaultString(value, fallback string) string {
+	if strings.TrimSpace(value) == "" {
+		return fallback
+	}
+	return strings.TrimSpace(value)
+}
+
+func marshalDIDL(objects []cdObject) (string, error) {
+	didl := didlLite{
+		Xmlns:     "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/",
+		XmlnsDC:   "http://purl.org/dc/elements/1.1/",
+		XmlnsUPNP: "urn:schemas-upnp-org:metadata-1-0/upnp/",
+	}
+	for _, object := range objects {
+		if object.container != nil {
+			didl.Containers = append(didl.Containers, *object.container)
+		} else {
+			didl.Items = append(didl.Items, *object.item)
+		}
+	}
+	data, marshalErr := xml.Marshal(didl)
+	return string(data), marshalErr
+}
+
+// sortedChannels returns the channels of the lineup in channel number order.
+func (l *lineup) sortedChannels() []hdHomeRunLineupItem {
+	channels := make([]hdHomeRunLineupItem, 0, len(l.channels))
+	for _, channel := range l.channels {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].providerChannel.Number.Less(channels[j].providerChannel.Number)
+	})
+	return channels
+}
+
+// channelGroup returns the group-title of the channel, or cdUngrouped.
+func channelGroup(channel hdHomeRunLineupItem) string {
+	if group := strings.TrimSpace(channel.providerChannel.Track.Tags["group-title"]); group != "" {
+		return group
+	}
+	return cdUngrouped
+}
+
+// cdGroups returns the group-titles of the channels, naturally sorted.
+func (l *lineup) cdGroups() []string {
+	seen := make(map[string]bool)
+	groups := make([]string, 0)
+	for _, channel := range l.channels {
+		if group := channelGroup(channel); !seen[group] {
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return providers.NaturalCompare(groups[i], groups[j]) < 0 })
+	return groups
+}
+
+// cdContainerChannels returns the channels in a container that holds channels, and false if the ID isn't one.
+func (l *lineup) cdContainerChannels(id string) ([]hdHomeRunLineupItem, bool) {
+	var match func(channel hdHomeRunLineupItem) bool
+
+	switch {
+	case id == cdFavoritesID:
+		match = func(channel hdHomeRunLineupItem) bool { return channel.providerChannel.Favorite }
+	case strings.HasPrefix(id, cdSourcesID+"/"):
+		idx, idxErr := strconv.Atoi(strings.TrimPrefix(id, cdSourcesID+"/"))
+		if idxErr != nil || idx < 0 || idx >= len(l.Sources) {
+			return nil, false
+		}
+		source := l.Sources[idx]
+		match = func(channel hdHomeRunLineupItem) bool { return channel.provider == source }
+	case strings.HasPrefix(id, cdGroupsID+"/"):
+		group, unescapeErr := url.PathUnescape(strings.TrimPrefix(id, cdGroupsID+"/"))
+		if unescapeErr != nil {
+			return nil, false
+		}
+		match = func(channel hdHomeRunLineupItem) bool { return channelGroup(channel) == group }
+	default:
+		return nil, false
+	}
+
+	channels := make([]hdHomeRunLineupItem, 0)
+	for _, channel := range l.sortedChannels() {
+		if match(channel) {
+			channels = append(channels, channel)
+		}
+	}
+
+	// Groups only exist as long as a channel is in them.
+	if len(channels) == 0 && strings.HasPrefix(id, cdGroupsID+"/") {
+		return nil, false
+	}
+	return channels, true
+}
+
+func cdContainer(id, parentID, title string, childCount int) cdObject {
+	return cdObject{container: &didlContainer{
+		ID:         id,
+		ParentID:   parentID,
+		Restricted: 1,
+		ChildCount: childCount,
+		Title:      title,
+		Class:      "object.container.storageFolder",
+	}}
+}
+
+func cdChannelItem(parentID string, channel hdHomeRunLineupItem) cdObject {
+	return cdObject{item: &didlItem{
+		ID:          fmt.Sprintf("%s/%s", parentID, channel.providerChannel.Number),
+		ParentID:    parentID,
+		Restricted:  1,
+		Title:       channel.GuideName,
+		Class:       "object.item.videoItem.videoBroadcast",
+		ChannelNr:   channel.GuideNumber,
+		ChannelName: channel.GuideName,
+		AlbumArtURI: channel.providerChannel.Logo,
+		Icon:        channel.providerChannel.Logo,
+		Res: didlRes{
+			ProtocolInfo: "http-get:*:video/mpeg:*",
+			URL:          channel.URL,
+		},
+	}}
+}
+
+// cdChildren returns the objects in a container, and false if there is no such container.
+func (l *lineup) cdChildren(id string) ([]cdObject, bool) {
+	switch id {
+	case cdRootID:
+		favorites, _ := l.cdContainerChannels(cdFavoritesID)
+		return []cdObject{
+			cdContainer(cdSourcesID, cdRootID, "Sources", len(l.Sources)),
+			cdContainer(cdGroupsID, cdRootID, "Groups", len(l.cdGroups())),
+			cdContainer(cdFavoritesID, cdRootID, "Favorites", len(favorites)),
+		}, true
+
+	case cdSourcesID:
+		children := make([]cdObject, 0, len(l.Sources))
+		for idx, source := range l.Sources {
+			sourceID := fmt.Sprintf("%s/%d", cdSourcesID, idx)
+			channels, _ := l.cdContainerChannels(sourceID)
+			children = append(children, cdContainer(sourceID, cdSourcesID, source.Name(), len(channels)))
+		}
+		return children, true
+
+	case cdGroupsID:
+		groups := l.cdGroups()
+		children := make([]cdObject, 0, len(groups))
+		for _, group := range groups {
+			groupID := fmt.Sprintf("%s/%s", cdGroupsID, url.PathEscape(group))
+			channels, _ := l.cdContainerChannels(groupID)
+			children = append(children, cdContainer(groupID, cdGroupsID, group, len(channels)))
+		}
+		return children, true
+	}
+
+	channels, ok := l.cdContainerChannels(id)
+	if !ok {
+		return nil, false
+	}
+	children := make([]cdObject, 0, len(channels))
+	for _, channel := range channels {
+		children = append(children, cdChannelItem(id, channel))
+	}
+	return children, true
+}
+
+// cdObject returns the object with the ID, which is looked up among the children of its parent.
+func (l *lineup) cdObject(id string) (cdObject, bool) {
+	if id == cdRootID {
+		root := cdContainer(cdRootID, "-1", "telly", 3)
+		return root, true
+	}
+
+	slash := strings.LastIndex(id, "/")
+	if slash == -1 {
+		return cdObject{}, false
+	}
+
+	siblings, ok := l.cdChildren(id[:slash])
+	if !ok {
+		return cdObject{}, false
+	}
+	for _, sibling := range siblings {
+		if (sibling.container != nil && sibling.container.ID == id) || (sibling.item != nil && sibling.item.ID == id) {
+			return sibling, true
+		}
+	}
+	return cdObject{}, false
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/tellytv/telly/internal/providers"
+)
+
+func testContentDirectoryLineup(t *testing.T) *lineup {
+	t.Helper()
+
+	news := testProvider(t, providers.Configuration{Name: "news"})
+	sports := testProvider(t, providers.Configuration{Name: "sports"})
+	l := testLineup(news, sports)
+
+	channel := func(name, number, group string, favorite bool) *providers.ProviderChannel {
+		channel := testChannel(name, "", number)
+		channel.Favorite = favorite
+		if group != "" {
+			channel.Track.Tags["group-title"] = group
+		}
+		return channel
+	}
+
+	l.channels = l.assignChannelNumbers([]pendingChannel{
+		{provider: news, channel: channel("BBC News", "10", "News/Sport", true)},
+		{provider: news, channel: channel("CNN", "2", "News", false)},
+		{provider: news, channel: channel("Al Jazeera", "3", "", false)},
+		{provider: sports, channel: channel("Sky Sports", "5.1", "News/Sport", false)},
+		{provider: sports, channel: channel("Eurosport", "5.2", "Sport 10", true)},
+		{provider: sports, channel: channel("BT Sport", "4", "Sport 2", false)},
+	})
+	return l
+}
+
+func TestContentDirectoryObjects(t *testing.T) {
+	l := testContentDirectoryLineup(t)
+
+	// Every object found by browsing is also found by its ID, with the container it was found in as its parent.
+	visited := 0
+	var walk func(id string)
+	walk = func(id string) {
+		children, ok := l.cdChildren(id)
+		if !ok {
+			t.Fatalf("expected %s to be a container", id)
+		}
+		for _, child := range children {
+			childID, parentID := "", ""
+			if child.container != nil {
+				childID, parentID = child.container.ID, child.container.ParentID
+				if count, _ := l.cdChildren(childID); len(count) != child.container.ChildCount {
+					t.Errorf("%s: expected %d children, got %d", childID, child.container.ChildCount, len(count))
+				}
+			} else {
+				childID, parentID = child.item.ID, child.item.ParentID
+			}
+			if parentID != id {
+				t.Errorf("%s: expected parent %s, got %s", childID, id, parentID)
+			}
+
+			object, found := l.cdObject(childID)
+			if !found {
+				t.Errorf("expected to find %s by its ID", childID)
+			} else if (object.container == nil) != (child.container == nil) || (object.item != nil && *object.item != *child.item) {
+				t.Errorf("%s: expected %+v, got %+v", childID, child, object)
+			}
+
+			visited++
+			if child.container != nil {
+				walk(childID)
+			}
+		}
+	}
+	walk(cdRootID)
+
+	// 3 top level containers, 2 sources, 5 groups and 2 favorites, with every channel once per source and group.
+	if expected := 3 + 2 + 5 + 6 + 6 + 2; visited != expected {
+		t.Errorf("expected %d objects, got %d", expected, visited)
+	}
+
+	groups, _ := l.cdChildren(cdGroupsID)
+	titles := make([]string, 0, len(groups))
+	for _, group := range groups {
+		titles = append(titles, group.container.Title)
+	}
+	if strings.Join(titles, "|") != "News|News/Sport|Sport 2|Sport 10|Ungrouped" {
+		t.Errorf("expected naturally sorted groups, got %v", titles)
+	}
+
+	slashed := cdGroupsID + "/" + url.PathEscape("News/Sport")
+	channels, ok := l.cdChildren(slashed)
+	if !ok || len(channels) != 2 || channels[0].item.ID != slashed+"/5.1" || channels[1].item.ID != slashed+"/10" {
+		t.Errorf("expected the channels of a group with a slash in its name in number order, got %+v", channels)
+	}
+
+	for _, id := range []string{"", "1", "0/", "0/bogus", "0/sources/2", "0/sources/-1", "0/sources/x", "0/groups/Missing", "0/groups/%zz", "0/favorites/2", "0/sources/0/999", "-1"} {
+		if _, found := l.cdObject(id); found {
+			t.Errorf("expected %q not to be found", id)
+		}
+	}
+}
+
+func TestContentDirectoryBrowse(t *testing.T) {
+	browse := contentDirectory(newLiveLineup(testContentDirectoryLineup(t)))
+
+	tests := []struct {
+		name     string
+		args     map[string]string
+		returned string
+		total    string
+		ids      []string
+		err      *upnpError
+	}{
+		{
+			name:     "root metadata",
+			args:     map[string]string{"ObjectID": cdRootID, "BrowseFlag": "BrowseMetadata"},
+			returned: "1", total: "1",
+			ids: []string{`id="0" parentID="-1"`},
+		},
+		{
+			name:     "all children",
+			args:     map[string]string{"ObjectID": cdSourcesID + "/0", "BrowseFlag": "BrowseDirectChildren"},
+			returned: "3", total: "3",
+			ids: []string{`id="0/sources/0/2"`, `id="0/sources/0/3"`, `id="0/sources/0/10"`},
+		},
+		{
+			name:     "a page",
+			args:     map[string]string{"ObjectID": cdSourcesID + "/0", "BrowseFlag": "BrowseDirectChildren", "StartingIndex": "1", "RequestedCount": "1"},
+			returned: "1", total: "3",
+			ids: []string{`id="0/sources/0/3"`},
+		},
+		{
+			name:     "past the end",
+			args:     map[string]string{"ObjectID": cdSourcesID + "/0", "BrowseFlag": "BrowseDirectChildren", "StartingIndex": "5", "RequestedCount": "10"},
+			returned: "0", total: "3",
+		},
+		{
+			name: "no such object",
+			args: map[string]string{"ObjectID": "0/groups/Missing", "BrowseFlag": "BrowseDirectChildren"},
+			err:  upnpNoSuchObject,
+		},
+		{
+			name: "no such object metadata",
+			args: map[string]string{"ObjectID": "0/favorites/2", "BrowseFlag": "BrowseMetadata"},
+			err:  upnpNoSuchObject,
+		},
+		{
+			name: "invalid flag",
+			args: map[string]string{"ObjectID": cdRootID, "BrowseFlag": "BrowseEverything"},
+			err:  upnpInvalidArgs,
+		},
+		{
+			name: "invalid index",
+			args: map[string]string{"ObjectID": cdRootID, "BrowseFlag": "BrowseDirectChildren", "StartingIndex": "-1"},
+			err:  upnpInvalidArgs,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, browseErr := browse("Browse", test.args)
+			if browseErr != test.err {
+				t.Fatalf("expected error %v, got %v", test.err, browseErr)
+			}
+			if browseErr != nil {
+				return
+			}
+
+			values := make(map[string]string)
+			for _, arg := range result {
+				values[arg.Name] = arg.Value
+			}
+			if values["NumberReturned"] != test.returned || values["TotalMatches"] != test.total {
+				t.Errorf("expected %s of %s, got %s of %s", test.returned, test.total, values["NumberReturned"], values["TotalMatches"])
+			}
+			for _, id := range test.ids {
+				if !strings.Contains(values["Result"], id) {
+					t.Errorf("expected the result to contain %s, got %s", id, values["Result"])
+				}
+			}
+		})
+	}
+
+	if _, actionErr := browse("Search", nil); actionErr != upnpInvalidAction {
+		t.Errorf("expected unknown actions to be invalid, got %v", actionErr)
+	}
+}
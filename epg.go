@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/tellytv/telly/internal/providers"
+	"github.com/tellytv/telly/internal/xmltv"
+)
+
+// placeholderDefaultSpan is how far ahead placeholder programmes are generated when the EPG window is unlimited.
+const placeholderDefaultSpan = 7 * 24 * time.Hour
+
+// placeholderChannel returns a XMLTV channel for a lineup entry that could not be matched to any EPG.
+func placeholderChannel(channel *providers.ProviderChannel) *xmltv.Channel {
+	epgChannel := &xmltv.Channel{
+		ID:           fmt.Sprintf("telly.%s", channel.Number),
+		DisplayNames: []xmltv.CommonElement{{Value: channel.Name}},
+		LCN:          channel.Number.Major,
+	}
+
+	return epgChannel
+}
+
+// placeholderProgrammes fills the period between from and to with back to back programmes of the given length.
+// Blocks are aligned to multiples of length so that they stay the same between requests.
+func placeholderProgrammes(channel *xmltv.Channel, length time.Duration, category string, from, to time.Time) []xmltv.Programme {
+	if length <= 0 {
+		return nil
+	}
+
+	title := channel.ID
+	if len(channel.DisplayNames) > 0 {
+		title = channel.DisplayNames[0].Value
+	}
+
+	programmes := make([]xmltv.Programme, 0)
+
+	for start := from.Truncate(length); start.Before(to); start = start.Add(length) {
+		programme := xmltv.Programme{
+			Channel: channel.ID,
+			Titles:  []xmltv.CommonElement{{Value: title}},
+			Start:   &xmltv.Time{Time: start},
+			Stop:    &xmltv.Time{Time: start.Add(length)},
+		}
+
+		if category != "" {
+			programme.Categories = []xmltv.CommonElement{{Value: category}}
+		}
+
+		programmes = append(programmes, programme)
+	}
+
+	return programmes
+}
+
+// placeholderWindow returns the period placeholder programmes are generated for, bounding the open ends of the EPG window.
+func placeholderWindow(now, from, to time.Time) (time.Time, time.Time) {
+	if from.IsZero() {
+		from = now
+	}
+	if to.IsZero() {
+		to = now.Add(placeholderDefaultSpan)
+	}
+	return from, to
+}
+
+// epgChannels returns the lineup entries that have EPG data, optionally only favorites, in channel number order.
+func epgChannels(lineup *lineup, favoritesOnly bool) []providers.ProviderChannel {
+	channels := make([]providers.ProviderChannel, 0, len(lineup.channels))
+	for _, channel := range lineup.channels {
+		if favoritesOnly && !channel.providerChannel.Favorite {
+			continue
+		}
+		if channel.providerChannel.EPGChannel != nil {
+			channels = append(channels, channel.providerChannel)
+		}
+	}
+
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Number.Less(channels[j].Number) })
+
+	return channels
+}
+
+// writeEPG streams the XMLTV document for the lineup to w, as it should look at the given time.
+func writeEPG(w io.Writer, lineup *lineup, now time.Time, favoritesOnly bool) error {
+	from, to := lineup.epgWindow(now)
+	channels := epgChannels(lineup, favoritesOnly)
+
+	encoder := xmltv.NewEncoder(w)
+	if beginErr := encoder.Begin(&xmltv.TV{
+		GeneratorInfoName: namespaceWithVersion,
+		GeneratorInfoURL:  "https://github.com/tellytv/telly",
+	}); beginErr != nil {
+		return beginErr
+	}
+
+	for _, channel := range channels {
+		if encodeErr := encoder.EncodeChannel(channel.EPGChannel); encodeErr != nil {
+			return encodeErr
+		}
+	}
+
+	for _, channel := range channels {
+		programmes := channel.EPGProgrammes
+		if channel.EPGPlaceholder {
+			placeholderFrom, placeholderTo := placeholderWindow(now, from, to)
+			programmes = placeholderProgrammes(channel.EPGChannel, lineup.epgPlaceholderLength, lineup.epgPlaceholderCategory, placeholderFrom, placeholderTo)
+		}
+
+		for idx := range programmes {
+			if !programmes[idx].Airs(from, to) {
+				continue
+			}
+			if encodeErr := encoder.EncodeProgramme(&programmes[idx]); encodeErr != nil {
+				return encodeErr
+			}
+		}
+	}
+
+	return encoder.End()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tellytv/telly/internal/providers"
+	"github.com/tellytv/telly/internal/xmltv"
+)
+
+func TestPlaceholderChannel(t *testing.T) {
+	channel := placeholderChannel(&providers.ProviderChannel{Name: "Unmatched Sports", Number: providers.ChannelNumber{Major: 42}})
+
+	if channel.ID != "telly.42" {
+		t.Errorf("expected ID telly.42, got %s", channel.ID)
+	}
+	if len(channel.DisplayNames) != 1 || channel.DisplayNames[0].Value != "Unmatched Sports" {
+		t.Errorf("expected the channel name as display name, got %v", channel.DisplayNames)
+	}
+	if channel.LCN != 42 {
+		t.Errorf("expected LCN 42, got %d", channel.LCN)
+	}
+}
+
+func TestPlaceholderProgrammes(t *testing.T) {
+	channel := &xmltv.Channel{ID: "telly.42", DisplayNames: []xmltv.CommonElement{{Value: "Unmatched Sports"}}}
+	from := time.Date(2018, time.August, 1, 20, 15, 0, 0, time.UTC)
+	to := time.Date(2018, time.August, 1, 23, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		length   time.Duration
+		category string
+		starts   []int
+	}{
+		{"hourly blocks aligned to the hour", time.Hour, "", []int{20, 21, 22}},
+		{"two hour blocks aligned to even hours", 2 * time.Hour, "Sports", []int{20, 22}},
+		{"no length", 0, "", nil},
+	}
+
+	for _, test := range tests {
+		programmes := placeholderProgrammes(channel, test.length, test.category, from, to)
+		if len(programmes) != len(test.starts) {
+			t.Errorf("%s: expected %d programmes, got %d", test.name, len(test.starts), len(programmes))
+			continue
+		}
+
+		for idx, programme := range programmes {
+			start := time.Date(2018, time.August, 1, test.starts[idx], 0, 0, 0, time.UTC)
+			if !programme.Start.Time.Equal(start) || !programme.Stop.Time.Equal(start.Add(test.length)) {
+				t.Errorf("%s: expected programme %d to run from %s, got %s to %s", test.name, idx, start, programme.Start.Time, programme.Stop.Time)
+			}
+			if programme.Channel != "telly.42" || len(programme.Titles) != 1 || programme.Titles[0].Value != "Unmatched Sports" {
+				t.Errorf("%s: expected programme %d to be titled after its channel, got %v on %s", test.name, idx, programme.Titles, programme.Channel)
+			}
+			if hasCategory := len(programme.Categories) > 0; hasCategory != (test.category != "") || (hasCategory && programme.Categories[0].Value != test.category) {
+				t.Errorf("%s: expected category %q, got %v", test.name, test.category, programme.Categories)
+			}
+		}
+	}
+}
+
+func TestPlaceholderWindow(t *testing.T) {
+	now := time.Date(2018, time.August, 1, 20, 0, 0, 0, time.UTC)
+	past := now.Add(-6 * time.Hour)
+	future := now.Add(24 * time.Hour)
+
+	tests := []struct {
+		name         string
+		from, to     time.Time
+		expectedFrom time.Time
+		expectedTo   time.Time
+	}{
+		{"unlimited window", time.Time{}, time.Time{}, now, now.Add(placeholderDefaultSpan)},
+		{"bounded window", past, future, past, future},
+		{"only a past window", past, time.Time{}, past, now.Add(placeholderDefaultSpan)},
+	}
+
+	for _, test := range tests {
+		from, to := placeholderWindow(now, test.from, test.to)
+		if !from.Equal(test.expectedFrom) || !to.Equal(test.expectedTo) {
+			t.Errorf("%s: expected %s to %s, got %s to %s", test.name, test.expectedFrom, test.expectedTo, from, to)
+		}
+	}
+}
+
+// lcnElement matches the lcn elements telly adds to channels, which are the only part of its output not in xmltv.dtd.
+var lcnElement = regexp.MustCompile(`\s*<lcn>[0-9]+</lcn>`)
+
+func TestWriteEPGValidatesAgainstDTD(t *testing.T) {
+	xmllint, lookErr := exec.LookPath("xmllint")
+	if lookErr != nil {
+		t.Skip("xmllint is not installed")
+	}
+
+	now := time.Date(2018, time.August, 1, 20, 0, 0, 0, time.UTC)
+
+	matched := providers.ProviderChannel{
+		Name:   "BBC One",
+		Number: providers.ChannelNumber{Major: 1},
+		EPGChannel: &xmltv.Channel{
+			ID:           "bbc1",
+			DisplayNames: []xmltv.CommonElement{{Value: "BBC One"}},
+			LCN:          1,
+		},
+		EPGProgrammes: []xmltv.Programme{{
+			Channel:      "bbc1",
+			Titles:       []xmltv.CommonElement{{Value: "News & Weather", Lang: "en"}},
+			Descriptions: []xmltv.CommonElement{{Value: "The latest <news>.", Lang: "en"}},
+			Start:        &xmltv.Time{Time: now},
+			Stop:         &xmltv.Time{Time: now.Add(30 * time.Minute)},
+		}},
+	}
+
+	unmatched := providers.ProviderChannel{Name: "Unmatched Sports", Number: providers.ChannelNumber{Major: 2}}
+	unmatched.EPGChannel = placeholderChannel(&unmatched)
+	unmatched.EPGPlaceholder = true
+
+	lineup := &lineup{
+		channels: map[providers.ChannelNumber]hdHomeRunLineupItem{
+			matched.Number:   {providerChannel: matched},
+			unmatched.Number: {providerChannel: unmatched},
+		},
+		epgFutureWindow:        6 * time.Hour,
+		epgPlaceholder:         true,
+		epgPlaceholderLength:   time.Hour,
+		epgPlaceholderCategory: "Sports",
+	}
+
+	buf := &bytes.Buffer{}
+	if writeErr := writeEPG(buf, lineup, now, false); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+
+	document := buf.String()
+	for _, expected := range []string{`<channel id="telly.2">`, `<programme start="20180801230000 +0000" stop="20180802000000 +0000" channel="telly.2">`, "<lcn>1</lcn>"} {
+		if !strings.Contains(document, expected) {
+			t.Errorf("expected the guide to contain %s:\n%s", expected, document)
+		}
+	}
+
+	dtd, dtdErr := filepath.Abs(filepath.Join("internal", "xmltv", "xmltv.dtd"))
+	if dtdErr != nil {
+		t.Fatal(dtdErr)
+	}
+
+	out, outErr := ioutil.TempFile("", "telly-epg")
+	if outErr != nil {
+		t.Fatal(outErr)
+	}
+	defer os.Remove(out.Name())
+
+	if _, writeErr := out.WriteString(lcnElement.ReplaceAllString(document, "")); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	out.Close()
+
+	if output, lintErr := exec.Command(xmllint, "--noout", "--dtdvalid", dtd, out.Name()).CombinedOutput(); lintErr != nil {
+		t.Errorf("guide is not valid: %s\n%s", lintErr, output)
+	}
+}
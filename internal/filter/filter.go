@@ -0,0 +1,252 @@
+// Package filter implements the expression language used to decide which playlist tracks make it into the lineup.
+//
+// An expression compares fields of a track and combines comparisons with and, or, not and parentheses:
+//
+//	group-title in ["Sports", "Movies"] and not name like "*+1*"
+//	name =~ "(?i)^uk:" or tvg-id == "bbc1.uk"
+//	duration > 0
+//
+// The fields name, url, duration and raw refer to the track itself, any other field is a tag of the track.
+// A tag that clashes with one of those can be reached as tag.name. Missing tags are empty strings.
+// Strings are quoted with " or ', inside them a backslash only escapes the quote and itself.
+//
+// The operators are == and != (equality), =~ and !~ (regular expression), like (glob with * and ?),
+// in (list membership) and <, <=, >, >= (numeric). Equality, like and in ignore case.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	m3u "github.com/tellytv/telly/internal/m3uplus"
+)
+
+// Expression is a compiled filter expression.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression, compiling all regular expressions and globs up front.
+func Compile(source string) (*Expression, error) {
+	tokens, lexErr := lex(source)
+	if lexErr != nil {
+		return nil, lexErr
+	}
+
+	p := &parser{tokens: tokens}
+	root, parseErr := p.parseOr()
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %s at position %d", p.peek().value, p.peek().pos)
+	}
+
+	return &Expression{source: source, root: root}, nil
+}
+
+// MustCompile is like Compile but panics if the expression is invalid.
+func MustCompile(source string) *Expression {
+	expr, err := Compile(source)
+	if err != nil {
+		panic(fmt.Sprintf("filter: Compile(%q): %s", source, err))
+	}
+	return expr
+}
+
+// HasTag returns an expression matching tracks that have the tag, even if its value is empty.
+// The tag name is case sensitive, unlike tags in compiled expressions.
+func HasTag(tag string) *Expression {
+	return &Expression{source: fmt.Sprintf("has tag %s", Quote(tag)), root: taggedNode{tag: tag}}
+}
+
+// TagIn returns an expression matching tracks that have the tag with exactly one of the values, comparing case
+// sensitively like the IncludeOnly setting always did.
+func TagIn(tag string, values []string) *Expression {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, Quote(value))
+	}
+	source := fmt.Sprintf("tag %s is exactly one of [%s]", Quote(tag), strings.Join(quoted, ", "))
+	return &Expression{source: source, root: taggedNode{tag: tag, values: values, exact: true}}
+}
+
+// Match reports whether the track satisfies the expression.
+func (e *Expression) Match(track m3u.Track) bool {
+	return e.root.eval(track)
+}
+
+// String returns the source of the expression.
+func (e *Expression) String() string {
+	return e.source
+}
+
+type node interface {
+	eval(track m3u.Track) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(track m3u.Track) bool { return n.left.eval(track) && n.right.eval(track) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(track m3u.Track) bool { return n.left.eval(track) || n.right.eval(track) }
+
+type notNode struct{ inner node }
+
+func (n notNode) eval(track m3u.Track) bool { return !n.inner.eval(track) }
+
+// taggedNode checks the presence of a tag, and with exact its value as well, without folding case.
+type taggedNode struct {
+	tag    string
+	values []string
+	exact  bool
+}
+
+func (n taggedNode) eval(track m3u.Track) bool {
+	value, ok := track.Tags[n.tag]
+	if !ok || !n.exact {
+		return ok
+	}
+	for _, want := range n.values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+// comparison compares a single field of the track.
+type comparison struct {
+	field string
+	test  func(value string) bool
+}
+
+func (c comparison) eval(track m3u.Track) bool {
+	return c.test(fieldValue(track, c.field))
+}
+
+// fieldValue returns the value of a field of the track as a string.
+func fieldValue(track m3u.Track, field string) string {
+	switch strings.ToLower(field) {
+	case "name":
+		return track.Name
+	case "url":
+		if track.URI == nil {
+			return ""
+		}
+		return track.URI.String()
+	case "duration":
+		return strconv.FormatFloat(track.Length, 'f', -1, 64)
+	case "raw":
+		return track.Raw
+	}
+
+	tag := field
+	if strings.HasPrefix(strings.ToLower(tag), "tag.") {
+		tag = tag[len("tag."):]
+	}
+	if value, ok := track.Tags[tag]; ok {
+		return value
+	}
+	for key, value := range track.Tags {
+		if strings.EqualFold(key, tag) {
+			return value
+		}
+	}
+	return ""
+}
+
+// newComparison returns the comparison for field op values.
+func newComparison(field, op string, values []string) (node, error) {
+	if op != "in" && len(values) != 1 {
+		return nil, fmt.Errorf("%s compares with a single value, not a list", op)
+	}
+
+	switch op {
+	case "==", "!=":
+		want := values[0]
+		equal := func(value string) bool { return strings.EqualFold(value, want) }
+		if op == "!=" {
+			return comparison{field, func(value string) bool { return !equal(value) }}, nil
+		}
+		return comparison{field, equal}, nil
+
+	case "=~", "!~":
+		re, reErr := regexp.Compile(values[0])
+		if reErr != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %s", values[0], reErr)
+		}
+		if op == "!~" {
+			return comparison{field, func(value string) bool { return !re.MatchString(value) }}, nil
+		}
+		return comparison{field, re.MatchString}, nil
+
+	case "like":
+		re, reErr := regexp.Compile(globToRegex(values[0]))
+		if reErr != nil {
+			return nil, fmt.Errorf("invalid glob %q: %s", values[0], reErr)
+		}
+		return comparison{field, re.MatchString}, nil
+
+	case "in":
+		return comparison{field, func(value string) bool {
+			for _, want := range values {
+				if strings.EqualFold(value, want) {
+					return true
+				}
+			}
+			return false
+		}}, nil
+
+	case "<", "<=", ">", ">=":
+		want, numErr := strconv.ParseFloat(values[0], 64)
+		if numErr != nil {
+			return nil, fmt.Errorf("%s needs a number, got %q", op, values[0])
+		}
+		return comparison{field, func(value string) bool {
+			have, haveErr := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if haveErr != nil {
+				return false
+			}
+			switch op {
+			case "<":
+				return have < want
+			case "<=":
+				return have <= want
+			case ">":
+				return have > want
+			}
+			return have >= want
+		}}, nil
+	}
+
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// globToRegex converts a glob with * and ? wildcards to an anchored, case insensitive regular expression.
+func globToRegex(glob string) string {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// Quote returns value as a string literal that reads back as value.
+func Quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
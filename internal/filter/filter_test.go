@@ -0,0 +1,127 @@
+package filter
+
+import (
+	"net/url"
+	"testing"
+
+	m3u "github.com/tellytv/telly/internal/m3uplus"
+)
+
+func testTrack() m3u.Track {
+	uri, _ := url.Parse("http://example.com/live/1234.ts")
+	return m3u.Track{
+		Name:   "UK: BBC One HD",
+		Length: -1,
+		URI:    uri,
+		Tags: map[string]string{
+			"tvg-id":      "bbc1.uk",
+			"group-title": "UK Entertainment",
+			"Name":        "BBC One",
+		},
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`tvg-id == "BBC1.UK"`, true},
+		{`tvg-id != 'bbc1.uk'`, false},
+		{`name =~ "^UK: "`, true},
+		{`name !~ "(?i)\bsd$"`, true},
+		{`name like "uk:*hd"`, true},
+		{`group-title in ["Sports", "UK Entertainment"]`, true},
+		{`group-title in []`, false},
+		{`duration < 0 and url like "*.ts"`, true},
+		{`not (duration >= 0 || missing-tag != "")`, true},
+		{`tag.name == "BBC One" and name == "UK: BBC One HD"`, true},
+		{`name == "it's \"quoted\""`, false},
+	}
+
+	for _, test := range tests {
+		expr, err := Compile(test.expr)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+		if actual := expr.Match(testTrack()); actual != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.expr, test.expected, actual)
+		}
+	}
+}
+
+func TestInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`name`,
+		`name ==`,
+		`name == "unterminated`,
+		`name =~ "("`,
+		`duration > "long"`,
+		`(name == "a"`,
+		`name == ["a", "b"]`,
+		`name == "a" "b"`,
+		`name matches "a"`,
+	} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("expected %q to be invalid", expr)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	value := `\d+ "quoted"`
+	expr := MustCompile("name == " + Quote(value))
+	track := testTrack()
+	track.Name = value
+	if !expr.Match(track) {
+		t.Errorf("expected %s to read back as %s", Quote(value), value)
+	}
+}
+
+func TestRulesFirstMatchDecides(t *testing.T) {
+	rules := Rules{
+		{Name: "no timeshift", Include: false, Expression: MustCompile(`name like "*+1*"`)},
+		{Name: "uk", Include: true, Expression: MustCompile(`name =~ "^UK:"`)},
+	}
+
+	track := testTrack()
+	if admitted, rule := rules.Evaluate(track); !admitted || rule.Name != "uk" {
+		t.Errorf("expected the uk rule to admit the track, got %t by %v", admitted, rule)
+	}
+
+	track.Name = "UK: BBC One +1"
+	if admitted, rule := rules.Evaluate(track); admitted || rule.Name != "no timeshift" {
+		t.Errorf("expected the timeshift rule to reject the track, got %t by %v", admitted, rule)
+	}
+
+	track.Name = "US: CNN"
+	if admitted, rule := rules.Evaluate(track); admitted || rule != nil {
+		t.Errorf("expected unmatched tracks to be rejected when there are include rules, got %t by %v", admitted, rule)
+	}
+
+	if admitted, _ := rules[:1].Evaluate(track); !admitted {
+		t.Errorf("expected unmatched tracks to be admitted when there are only exclude rules")
+	}
+}
+
+func TestTagExpressions(t *testing.T) {
+	tests := []struct {
+		expr     *Expression
+		expected bool
+	}{
+		{HasTag("tvg-id"), true},
+		{HasTag("TVG-ID"), false},
+		{HasTag("missing-tag"), false},
+		{TagIn("group-title", []string{"Sports", "UK Entertainment"}), true},
+		{TagIn("group-title", []string{"uk entertainment"}), false},
+		{TagIn("missing-tag", []string{""}), false},
+	}
+
+	for _, test := range tests {
+		if actual := test.expr.Match(testTrack()); actual != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.expr, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenString
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+	tokenComma
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// operators are listed longest first so that <= isn't read as <.
+var operators = []string{"==", "!=", "=~", "!~", "<=", ">=", "&&", "||", "<", ">", "!"}
+
+// lex splits an expression into tokens.
+func lex(source string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(source)
+
+	for pos := 0; pos < len(runes); {
+		r := runes[pos]
+
+		switch {
+		case unicode.IsSpace(r):
+			pos++
+
+		case r == '(' || r == ')' || r == '[' || r == ']' || r == ',':
+			kind := map[rune]tokenKind{'(': tokenLParen, ')': tokenRParen, '[': tokenLBracket, ']': tokenRBracket, ',': tokenComma}[r]
+			tokens = append(tokens, token{kind: kind, value: string(r), pos: pos})
+			pos++
+
+		case r == '"' || r == '\'':
+			value, end, strErr := lexString(runes, pos)
+			if strErr != nil {
+				return nil, strErr
+			}
+			tokens = append(tokens, token{kind: tokenString, value: value, pos: pos})
+			pos = end
+
+		case isIdentRune(r):
+			start := pos
+			for pos < len(runes) && isIdentRune(runes[pos]) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, value: string(runes[start:pos]), pos: start})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(string(runes[pos:]), op) {
+					tokens = append(tokens, token{kind: tokenOperator, value: op, pos: pos})
+					pos += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at position %d", r, pos)
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// lexString reads a quoted string starting at pos, returning its value and the position after the closing quote.
+// A backslash escapes the quote character and itself, anything else is kept as is so regular expressions read naturally.
+func lexString(runes []rune, pos int) (string, int, error) {
+	quote := runes[pos]
+	var b strings.Builder
+
+	for idx := pos + 1; idx < len(runes); idx++ {
+		switch {
+		case runes[idx] == '\\' && idx+1 < len(runes) && (runes[idx+1] == quote || runes[idx+1] == '\\'):
+			b.WriteRune(runes[idx+1])
+			idx++
+		case runes[idx] == quote:
+			return b.String(), idx + 1, nil
+		default:
+			b.WriteRune(runes[idx])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string starting at position %d", pos)
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.'
+}
+
+// parser is a recursive descent parser over the tokens of an expression.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{value: "end of expression", pos: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() (token, error) {
+	if p.done() {
+		return token{}, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+// isKeyword reports whether the next token is the keyword or operator, in any case.
+func (p *parser) isKeyword(words ...string) bool {
+	if p.done() {
+		return false
+	}
+	t := p.peek()
+	if t.kind != tokenIdent && t.kind != tokenOperator {
+		return false
+	}
+	for _, word := range words {
+		if strings.EqualFold(t.value, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or", "||") {
+		p.pos++
+		right, rightErr := p.parseAnd()
+		if rightErr != nil {
+			return nil, rightErr
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and", "&&") {
+		p.pos++
+		right, rightErr := p.parseNot()
+		if rightErr != nil {
+			return nil, rightErr
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isKeyword("not", "!") {
+		p.pos++
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if t.kind == tokenLParen {
+		inner, innerErr := p.parseOr()
+		if innerErr != nil {
+			return nil, innerErr
+		}
+		if closing, closeErr := p.next(); closeErr != nil || closing.kind != tokenRParen {
+			return nil, fmt.Errorf("missing ) for ( at position %d", t.pos)
+		}
+		return inner, nil
+	}
+
+	if t.kind != tokenIdent {
+		return nil, fmt.Errorf("expected a field name at position %d, got %s", t.pos, t.value)
+	}
+	field := t.value
+
+	op, opErr := p.next()
+	if opErr != nil {
+		return nil, fmt.Errorf("expected an operator after %s", t.value)
+	}
+	opValue := strings.ToLower(op.value)
+	if op.kind == tokenIdent && opValue != "like" && opValue != "in" {
+		return nil, fmt.Errorf("expected an operator at position %d, got %s", op.pos, op.value)
+	}
+
+	values, valuesErr := p.parseValues()
+	if valuesErr != nil {
+		return nil, valuesErr
+	}
+
+	comparisonNode, comparisonErr := newComparison(field, opValue, values)
+	if comparisonErr != nil {
+		return nil, fmt.Errorf("%s at position %d", comparisonErr, op.pos)
+	}
+	return comparisonNode, nil
+}
+
+// parseValues reads a single value or a bracketed list of values. Unquoted values such as numbers are allowed.
+func (p *parser) parseValues() ([]string, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t.kind {
+	case tokenString, tokenIdent:
+		return []string{t.value}, nil
+	case tokenLBracket:
+		values := make([]string, 0)
+		for {
+			item, itemErr := p.next()
+			if itemErr != nil {
+				return nil, fmt.Errorf("missing ] for [ at position %d", t.pos)
+			}
+			switch {
+			case item.kind == tokenRBracket && len(values) == 0:
+				return values, nil
+			case item.kind == tokenString || item.kind == tokenIdent:
+				values = append(values, item.value)
+			default:
+				return nil, fmt.Errorf("expected a value at position %d, got %s", item.pos, item.value)
+			}
+
+			sep, sepErr := p.next()
+			if sepErr != nil {
+				return nil, fmt.Errorf("missing ] for [ at position %d", t.pos)
+			}
+			if sep.kind == tokenRBracket {
+				return values, nil
+			}
+			if sep.kind != tokenComma {
+				return nil, fmt.Errorf("expected , or ] at position %d, got %s", sep.pos, sep.value)
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("expected a value at position %d, got %s", t.pos, t.value)
+}
@@ -0,0 +1,42 @@
+package filter
+
+import (
+	"fmt"
+
+	m3u "github.com/tellytv/telly/internal/m3uplus"
+)
+
+// Rule admits or rejects the tracks its expression matches.
+type Rule struct {
+	Name       string
+	Include    bool
+	Expression *Expression
+}
+
+// String describes the rule for logs and dry runs.
+func (r *Rule) String() string {
+	action := "exclude"
+	if r.Include {
+		action = "include"
+	}
+	if r.Name != "" {
+		return fmt.Sprintf("%s %q (%s)", action, r.Name, r.Expression)
+	}
+	return fmt.Sprintf("%s (%s)", action, r.Expression)
+}
+
+// Rules are evaluated in order and the first rule that matches a track decides.
+type Rules []Rule
+
+// Evaluate reports whether the track is admitted and which rule decided, nil if no rule matched.
+// A track no rule matches is admitted unless there are include rules, in which case it had to match one of them.
+func (r Rules) Evaluate(track m3u.Track) (bool, *Rule) {
+	hasInclude := false
+	for idx := range r {
+		if r[idx].Expression.Match(track) {
+			return r[idx].Include, &r[idx]
+		}
+		hasInclude = hasInclude || r[idx].Include
+	}
+	return !hasInclude, nil
+}
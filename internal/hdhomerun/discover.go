@@ -0,0 +1,253 @@
+// Package hdhomerun implements the SiliconDust HDHomeRun discovery protocol, which HDHomeRun clients use to find
+// tuners on the local network by broadcasting a request to UDP port 65001.
+//
+// A packet is a big endian type and payload length, the payload, and a little endian CRC32 of everything before it.
+// The payload is a list of tag, length, value entries.
+package hdhomerun
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DiscoverPort is the UDP port discovery requests are sent to.
+const DiscoverPort = 65001
+
+// Packet types.
+const (
+	TypeDiscoverRequest uint16 = 0x0002
+	TypeDiscoverReply   uint16 = 0x0003
+)
+
+// Tags used in discovery packets.
+const (
+	TagDeviceType     byte = 0x01
+	TagDeviceID       byte = 0x02
+	TagTunerCount     byte = 0x10
+	TagLineupURL      byte = 0x27
+	TagBaseURL        byte = 0x2A
+	TagDeviceAuthText byte = 0x2B
+)
+
+// Device types, a request for the wildcard type or ID is answered by every device.
+const (
+	DeviceTypeTuner    uint32 = 0x00000001
+	DeviceTypeWildcard uint32 = 0xFFFFFFFF
+	DeviceIDWildcard   uint32 = 0xFFFFFFFF
+)
+
+// Packet is a decoded discovery packet. Tags keeps the value of each tag, the last one wins if a tag is repeated.
+type Packet struct {
+	Type uint16
+	Tags map[byte][]byte
+}
+
+// ErrChecksum is returned when a packet is damaged.
+var ErrChecksum = errors.New("hdhomerun: packet checksum mismatch")
+
+// Decode parses a packet and verifies its checksum.
+func Decode(data []byte) (*Packet, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("hdhomerun: packet too short (%d bytes)", len(data))
+	}
+
+	payloadLength := int(binary.BigEndian.Uint16(data[2:4]))
+	if len(data) != 4+payloadLength+4 {
+		return nil, fmt.Errorf("hdhomerun: packet is %d bytes but says its payload is %d bytes", len(data), payloadLength)
+	}
+
+	body, checksum := data[:4+payloadLength], data[4+payloadLength:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(checksum) {
+		return nil, ErrChecksum
+	}
+
+	packet := &Packet{
+		Type: binary.BigEndian.Uint16(data[0:2]),
+		Tags: make(map[byte][]byte),
+	}
+
+	payload := body[4:]
+	for len(payload) > 0 {
+		if len(payload) < 2 {
+			return nil, errors.New("hdhomerun: truncated tag")
+		}
+		tag := payload[0]
+
+		// Lengths over 127 take a second byte, holding the bits above the lowest seven.
+		length, header := int(payload[1]), 2
+		if length&0x80 != 0 {
+			if len(payload) < 3 {
+				return nil, errors.New("hdhomerun: truncated tag length")
+			}
+			length, header = (length&0x7F)|int(payload[2])<<7, 3
+		}
+
+		if len(payload) < header+length {
+			return nil, fmt.Errorf("hdhomerun: tag 0x%02X is longer than the packet", tag)
+		}
+		packet.Tags[tag] = payload[header : header+length]
+		payload = payload[header+length:]
+	}
+
+	return packet, nil
+}
+
+// Uint32 returns the value of a four byte tag.
+func (p *Packet) Uint32(tag byte) (uint32, bool) {
+	value, ok := p.Tags[tag]
+	if !ok || len(value) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(value), true
+}
+
+// packetWriter builds a packet.
+type packetWriter struct {
+	payload bytes.Buffer
+}
+
+func (w *packetWriter) writeTag(tag byte, value []byte) {
+	w.payload.WriteByte(tag)
+	if len(value) > 127 {
+		w.payload.WriteByte(byte(len(value)&0x7F) | 0x80)
+		w.payload.WriteByte(byte(len(value) >> 7))
+	} else {
+		w.payload.WriteByte(byte(len(value)))
+	}
+	w.payload.Write(value)
+}
+
+func (w *packetWriter) writeUint32(tag byte, value uint32) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, value)
+	w.writeTag(tag, buf)
+}
+
+func (w *packetWriter) bytes(packetType uint16) []byte {
+	packet := make([]byte, 4, 4+w.payload.Len()+4)
+	binary.BigEndian.PutUint16(packet[0:2], packetType)
+	binary.BigEndian.PutUint16(packet[2:4], uint16(w.payload.Len()))
+	packet = append(packet, w.payload.Bytes()...)
+
+	checksum := make([]byte, 4)
+	binary.LittleEndian.PutUint32(checksum, crc32.ChecksumIEEE(packet))
+	return append(packet, checksum...)
+}
+
+// DiscoverRequest returns a request for devices of the given type and ID, which may be wildcards.
+func DiscoverRequest(deviceType, deviceID uint32) []byte {
+	w := &packetWriter{}
+	w.writeUint32(TagDeviceType, deviceType)
+	w.writeUint32(TagDeviceID, deviceID)
+	return w.bytes(TypeDiscoverRequest)
+}
+
+// Device describes the tuner advertised in discovery replies.
+type Device struct {
+	ID         uint32
+	TunerCount int
+	BaseURL    string
+	LineupURL  string
+	DeviceAuth string
+}
+
+// DiscoverReply returns the reply describing the device.
+func (d Device) DiscoverReply() []byte {
+	w := &packetWriter{}
+	w.writeUint32(TagDeviceType, DeviceTypeTuner)
+	w.writeUint32(TagDeviceID, d.ID)
+	w.writeTag(TagTunerCount, []byte{byte(d.TunerCount)})
+	w.writeTag(TagBaseURL, []byte(d.BaseURL))
+	w.writeTag(TagLineupURL, []byte(d.LineupURL))
+	if d.DeviceAuth != "" {
+		w.writeTag(TagDeviceAuthText, []byte(d.DeviceAuth))
+	}
+	return w.bytes(TypeDiscoverReply)
+}
+
+// Answer returns the reply to a discovery request, or false if the request isn't one or is meant for another device.
+func (d Device) Answer(request *Packet) ([]byte, bool) {
+	if request.Type != TypeDiscoverRequest {
+		return nil, false
+	}
+	if deviceType, ok := request.Uint32(TagDeviceType); ok && deviceType != DeviceTypeWildcard && deviceType != DeviceTypeTuner {
+		return nil, false
+	}
+	if deviceID, ok := request.Uint32(TagDeviceID); ok && deviceID != DeviceIDWildcard && deviceID != d.ID {
+		return nil, false
+	}
+	return d.DiscoverReply(), true
+}
+
+// Serve answers the discovery requests arriving on conn for any of the devices, until conn is closed.
+// Damaged or unrelated packets are ignored, errors replying are passed to onError if it isn't nil.
+func Serve(conn net.PacketConn, onError func(error), devices ...Device) error {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, readErr := conn.ReadFrom(buf)
+		if readErr != nil {
+			return readErr
+		}
+
+		request, decodeErr := Decode(buf[:n])
+		if decodeErr != nil {
+			continue
+		}
+
+		for _, device := range devices {
+			reply, ok := device.Answer(request)
+			if !ok {
+				continue
+			}
+
+			if _, writeErr := conn.WriteTo(reply, addr); writeErr != nil && onError != nil {
+				onError(fmt.Errorf("hdhomerun: replying to %s: %s", addr, writeErr))
+			}
+		}
+	}
+}
+
+// ParseDeviceID parses an 8 digit hexadecimal device ID.
+func ParseDeviceID(id string) (uint32, error) {
+	id = strings.TrimSpace(id)
+	if len(id) != 8 {
+		return 0, fmt.Errorf("hdhomerun: device ID %q must be 8 hexadecimal digits", id)
+	}
+	value, parseErr := strconv.ParseUint(id, 16, 32)
+	if parseErr != nil {
+		return 0, fmt.Errorf("hdhomerun: device ID %q must be 8 hexadecimal digits", id)
+	}
+	return uint32(value), nil
+}
+
+// deviceIDLookup is the table SiliconDust uses to checksum device IDs.
+var deviceIDLookup = [16]uint32{0xA, 0x5, 0xF, 0x6, 0x7, 0xC, 0x1, 0xB, 0x9, 0x2, 0x8, 0xD, 0x4, 0x3, 0xE, 0x0}
+
+// ValidDeviceID reports whether the device ID has a valid checksum. Some clients ignore devices whose ID doesn't.
+func ValidDeviceID(id uint32) bool {
+	return deviceIDChecksum(id) == 0
+}
+
+// WithValidChecksum returns the device ID with its last digit, which is the checksum, replaced by a valid one.
+func WithValidChecksum(id uint32) uint32 {
+	id &^= 0x0F
+	return id | deviceIDChecksum(id)
+}
+
+func deviceIDChecksum(id uint32) uint32 {
+	checksum := uint32(0)
+	for shift := uint(28); ; shift -= 8 {
+		checksum ^= deviceIDLookup[(id>>shift)&0x0F]
+		checksum ^= (id >> (shift - 4)) & 0x0F
+		if shift == 4 {
+			break
+		}
+	}
+	return checksum
+}
@@ -0,0 +1,124 @@
+package hdhomerun
+
+import (
+	"strings"
+	"testing"
+)
+
+func testDevice() Device {
+	return Device{
+		ID:         0x12345678,
+		TunerCount: 2,
+		BaseURL:    "http://192.168.1.10:6077",
+		LineupURL:  "http://192.168.1.10:6077/lineup.json",
+		DeviceAuth: "telly123",
+	}
+}
+
+func TestDiscoverReplyRoundTrip(t *testing.T) {
+	device := testDevice()
+	device.BaseURL = "http://192.168.1.10:6077/" + strings.Repeat("a", 200)
+
+	packet, decodeErr := Decode(device.DiscoverReply())
+	if decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+
+	if packet.Type != TypeDiscoverReply {
+		t.Errorf("expected a discover reply, got type 0x%04X", packet.Type)
+	}
+	if id, _ := packet.Uint32(TagDeviceID); id != device.ID {
+		t.Errorf("expected device ID %08X, got %08X", device.ID, id)
+	}
+	if deviceType, _ := packet.Uint32(TagDeviceType); deviceType != DeviceTypeTuner {
+		t.Errorf("expected a tuner, got device type %08X", deviceType)
+	}
+	if count := packet.Tags[TagTunerCount]; len(count) != 1 || count[0] != 2 {
+		t.Errorf("expected 2 tuners, got %v", count)
+	}
+	if baseURL := string(packet.Tags[TagBaseURL]); baseURL != device.BaseURL {
+		t.Errorf("expected base URL %s, got %s", device.BaseURL, baseURL)
+	}
+	if lineupURL := string(packet.Tags[TagLineupURL]); lineupURL != device.LineupURL {
+		t.Errorf("expected lineup URL %s, got %s", device.LineupURL, lineupURL)
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	device := testDevice()
+
+	tests := []struct {
+		deviceType uint32
+		deviceID   uint32
+		answered   bool
+	}{
+		{DeviceTypeWildcard, DeviceIDWildcard, true},
+		{DeviceTypeTuner, DeviceIDWildcard, true},
+		{DeviceTypeTuner, device.ID, true},
+		{DeviceTypeTuner, 0x87654321, false},
+		{0x00000005, DeviceIDWildcard, false},
+	}
+
+	for _, test := range tests {
+		request, decodeErr := Decode(DiscoverRequest(test.deviceType, test.deviceID))
+		if decodeErr != nil {
+			t.Fatal(decodeErr)
+		}
+		if _, answered := device.Answer(request); answered != test.answered {
+			t.Errorf("request for type %08X and ID %08X: expected answered to be %t", test.deviceType, test.deviceID, test.answered)
+		}
+	}
+
+	reply, _ := Decode(device.DiscoverReply())
+	if _, answered := device.Answer(reply); answered {
+		t.Errorf("expected replies from other devices to be ignored")
+	}
+}
+
+func TestDecodeRejectsDamagedPackets(t *testing.T) {
+	request := DiscoverRequest(DeviceTypeTuner, DeviceIDWildcard)
+
+	damaged := append([]byte{}, request...)
+	damaged[6] ^= 0xFF
+	if _, err := Decode(damaged); err != ErrChecksum {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+
+	if _, err := Decode(request[:len(request)-1]); err == nil {
+		t.Errorf("expected a truncated packet to be rejected")
+	}
+}
+
+func TestDeviceID(t *testing.T) {
+	if id, err := ParseDeviceID("1234ABCD"); err != nil || id != 0x1234ABCD {
+		t.Errorf("expected 1234ABCD to parse, got %08X, %v", id, err)
+	}
+	for _, invalid := range []string{"", "1234", "telly123", "123456789"} {
+		if _, err := ParseDeviceID(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+
+	// The last digit is the checksum, so exactly one of them makes an ID valid.
+	valid := 0
+	for last := uint32(0); last < 16; last++ {
+		if ValidDeviceID(0x1234ABC0 | last) {
+			valid++
+		}
+	}
+	if valid != 1 {
+		t.Errorf("expected exactly one valid checksum digit, found %d", valid)
+	}
+}
+
+func TestWithValidChecksum(t *testing.T) {
+	for _, id := range []uint32{0x00000000, 0x12345678, 0x1234ABCD, 0xFFFFFFFF} {
+		fixed := WithValidChecksum(id)
+		if !ValidDeviceID(fixed) {
+			t.Errorf("expected %08X to be made valid, got %08X", id, fixed)
+		}
+		if fixed&^0x0F != id&^0x0F {
+			t.Errorf("expected only the last digit of %08X to change, got %08X", id, fixed)
+		}
+	}
+}
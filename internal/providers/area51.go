@@ -2,7 +2,6 @@ package providers
 
 import (
 	"fmt"
-	"strings"
 
 	m3u "github.com/tellytv/telly/internal/m3uplus"
 	"github.com/tellytv/telly/internal/xmltv"
@@ -32,7 +31,7 @@ func (i *area51) EPGURL() string {
 }
 
 // ParseTrack matches the provided M3U track an XMLTV channel and returns a ProviderChannel.
-func (i *area51) ParseTrack(track m3u.Track, channelMap map[string]xmltv.Channel) (*ProviderChannel, error) {
+func (i *area51) ParseTrack(track m3u.Track, epgMatcher *EPGMatcher) (*ProviderChannel, error) {
 	nameVal := track.Name
 	if i.BaseConfig.NameKey != "" {
 		nameVal = track.Tags[i.BaseConfig.NameKey]
@@ -46,23 +45,24 @@ func (i *area51) ParseTrack(track m3u.Track, channelMap map[string]xmltv.Channel
 	pChannel := &ProviderChannel{
 		Name:         nameVal,
 		Logo:         logoVal,
-		Number:       0,
+		Number:       ChannelNumber{},
 		StreamURL:    track.URI.String(),
 		StreamID:     0,
-		HD:           strings.Contains(strings.ToLower(track.Name), "hd"),
 		StreamFormat: "Unknown",
 		Track:        track,
 		OnDemand:     false,
 	}
+	pChannel.SetQuality(DetectQuality(track))
 
 	epgVal := track.Tags["tvg-id"]
 	if i.BaseConfig.EPGMatchKey != "" {
 		epgVal = track.Tags[i.BaseConfig.EPGMatchKey]
 	}
 
-	if xmlChan, ok := channelMap[epgVal]; ok {
-		pChannel.EPGMatch = epgVal
-		pChannel.EPGChannel = &xmlChan
+	if xmlChan, matchRule := epgMatcher.Match(epgVal, nameVal); xmlChan != nil {
+		pChannel.EPGMatch = xmlChan.ID
+		pChannel.EPGMatchRule = matchRule
+		pChannel.EPGChannel = xmlChan
 	}
 
 	return pChannel, nil
@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"net"
 	"net/url"
-	"strconv"
-	"strings"
 
 	log "github.com/sirupsen/logrus"
 	m3u "github.com/tellytv/telly/internal/m3uplus"
@@ -33,15 +31,15 @@ func (i *customProvider) EPGURL() string {
 }
 
 // ParseTrack matches the provided M3U track an XMLTV channel and returns a ProviderChannel.
-func (i *customProvider) ParseTrack(track m3u.Track, channelMap map[string]xmltv.Channel) (*ProviderChannel, error) {
+func (i *customProvider) ParseTrack(track m3u.Track, epgMatcher *EPGMatcher) (*ProviderChannel, error) {
 	channelVal := track.Tags["tvg-chno"]
 	if i.BaseConfig.ChannelNumberKey != "" {
 		channelVal = track.Tags[i.BaseConfig.ChannelNumberKey]
 	}
 
-	chanNum := 0
+	chanNum := ChannelNumber{}
 
-	if channelNumber, channelNumberErr := strconv.Atoi(channelVal); channelNumberErr == nil {
+	if channelNumber, channelNumberErr := ParseChannelNumber(channelVal); channelNumberErr == nil {
 		chanNum = channelNumber
 	}
 
@@ -60,12 +58,12 @@ func (i *customProvider) ParseTrack(track m3u.Track, channelMap map[string]xmltv
 		Logo:         logoVal,
 		Number:       chanNum,
 		StreamURL:    track.URI.String(),
-		StreamID:     chanNum,
-		HD:           strings.Contains(strings.ToLower(track.Name), "hd"),
+		StreamID:     chanNum.Major,
 		StreamFormat: "Unknown",
 		Track:        track,
 		OnDemand:     false,
 	}
+	pChannel.SetQuality(DetectQuality(track))
 
 	// If Udpxy is set in the provider configuration and StreamURL is a multicast stream,
 	// rewrite the URL to point to the Udpxy instance.
@@ -85,9 +83,10 @@ func (i *customProvider) ParseTrack(track m3u.Track, channelMap map[string]xmltv
 		epgVal = track.Tags[i.BaseConfig.EPGMatchKey]
 	}
 
-	if xmlChan, ok := channelMap[epgVal]; ok {
-		pChannel.EPGMatch = epgVal
-		pChannel.EPGChannel = &xmlChan
+	if xmlChan, matchRule := epgMatcher.Match(epgVal, nameVal); xmlChan != nil {
+		pChannel.EPGMatch = xmlChan.ID
+		pChannel.EPGMatchRule = matchRule
+		pChannel.EPGChannel = xmlChan
 	}
 
 	return pChannel, nil
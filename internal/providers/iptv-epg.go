@@ -2,8 +2,6 @@ package providers
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
 
 	m3u "github.com/tellytv/telly/internal/m3uplus"
 	"github.com/tellytv/telly/internal/xmltv"
@@ -33,13 +31,13 @@ func (i *iptvepg) EPGURL() string {
 }
 
 // ParseTrack matches the provided M3U track an XMLTV channel and returns a ProviderChannel.
-func (i *iptvepg) ParseTrack(track m3u.Track, channelMap map[string]xmltv.Channel) (*ProviderChannel, error) {
+func (i *iptvepg) ParseTrack(track m3u.Track, epgMatcher *EPGMatcher) (*ProviderChannel, error) {
 	channelVal := track.Tags["tvg-chno"]
 	if i.BaseConfig.ChannelNumberKey != "" {
 		channelVal = track.Tags[i.BaseConfig.ChannelNumberKey]
 	}
 
-	channelNumber, channelNumberErr := strconv.Atoi(channelVal)
+	channelNumber, channelNumberErr := ParseChannelNumber(channelVal)
 	if channelNumberErr != nil {
 		return nil, channelNumberErr
 	}
@@ -59,21 +57,22 @@ func (i *iptvepg) ParseTrack(track m3u.Track, channelMap map[string]xmltv.Channe
 		Logo:         logoVal,
 		Number:       channelNumber,
 		StreamURL:    track.URI.String(),
-		StreamID:     channelNumber,
-		HD:           strings.Contains(strings.ToLower(track.Name), "hd"),
+		StreamID:     channelNumber.Major,
 		StreamFormat: "Unknown",
 		Track:        track,
 		OnDemand:     false,
 	}
+	pChannel.SetQuality(DetectQuality(track))
 
 	epgVal := track.Tags["tvg-id"]
 	if i.BaseConfig.EPGMatchKey != "" {
 		epgVal = track.Tags[i.BaseConfig.EPGMatchKey]
 	}
 
-	if xmlChan, ok := channelMap[epgVal]; ok {
-		pChannel.EPGMatch = epgVal
-		pChannel.EPGChannel = &xmlChan
+	if xmlChan, matchRule := epgMatcher.Match(epgVal, nameVal); xmlChan != nil {
+		pChannel.EPGMatch = xmlChan.ID
+		pChannel.EPGMatchRule = matchRule
+		pChannel.EPGChannel = xmlChan
 	}
 
 	return pChannel, nil
@@ -1,9 +1,12 @@
 package providers
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/tellytv/telly/internal/filter"
 	m3u "github.com/tellytv/telly/internal/m3uplus"
 	"github.com/tellytv/telly/internal/xmltv"
 )
@@ -11,7 +14,7 @@ import (
 var streamNumberRegex = regexp.MustCompile(`/(\d+).(ts|.*.m3u8)`).FindAllStringSubmatch
 var channelNumberRegex = regexp.MustCompile(`^[0-9]+[[:space:]]?$`).MatchString
 var callSignRegex = regexp.MustCompile(`^[A-Z0-9]+$`).MatchString
-var hdRegex = regexp.MustCompile(`hd|4k`)
+var sdChannelIDRegex = regexp.MustCompile(`^I([0-9]+)\.json\.schedulesdirect\.org$`).FindStringSubmatch
 
 type Configuration struct {
 	Name     string `json:"-"`
@@ -27,25 +30,170 @@ type Configuration struct {
 
 	VideoOnDemand bool `json:"-"`
 
+	// Priority decides which source a channel carried by several sources is streamed from, higher first.
+	Priority int
+
 	Filter    string
 	FilterKey string
 	FilterRaw bool
 
+	// SortKey and SortReverse sort the playlist by a single tag, SortKeys and GroupOrder replace them (see ChannelOrder).
 	SortKey     string
 	SortReverse bool
+	SortKeys    []string
+	GroupOrder  []string
 
+	// Favorites are track names, tvg-ids or values of FavoriteTag that mark a channel as a favorite.
 	Favorites   []string
 	FavoriteTag string
 
 	IncludeOnly    []string
 	IncludeOnlyTag string
 
+	// FilterRules replace Filter and IncludeOnly when given, they are evaluated in order and the first match decides.
+	FilterRules []FilterRule
+
+	// Transforms rewrite every track, in order, before it is filtered and parsed.
+	Transforms []TrackTransform
+
 	CacheFiles bool
 
 	NameKey          string
 	LogoKey          string
 	ChannelNumberKey string
 	EPGMatchKey      string
+
+	// ChannelOffset is added to the channel numbers found in the playlist.
+	ChannelOffset int
+	// ChannelStart and ChannelEnd bound the numbers automatically assigned to channels without one.
+	ChannelStart int
+	ChannelEnd   int
+	// ChannelMap maps a track name or tvg-id to a fixed channel number such as "5" or "5.1".
+	ChannelMap map[string]string
+
+	// EPGOverrides maps a track name or EPG match key value to the XMLTV channel ID it should use.
+	EPGOverrides map[string]string
+
+	// EPGTimeShift moves every programme of this provider, e.g. "1h" for a "+1" timeshift source.
+	EPGTimeShift time.Duration
+
+	// SchedulesDirect, if set and no EPG is given, builds the guide from Schedules Direct instead.
+	SchedulesDirect *SchedulesDirectConfiguration
+}
+
+// SchedulesDirectConfiguration selects the Schedules Direct lineups and stations used as a guide source.
+type SchedulesDirectConfiguration struct {
+	// Lineups are Schedules Direct lineup IDs such as USA-OTA-90210, they are added to the account if missing.
+	Lineups []string
+	// Stations limits the guide to these station IDs or call signs. If empty, every station in the lineups is used.
+	Stations []string
+	// Days is the number of days of schedules to fetch.
+	Days int
+	// StationMap maps a track name or EPG match key value to a station ID.
+	StationMap map[string]string
+}
+
+// IsFavorite reports whether the track is one of the Favorites, by name, tvg-id or the value of FavoriteTag.
+func (i *Configuration) IsFavorite(track m3u.Track) bool {
+	candidates := []string{track.Name, track.Tags["tvg-id"]}
+	if i.FavoriteTag != "" {
+		candidates = append(candidates, track.Tags[i.FavoriteTag])
+	}
+
+	for _, favorite := range i.Favorites {
+		for _, candidate := range candidates {
+			if candidate != "" && strings.EqualFold(strings.TrimSpace(candidate), strings.TrimSpace(favorite)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// FilterRule includes or excludes the tracks matching a filter expression, only one of Include and Exclude should be set.
+type FilterRule struct {
+	Name    string
+	Include string
+	Exclude string
+}
+
+// CompileFilterRules compiles the FilterRules, or if there are none, rules equivalent to Filter and IncludeOnly.
+// regexKey is the tag Filter applies to when FilterKey isn't set.
+func (i *Configuration) CompileFilterRules(regexKey string) (filter.Rules, error) {
+	rules := make(filter.Rules, 0)
+
+	for idx, rule := range i.FilterRules {
+		source, include := rule.Include, true
+		if rule.Exclude != "" {
+			if rule.Include != "" {
+				return nil, fmt.Errorf("filter rule %d (%s) has both Include and Exclude set", idx+1, rule.Name)
+			}
+			source, include = rule.Exclude, false
+		}
+
+		expr, compileErr := filter.Compile(source)
+		if compileErr != nil {
+			return nil, fmt.Errorf("filter rule %d (%s): %s", idx+1, rule.Name, compileErr)
+		}
+
+		rules = append(rules, filter.Rule{Name: rule.Name, Include: include, Expression: expr})
+	}
+
+	if len(rules) > 0 || (i.Filter == "" && len(i.IncludeOnly) == 0) {
+		return rules, nil
+	}
+
+	if len(i.IncludeOnly) > 0 {
+		// Tracks that have the tag, even empty, are decided by it alone, the others fall through to Filter.
+		rules = append(rules,
+			filter.Rule{Name: "legacy filter", Include: true, Expression: filter.TagIn(i.IncludeOnlyTag, i.IncludeOnly)},
+			filter.Rule{Name: "legacy filter", Include: false, Expression: filter.HasTag(i.IncludeOnlyTag)},
+		)
+	}
+
+	filterField := "raw"
+	if !i.FilterRaw {
+		filterField = "tag." + regexKey
+		if i.FilterKey != "" {
+			filterField = "tag." + i.FilterKey
+		}
+	}
+	expr, compileErr := filter.Compile(fmt.Sprintf("%s =~ %s", filterField, filter.Quote(i.Filter)))
+	if compileErr != nil {
+		return nil, compileErr
+	}
+
+	return append(rules, filter.Rule{Name: "legacy filter", Include: true, Expression: expr}), nil
+}
+
+// SchedulesDirectChannelID returns the XMLTV channel ID used for a Schedules Direct station.
+func SchedulesDirectChannelID(stationID string) string {
+	return fmt.Sprintf("I%s.json.schedulesdirect.org", stationID)
+}
+
+// SchedulesDirectStationID returns the Schedules Direct station of an XMLTV channel ID made by
+// SchedulesDirectChannelID, which tv_grab_zz_sdjson uses as well.
+func SchedulesDirectStationID(channelID string) (string, bool) {
+	matches := sdChannelIDRegex(channelID)
+	if matches == nil {
+		return "", false
+	}
+	return matches[1], true
+}
+
+// EPGOverrideMap returns the EPGOverrides merged with the Schedules Direct station map, if any.
+func (i *Configuration) EPGOverrideMap() map[string]string {
+	overrides := make(map[string]string)
+	if i.SchedulesDirect != nil {
+		for key, stationID := range i.SchedulesDirect.StationMap {
+			overrides[key] = SchedulesDirectChannelID(stationID)
+		}
+	}
+	for key, channelID := range i.EPGOverrides {
+		overrides[key] = channelID
+	}
+	return overrides
 }
 
 func (i *Configuration) GetProvider() (Provider, error) {
@@ -59,19 +207,24 @@ func (i *Configuration) GetProvider() (Provider, error) {
 type ProviderChannel struct {
 	Name         string
 	StreamID     int // Should be the integer just before .ts.
-	Number       int
+	Number       ChannelNumber
 	Logo         string
 	StreamURL    string
 	HD           bool
 	Quality      string
+	VideoCodec   string
+	AudioCodec   string
 	OnDemand     bool
 	StreamFormat string
 	Favorite     bool
 
 	EPGMatch      string
+	EPGMatchRule  EPGMatchRule
 	EPGChannel    *xmltv.Channel
 	EPGProgrammes []xmltv.Programme
-	Track         m3u.Track
+	// EPGPlaceholder is true when EPGChannel was generated by telly because the channel had no EPG.
+	EPGPlaceholder bool
+	Track          m3u.Track
 }
 
 // Provider describes a IPTV provider configuration.
@@ -81,7 +234,7 @@ type Provider interface {
 	EPGURL() string
 
 	// These are functions to extract information from playlists.
-	ParseTrack(track m3u.Track, channelMap map[string]xmltv.Channel) (*ProviderChannel, error)
+	ParseTrack(track m3u.Track, epgMatcher *EPGMatcher) (*ProviderChannel, error)
 	ProcessProgramme(programme xmltv.Programme) *xmltv.Programme
 
 	RegexKey() string
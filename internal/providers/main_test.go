@@ -0,0 +1,42 @@
+package providers
+
+import (
+	"testing"
+
+	m3u "github.com/tellytv/telly/internal/m3uplus"
+)
+
+func TestLegacyFilterRules(t *testing.T) {
+	track := func(name string, tags map[string]string) m3u.Track {
+		return m3u.Track{Name: name, Raw: "#EXTINF:-1," + name, Tags: tags}
+	}
+
+	tests := []struct {
+		name     string
+		config   Configuration
+		track    m3u.Track
+		expected bool
+	}{
+		{"no filter", Configuration{}, track("BBC One", nil), true},
+		{"filter on the regex key", Configuration{Filter: "^UK"}, track("BBC One", map[string]string{"group-title": "UK"}), true},
+		{"filter not matching", Configuration{Filter: "^UK"}, track("CNN", map[string]string{"group-title": "US"}), false},
+		{"raw filter", Configuration{Filter: "CNN$", FilterRaw: true}, track("CNN", nil), true},
+		{"include only", Configuration{IncludeOnly: []string{"UK"}, IncludeOnlyTag: "group-title"}, track("BBC One", map[string]string{"group-title": "UK"}), true},
+		{"include only is case sensitive", Configuration{IncludeOnly: []string{"UK"}, IncludeOnlyTag: "group-title"}, track("BBC One", map[string]string{"group-title": "uk"}), false},
+		{"include only tag name is case sensitive", Configuration{IncludeOnly: []string{"UK"}, IncludeOnlyTag: "Group-Title"}, track("BBC One", map[string]string{"group-title": "uk"}), true},
+		{"include only rejects an empty tag", Configuration{IncludeOnly: []string{"UK"}, IncludeOnlyTag: "group-title", Filter: "."}, track("BBC One", map[string]string{"group-title": ""}), false},
+		{"include only without the tag falls through", Configuration{IncludeOnly: []string{"UK"}, IncludeOnlyTag: "group-title", Filter: "^BBC", FilterKey: "tvg-name"}, track("BBC One", map[string]string{"tvg-name": "BBC One"}), true},
+		{"include only without the tag or a filter", Configuration{IncludeOnly: []string{"UK"}, IncludeOnlyTag: "group-title"}, track("CNN", nil), true},
+	}
+
+	for _, test := range tests {
+		rules, compileErr := test.config.CompileFilterRules("group-title")
+		if compileErr != nil {
+			t.Errorf("%s: %s", test.name, compileErr)
+			continue
+		}
+		if actual, _ := rules.Evaluate(test.track); actual != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, actual)
+		}
+	}
+}
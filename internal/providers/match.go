@@ -0,0 +1,120 @@
+package providers
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/tellytv/telly/internal/xmltv"
+)
+
+// EPGMatchRule describes how a track was matched to a XMLTV channel.
+type EPGMatchRule string
+
+const (
+	// EPGMatchNone means the track could not be matched to any XMLTV channel.
+	EPGMatchNone EPGMatchRule = ""
+	// EPGMatchOverride means the track was matched using the EPGOverrides configuration.
+	EPGMatchOverride EPGMatchRule = "override"
+	// EPGMatchID means the EPG match key of the track (tvg-id by default) equals the XMLTV channel ID.
+	EPGMatchID EPGMatchRule = "id"
+	// EPGMatchName means the normalised track name equals a normalised XMLTV display name.
+	EPGMatchName EPGMatchRule = "display-name"
+)
+
+var (
+	countryPrefixRegex = regexp.MustCompile(`^\s*\p{L}{2,3}\s*[:|]\s*`)
+	nonAlphaNumRegex   = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+	// nameSuffixes are stripped from the end of channel names before comparing them.
+	nameSuffixes = map[string]bool{
+		"hd": true, "sd": true, "fhd": true, "uhd": true, "4k": true, "hevc": true,
+		"1080p": true, "1080i": true, "720p": true, "576i": true, "480i": true,
+		"us": true, "usa": true, "uk": true, "ca": true, "au": true, "nz": true, "ie": true,
+		"de": true, "fr": true, "es": true, "it": true, "nl": true,
+		"east": true, "west": true,
+	}
+)
+
+// NormaliseChannelName reduces a channel name to a form suitable for loose comparison.
+// Case, punctuation, country prefixes like "UK:" as well as quality and country suffixes are removed.
+func NormaliseChannelName(name string) string {
+	name = countryPrefixRegex.ReplaceAllString(name, "")
+	tokens := strings.Fields(nonAlphaNumRegex.ReplaceAllString(strings.ToLower(name), " "))
+
+	for len(tokens) > 1 && nameSuffixes[tokens[len(tokens)-1]] {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	return strings.Join(tokens, "")
+}
+
+// EPGMatcher matches tracks to XMLTV channels by override, by ID and finally by display name.
+type EPGMatcher struct {
+	channels  map[string]xmltv.Channel
+	names     map[string]string
+	overrides map[string]string
+}
+
+// NewEPGMatcher returns a EPGMatcher for the given channels, keyed by XMLTV channel ID.
+// overrides maps a track name or EPG match key value to a XMLTV channel ID.
+func NewEPGMatcher(channels map[string]xmltv.Channel, overrides map[string]string) *EPGMatcher {
+	matcher := &EPGMatcher{
+		channels:  channels,
+		names:     make(map[string]string),
+		overrides: make(map[string]string),
+	}
+
+	// Config keys are lowercased by viper, so compare overrides case insensitively.
+	for key, id := range overrides {
+		matcher.overrides[strings.ToLower(key)] = id
+	}
+
+	ambiguous := make(map[string]bool)
+
+	for id, channel := range channels {
+		for _, displayName := range channel.DisplayNames {
+			name := NormaliseChannelName(displayName.Value)
+			if name == "" || ambiguous[name] {
+				continue
+			}
+			if existing, ok := matcher.names[name]; ok && existing != id {
+				// Two channels share a name so neither can be picked safely.
+				delete(matcher.names, name)
+				ambiguous[name] = true
+				continue
+			}
+			matcher.names[name] = id
+		}
+	}
+
+	return matcher
+}
+
+// Match returns the XMLTV channel for a track with the given EPG match key value and name, and the rule that matched.
+func (m *EPGMatcher) Match(id, name string) (*xmltv.Channel, EPGMatchRule) {
+	if m == nil {
+		return nil, EPGMatchNone
+	}
+
+	for _, key := range []string{id, name} {
+		if overrideID, ok := m.overrides[strings.ToLower(key)]; ok && key != "" {
+			if channel, ok := m.channels[overrideID]; ok {
+				return &channel, EPGMatchOverride
+			}
+		}
+	}
+
+	if channel, ok := m.channels[id]; ok && id != "" {
+		return &channel, EPGMatchID
+	}
+
+	// IDs are often a call sign or channel name rather than the XMLTV channel ID, so try them as names too.
+	for _, key := range []string{name, id} {
+		if channelID, ok := m.names[NormaliseChannelName(key)]; ok && key != "" {
+			channel := m.channels[channelID]
+			return &channel, EPGMatchName
+		}
+	}
+
+	return nil, EPGMatchNone
+}
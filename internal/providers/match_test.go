@@ -0,0 +1,140 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/tellytv/telly/internal/xmltv"
+)
+
+func TestNormaliseChannelName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"BBC One", "bbcone"},
+		{"UK: BBC One HD", "bbcone"},
+		{"UK | BBC One FHD", "bbcone"},
+		{"BBC One (East) 1080p", "bbcone"},
+		{"E! Entertainment", "eentertainment"},
+		{"HD", "hd"},
+		{"Sky Sports 1 UK HD", "skysports1"},
+		{"Россия 24", "россия24"},
+		{"RU: Россия 1 HD", "россия1"},
+		{"Матч! ТВ", "матчтв"},
+		{"ČT1", "čt1"},
+		{"CZ | ČT2 FHD", "čt2"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if actual := NormaliseChannelName(test.name); actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestEPGMatcher(t *testing.T) {
+	channel := func(id string, names ...string) xmltv.Channel {
+		epgChannel := xmltv.Channel{ID: id}
+		for _, name := range names {
+			epgChannel.DisplayNames = append(epgChannel.DisplayNames, xmltv.CommonElement{Value: name})
+		}
+		return epgChannel
+	}
+
+	matcher := NewEPGMatcher(map[string]xmltv.Channel{
+		"bbc1.uk":       channel("bbc1.uk", "BBC One", "BBC1"),
+		"bbc1.london":   channel("bbc1.london", "BBC One London"),
+		"itv1.uk":       channel("itv1.uk", "ITV", "Regional News"),
+		"stv.uk":        channel("stv.uk", "STV", "Regional News"),
+		"bbcnews.uk":    channel("bbcnews.uk", "BBC News"),
+		"overridden.uk": channel("overridden.uk", "Overridden"),
+		"russia1.ru":    channel("russia1.ru", "Россия 1"),
+		"matchtv.ru":    channel("matchtv.ru", "Матч ТВ"),
+	}, map[string]string{
+		"BBC News HD": "overridden.uk",
+		"ITV1.UK":     "overridden.uk",
+		"missing":     "missing.uk",
+	})
+
+	tests := []struct {
+		name       string
+		id         string
+		trackName  string
+		expectedID string
+		rule       EPGMatchRule
+	}{
+		{"override by name", "bbcnews.uk", "BBC News HD", "overridden.uk", EPGMatchOverride},
+		{"override by ID, ignoring case", "itv1.uk", "ITV", "overridden.uk", EPGMatchOverride},
+		{"override of an unknown channel is ignored", "missing", "BBC One", "bbc1.uk", EPGMatchName},
+		{"ID before display name", "bbc1.london", "BBC One", "bbc1.london", EPGMatchID},
+		{"display name", "", "UK: BBC One HD", "bbc1.uk", EPGMatchName},
+		{"ID used as a display name", "BBC1", "", "bbc1.uk", EPGMatchName},
+		{"non-ASCII display name", "", "RU: Россия 1 HD", "russia1.ru", EPGMatchName},
+		{"non-ASCII display name with punctuation", "", "Матч! ТВ", "matchtv.ru", EPGMatchName},
+		{"ambiguous display name", "", "Regional News", "", EPGMatchNone},
+		{"no match", "unknown.uk", "Unknown", "", EPGMatchNone},
+		{"nothing to match", "", "", "", EPGMatchNone},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matched, rule := matcher.Match(test.id, test.trackName)
+			matchedID := ""
+			if matched != nil {
+				matchedID = matched.ID
+			}
+			if matchedID != test.expectedID || rule != test.rule {
+				t.Errorf("expected %q by %q, got %q by %q", test.expectedID, test.rule, matchedID, rule)
+			}
+		})
+	}
+
+	if matched, rule := (*EPGMatcher)(nil).Match("bbc1.uk", "BBC One"); matched != nil || rule != EPGMatchNone {
+		t.Errorf("expected a nil matcher to match nothing")
+	}
+}
+
+func TestEPGOverrideMap(t *testing.T) {
+	config := &Configuration{
+		EPGOverrides: map[string]string{"13 KERA HD": "kera.epg", "BBC One": "bbc1.uk"},
+		SchedulesDirect: &SchedulesDirectConfiguration{
+			StationMap: map[string]string{"13 KERA HD": "10436", "KDFW": "10437"},
+		},
+	}
+
+	expected := map[string]string{
+		"13 KERA HD": "kera.epg",
+		"BBC One":    "bbc1.uk",
+		"KDFW":       "I10437.json.schedulesdirect.org",
+	}
+
+	overrides := config.EPGOverrideMap()
+	if len(overrides) != len(expected) {
+		t.Errorf("expected %d overrides, got %v", len(expected), overrides)
+	}
+	for key, channelID := range expected {
+		if overrides[key] != channelID {
+			t.Errorf("%s: expected %q, got %q", key, channelID, overrides[key])
+		}
+	}
+}
+
+func TestSchedulesDirectStationID(t *testing.T) {
+	tests := []struct {
+		channelID string
+		stationID string
+		ok        bool
+	}{
+		{SchedulesDirectChannelID("10436"), "10436", true},
+		{"I10436.json.schedulesdirect.org.example.com", "", false},
+		{"bbc1.uk", "", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		if stationID, ok := SchedulesDirectStationID(test.channelID); stationID != test.stationID || ok != test.ok {
+			t.Errorf("%s: expected %q and %t, got %q and %t", test.channelID, test.stationID, test.ok, stationID, ok)
+		}
+	}
+}
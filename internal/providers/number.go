@@ -0,0 +1,94 @@
+package providers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var channelNumberPartsRegex = regexp.MustCompile(`^(\d+)(?:[.\-_](\d+))?$`)
+
+// ChannelNumber is a channel number as shown to viewers, optionally with a sub-channel like 5.1.
+// The zero value means no number has been given.
+type ChannelNumber struct {
+	Major int
+	Minor int
+}
+
+// ParseChannelNumber parses numbers like 5, 5.1 or 5-1.
+func ParseChannelNumber(value string) (ChannelNumber, error) {
+	matches := channelNumberPartsRegex.FindStringSubmatch(strings.TrimSpace(value))
+	if matches == nil {
+		return ChannelNumber{}, fmt.Errorf("invalid channel number: %s", value)
+	}
+
+	major, majorErr := strconv.Atoi(matches[1])
+	if majorErr != nil {
+		return ChannelNumber{}, majorErr
+	}
+
+	number := ChannelNumber{Major: major}
+
+	if matches[2] != "" {
+		minor, minorErr := strconv.Atoi(matches[2])
+		if minorErr != nil {
+			return ChannelNumber{}, minorErr
+		}
+		number.Minor = minor
+	}
+
+	return number, nil
+}
+
+// IsZero reports whether no number was given.
+func (n ChannelNumber) IsZero() bool {
+	return n.Major == 0 && n.Minor == 0
+}
+
+// Less reports whether n sorts before other.
+func (n ChannelNumber) Less(other ChannelNumber) bool {
+	if n.Major != other.Major {
+		return n.Major < other.Major
+	}
+	return n.Minor < other.Minor
+}
+
+// Add returns the number moved by offset, keeping the sub-channel.
+func (n ChannelNumber) Add(offset int) ChannelNumber {
+	n.Major += offset
+	return n
+}
+
+// Next returns the following number, the next sub-channel if n has one.
+func (n ChannelNumber) Next() ChannelNumber {
+	if n.Minor > 0 {
+		n.Minor++
+	} else {
+		n.Major++
+	}
+	return n
+}
+
+// String returns the number the way HDHomeRun GuideNumbers are written, e.g. 5 or 5.1.
+func (n ChannelNumber) String() string {
+	if n.Minor > 0 {
+		return fmt.Sprintf("%d.%d", n.Major, n.Minor)
+	}
+	return strconv.Itoa(n.Major)
+}
+
+// MarshalText implements encoding.TextMarshaler so numbers can be used as JSON keys and values.
+func (n ChannelNumber) MarshalText() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (n *ChannelNumber) UnmarshalText(text []byte) error {
+	number, parseErr := ParseChannelNumber(string(text))
+	if parseErr != nil {
+		return parseErr
+	}
+	*n = number
+	return nil
+}
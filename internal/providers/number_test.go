@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseChannelNumber(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected ChannelNumber
+		valid    bool
+	}{
+		{"5", ChannelNumber{Major: 5}, true},
+		{" 101 ", ChannelNumber{Major: 101}, true},
+		{"5.1", ChannelNumber{Major: 5, Minor: 1}, true},
+		{"5-1", ChannelNumber{Major: 5, Minor: 1}, true},
+		{"5_12", ChannelNumber{Major: 5, Minor: 12}, true},
+		{"0", ChannelNumber{}, true},
+		{"", ChannelNumber{}, false},
+		{"5.", ChannelNumber{}, false},
+		{"5.1.2", ChannelNumber{}, false},
+		{"-5", ChannelNumber{}, false},
+		{"BBC1", ChannelNumber{}, false},
+	}
+
+	for _, test := range tests {
+		number, parseErr := ParseChannelNumber(test.value)
+		if (parseErr == nil) != test.valid {
+			t.Errorf("%q: expected valid to be %t, got error %v", test.value, test.valid, parseErr)
+			continue
+		}
+		if number != test.expected {
+			t.Errorf("%q: expected %+v, got %+v", test.value, test.expected, number)
+		}
+	}
+}
+
+func TestChannelNumberNextAndLess(t *testing.T) {
+	tests := []struct {
+		number   ChannelNumber
+		next     ChannelNumber
+		expected string
+	}{
+		{ChannelNumber{Major: 5}, ChannelNumber{Major: 6}, "6"},
+		{ChannelNumber{Major: 5, Minor: 1}, ChannelNumber{Major: 5, Minor: 2}, "5.2"},
+		{ChannelNumber{Major: 9, Minor: 9}, ChannelNumber{Major: 9, Minor: 10}, "9.10"},
+	}
+
+	for _, test := range tests {
+		next := test.number.Next()
+		if next != test.next || next.String() != test.expected {
+			t.Errorf("%s: expected the next number to be %s, got %s", test.number, test.expected, next)
+		}
+		if !test.number.Less(next) || next.Less(test.number) {
+			t.Errorf("%s: expected it to sort before %s", test.number, next)
+		}
+	}
+
+	ordered := []ChannelNumber{{Major: 2}, {Major: 2, Minor: 1}, {Major: 2, Minor: 10}, {Major: 10}}
+	for idx := 1; idx < len(ordered); idx++ {
+		if !ordered[idx-1].Less(ordered[idx]) || ordered[idx].Less(ordered[idx-1]) {
+			t.Errorf("expected %s to sort before %s", ordered[idx-1], ordered[idx])
+		}
+	}
+	if (ChannelNumber{Major: 5}).Less(ChannelNumber{Major: 5}) {
+		t.Errorf("expected a number not to sort before itself")
+	}
+}
+
+func TestChannelNumberJSON(t *testing.T) {
+	numbers := map[ChannelNumber]ChannelNumber{{Major: 5, Minor: 1}: {Major: 1005, Minor: 1}, {Major: 7}: {Major: 1007}}
+
+	data, marshalErr := json.Marshal(numbers)
+	if marshalErr != nil {
+		t.Fatal(marshalErr)
+	}
+	if string(data) != `{"5.1":"1005.1","7":"1007"}` {
+		t.Errorf("expected numbers to be written like guide numbers, got %s", data)
+	}
+
+	decoded := make(map[ChannelNumber]ChannelNumber)
+	if unmarshalErr := json.Unmarshal(data, &decoded); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	for key, value := range numbers {
+		if decoded[key] != value {
+			t.Errorf("expected %s to be read back as %s, got %s", key, value, decoded[key])
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package providers
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	m3u "github.com/tellytv/telly/internal/m3uplus"
+)
+
+// Resolutions, from worst to best. HD and better set the HD flag of a channel.
+const (
+	QualitySD  = "SD"
+	QualityHD  = "HD"
+	QualityFHD = "FHD"
+	QualityUHD = "UHD"
+)
+
+// Tokens in channel names and quality tags that give away the resolution or codecs of a stream.
+// They only match whole words, so "SHOWCASE" or "THE HDTV SHOW" aren't mistaken for HD channels.
+var (
+	uhdRegex = regexp.MustCompile(`(?i)(^|[^a-z0-9])(uhd|4k|2160[pi]?)($|[^a-z0-9])`)
+	fhdRegex = regexp.MustCompile(`(?i)(^|[^a-z0-9])(fhd|full ?hd|1080[pi]?)($|[^a-z0-9])`)
+	hdRegex  = regexp.MustCompile(`(?i)(^|[^a-z0-9])(hd|720[pi]?)($|[^a-z0-9])`)
+	sdRegex  = regexp.MustCompile(`(?i)(^|[^a-z0-9])(sd|480[pi]?|576[pi]?|360p)($|[^a-z0-9])`)
+
+	hevcRegex  = regexp.MustCompile(`(?i)(^|[^a-z0-9])(hevc|h\.?265|x265)($|[^a-z0-9])`)
+	h264Regex  = regexp.MustCompile(`(?i)(^|[^a-z0-9])(avc|h\.?264|x264)($|[^a-z0-9])`)
+	mpeg2Regex = regexp.MustCompile(`(?i)(^|[^a-z0-9])(mpeg-?2)($|[^a-z0-9])`)
+)
+
+// qualityTags are playlist tags some providers use to describe the stream.
+var qualityTags = []string{"quality", "resolution", "tvg-quality", "tvg-resolution", "video-codec", "codec"}
+
+// StreamQuality describes the picture and sound of a stream, empty fields are unknown.
+type StreamQuality struct {
+	Resolution string
+	Height     int
+	VideoCodec string
+	AudioCodec string
+}
+
+// IsHD reports whether the resolution is HD or better.
+func (q StreamQuality) IsHD() bool {
+	return q.Resolution == QualityHD || q.Resolution == QualityFHD || q.Resolution == QualityUHD
+}
+
+// Merge fills the unknown fields of q from other.
+func (q StreamQuality) Merge(other StreamQuality) StreamQuality {
+	if q.Resolution == "" {
+		q.Resolution = other.Resolution
+	}
+	if q.Height == 0 {
+		q.Height = other.Height
+	}
+	if q.VideoCodec == "" {
+		q.VideoCodec = other.VideoCodec
+	}
+	if q.AudioCodec == "" {
+		q.AudioCodec = other.AudioCodec
+	}
+	return q
+}
+
+// DetectQuality guesses the quality of the stream of a track from its quality tags and then its name.
+func DetectQuality(track m3u.Track) StreamQuality {
+	quality := StreamQuality{}
+	for _, tag := range qualityTags {
+		if value := track.Tags[tag]; value != "" {
+			quality = quality.Merge(qualityFromText(value))
+		}
+	}
+	return quality.Merge(qualityFromText(track.Name))
+}
+
+func qualityFromText(text string) StreamQuality {
+	quality := StreamQuality{}
+
+	switch {
+	case uhdRegex.MatchString(text):
+		quality.Resolution = QualityUHD
+	case fhdRegex.MatchString(text):
+		quality.Resolution = QualityFHD
+	case hdRegex.MatchString(text):
+		quality.Resolution = QualityHD
+	case sdRegex.MatchString(text):
+		quality.Resolution = QualitySD
+	}
+
+	// A bare height such as resolution="1080" is also common in tags.
+	if height, heightErr := strconv.Atoi(strings.TrimSpace(text)); heightErr == nil {
+		quality.Resolution = QualityFromHeight(height)
+		quality.Height = height
+	}
+
+	switch {
+	case hevcRegex.MatchString(text):
+		quality.VideoCodec = "HEVC"
+	case h264Regex.MatchString(text):
+		quality.VideoCodec = "H264"
+	case mpeg2Regex.MatchString(text):
+		quality.VideoCodec = "MPEG2"
+	}
+
+	return quality
+}
+
+// StripQualityTokens removes the words giving away the resolution or codec of a stream from a channel name, so
+// "BBC One HD" and "BBC One H.265" both become "BBC One".
+func StripQualityTokens(name string) string {
+	for _, tokenRegex := range []*regexp.Regexp{uhdRegex, fhdRegex, hdRegex, sdRegex, hevcRegex, h264Regex, mpeg2Regex} {
+		// Tokens that are next to each other share a separator, so replace until nothing is left to replace.
+		for replaced := ""; replaced != name; {
+			replaced = name
+			name = tokenRegex.ReplaceAllString(name, "$1$3")
+		}
+	}
+	return strings.TrimSpace(name)
+}
+
+// QualityFromHeight returns the resolution of a picture with the given number of lines.
+func QualityFromHeight(height int) string {
+	switch {
+	case height <= 0:
+		return ""
+	case height >= 2000:
+		return QualityUHD
+	case height >= 1000:
+		return QualityFHD
+	case height >= 700:
+		return QualityHD
+	}
+	return QualitySD
+}
+
+// SetQuality copies the quality into the channel.
+func (c *ProviderChannel) SetQuality(quality StreamQuality) {
+	c.HD = quality.IsHD()
+	c.Quality = quality.Resolution
+	c.VideoCodec = quality.VideoCodec
+	c.AudioCodec = quality.AudioCodec
+}
@@ -0,0 +1,85 @@
+package providers
+
+import (
+	"testing"
+
+	m3u "github.com/tellytv/telly/internal/m3uplus"
+)
+
+func TestDetectQuality(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		expected StreamQuality
+	}{
+		{"BBC One HD", nil, StreamQuality{Resolution: QualityHD}},
+		{"BBC One FHD", nil, StreamQuality{Resolution: QualityFHD}},
+		{"BBC One Full HD", nil, StreamQuality{Resolution: QualityFHD}},
+		{"UK: BBC One |4K|", nil, StreamQuality{Resolution: QualityUHD}},
+		{"BBC One 720p", nil, StreamQuality{Resolution: QualityHD}},
+		{"BBC One (SD)", nil, StreamQuality{Resolution: QualitySD}},
+		{"BBC One HEVC", nil, StreamQuality{VideoCodec: "HEVC"}},
+		{"BBC One H.265 UHD", nil, StreamQuality{Resolution: QualityUHD, VideoCodec: "HEVC"}},
+		{"BBC One x264", nil, StreamQuality{VideoCodec: "H264"}},
+		{"BBC One MPEG-2", nil, StreamQuality{VideoCodec: "MPEG2"}},
+		{"SHOWCASE", nil, StreamQuality{}},
+		{"THE HDTV SHOW", nil, StreamQuality{}},
+		{"SDTV Classics", nil, StreamQuality{}},
+		{"Channel 4", nil, StreamQuality{}},
+		{"BBC One", map[string]string{"tvg-resolution": "1080"}, StreamQuality{Resolution: QualityFHD, Height: 1080}},
+		{"BBC One", map[string]string{"resolution": "576"}, StreamQuality{Resolution: QualitySD, Height: 576}},
+		{"BBC One", map[string]string{"quality": "hd", "video-codec": "hevc"}, StreamQuality{Resolution: QualityHD, VideoCodec: "HEVC"}},
+		{"BBC One SD", map[string]string{"quality": "UHD"}, StreamQuality{Resolution: QualityUHD}},
+		{"BBC One HEVC", map[string]string{"quality": "HD"}, StreamQuality{Resolution: QualityHD, VideoCodec: "HEVC"}},
+	}
+
+	for _, test := range tests {
+		quality := DetectQuality(m3u.Track{Name: test.name, Tags: test.tags})
+		if quality != test.expected {
+			t.Errorf("%s %v: expected %+v, got %+v", test.name, test.tags, test.expected, quality)
+		}
+	}
+}
+
+func TestStreamQualityIsHD(t *testing.T) {
+	tests := []struct {
+		height   int
+		expected string
+		hd       bool
+	}{
+		{0, "", false},
+		{480, QualitySD, false},
+		{576, QualitySD, false},
+		{720, QualityHD, true},
+		{1080, QualityFHD, true},
+		{2160, QualityUHD, true},
+	}
+
+	for _, test := range tests {
+		resolution := QualityFromHeight(test.height)
+		if resolution != test.expected || (StreamQuality{Resolution: resolution}).IsHD() != test.hd {
+			t.Errorf("%d: expected %q with HD %t, got %q", test.height, test.expected, test.hd, resolution)
+		}
+	}
+}
+
+func TestStripQualityTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"BBC One HD", "BBC One"},
+		{"BBC One H.265", "BBC One"},
+		{"BBC One FHD HEVC", "BBC One"},
+		{"BBC One |HD|", "BBC One ||"},
+		{"SHOWCASE", "SHOWCASE"},
+		{"THE HDTV SHOW", "THE HDTV SHOW"},
+		{"HD", ""},
+	}
+
+	for _, test := range tests {
+		if actual := StripQualityTokens(test.name); actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, actual)
+		}
+	}
+}
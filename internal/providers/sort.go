@@ -0,0 +1,173 @@
+package providers
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ChannelOrder sorts the channels of a provider by a list of keys, each one breaking the ties of the one before.
+// The keys are group (the group-title, in GroupOrder first), number, name or any tag. A key prefixed with - sorts
+// in reverse. Text is compared naturally, so "Channel 2" comes before "Channel 10".
+type ChannelOrder struct {
+	keys       []sortKey
+	groupRanks map[string]int
+}
+
+type sortKey struct {
+	field   string
+	reverse bool
+}
+
+// ChannelOrder returns the order configured by SortKeys and GroupOrder, or by the older SortKey and SortReverse.
+// It returns nil if channels should stay in playlist order.
+func (i *Configuration) ChannelOrder() *ChannelOrder {
+	fields := i.SortKeys
+	if len(fields) == 0 && i.SortKey != "" {
+		fields = []string{i.SortKey}
+		if i.SortReverse {
+			fields[0] = "-" + i.SortKey
+		}
+	}
+	if len(fields) == 0 && len(i.GroupOrder) > 0 {
+		fields = []string{"group"}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	order := &ChannelOrder{groupRanks: make(map[string]int)}
+	for _, field := range fields {
+		key := sortKey{field: strings.ToLower(strings.TrimSpace(field))}
+		if strings.HasPrefix(key.field, "-") {
+			key.field, key.reverse = strings.TrimPrefix(key.field, "-"), true
+		}
+		if key.field != "" {
+			order.keys = append(order.keys, key)
+		}
+	}
+	for rank, group := range i.GroupOrder {
+		if _, ok := order.groupRanks[strings.ToLower(group)]; !ok {
+			order.groupRanks[strings.ToLower(group)] = rank
+		}
+	}
+
+	return order
+}
+
+// Sort sorts the channels in place. Channels that compare equal keep their playlist order.
+func (o *ChannelOrder) Sort(channels []*ProviderChannel) {
+	if o == nil {
+		return
+	}
+	sort.SliceStable(channels, func(i, j int) bool {
+		return o.compare(channels[i], channels[j]) < 0
+	})
+}
+
+func (o *ChannelOrder) compare(a, b *ProviderChannel) int {
+	for _, key := range o.keys {
+		result := 0
+		switch key.field {
+		case "group":
+			result = o.compareGroups(a.Track.Tags["group-title"], b.Track.Tags["group-title"])
+		case "number":
+			result = compareNumbers(a.Number, b.Number)
+		case "name":
+			result = NaturalCompare(a.Name, b.Name)
+		default:
+			tag := strings.TrimPrefix(key.field, "tag.")
+			result = NaturalCompare(tagValue(a, tag), tagValue(b, tag))
+		}
+		if key.reverse {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// compareGroups puts the groups listed in GroupOrder first, in that order, and the rest after them naturally sorted.
+func (o *ChannelOrder) compareGroups(a, b string) int {
+	rankA, listedA := o.groupRanks[strings.ToLower(a)]
+	rankB, listedB := o.groupRanks[strings.ToLower(b)]
+	switch {
+	case listedA && listedB:
+		return rankA - rankB
+	case listedA:
+		return -1
+	case listedB:
+		return 1
+	}
+	return NaturalCompare(a, b)
+}
+
+// compareNumbers sorts channels without a number after the numbered ones.
+func compareNumbers(a, b ChannelNumber) int {
+	switch {
+	case a == b:
+		return 0
+	case a.IsZero():
+		return 1
+	case b.IsZero():
+		return -1
+	case a.Less(b):
+		return -1
+	}
+	return 1
+}
+
+// tagValue returns the value of a tag of the channel, ignoring the case of the tag name.
+func tagValue(channel *ProviderChannel, tag string) string {
+	if value, ok := channel.Track.Tags[tag]; ok {
+		return value
+	}
+	for key, value := range channel.Track.Tags {
+		if strings.EqualFold(key, tag) {
+			return value
+		}
+	}
+	return ""
+}
+
+// NaturalCompare compares two strings ignoring case, with runs of digits compared by their numeric value.
+// It returns a negative number if a sorts first, a positive one if b does and zero if they are equal.
+func NaturalCompare(a, b string) int {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	i, j := 0, 0
+
+	for i < len(ra) && j < len(rb) {
+		if unicode.IsDigit(ra[i]) && unicode.IsDigit(rb[j]) {
+			startA, startB := i, j
+			for i < len(ra) && unicode.IsDigit(ra[i]) {
+				i++
+			}
+			for j < len(rb) && unicode.IsDigit(rb[j]) {
+				j++
+			}
+			numA := strings.TrimLeft(string(ra[startA:i]), "0")
+			numB := strings.TrimLeft(string(rb[startB:j]), "0")
+			// Without leading zeros, a longer run of digits is a bigger number.
+			if len(numA) != len(numB) {
+				return len(numA) - len(numB)
+			}
+			if numA != numB {
+				return strings.Compare(numA, numB)
+			}
+			continue
+		}
+
+		if ra[i] != rb[j] {
+			if ra[i] < rb[j] {
+				return -1
+			}
+			return 1
+		}
+		i++
+		j++
+	}
+
+	return (len(ra) - i) - (len(rb) - j)
+}
@@ -0,0 +1,94 @@
+package providers
+
+import (
+	"fmt"
+	"testing"
+
+	m3u "github.com/tellytv/telly/internal/m3uplus"
+)
+
+func TestNaturalCompare(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"Ch 2", "Ch 10", -1},
+		{"Ch 10", "Ch 2", 1},
+		{"Ch 02", "Ch 2", 0},
+		{"ch 2", "CH 2", 0},
+		{"Ch 2a", "Ch 2b", -1},
+		{"Ch 9", "Ch 10 HD", -1},
+		{"Ch", "Ch 1", -1},
+		{"BBC One", "BBC Two", -1},
+		{"Channel 100", "Channel 99", 1},
+		{"", "", 0},
+	}
+
+	sign := func(n int) int {
+		switch {
+		case n < 0:
+			return -1
+		case n > 0:
+			return 1
+		}
+		return 0
+	}
+
+	for _, test := range tests {
+		if actual := sign(NaturalCompare(test.a, test.b)); actual != test.expected {
+			t.Errorf("%q, %q: expected %d, got %d", test.a, test.b, test.expected, actual)
+		}
+	}
+}
+
+func TestChannelOrder(t *testing.T) {
+	channel := func(name, number, group, quality string) *ProviderChannel {
+		parsed, _ := ParseChannelNumber(number)
+		return &ProviderChannel{
+			Name:   name,
+			Number: parsed,
+			Track:  m3u.Track{Name: name, Tags: map[string]string{"group-title": group, "Quality": quality}},
+		}
+	}
+
+	playlist := func() []*ProviderChannel {
+		return []*ProviderChannel{
+			channel("Ch 10", "3", "News", "HD"),
+			channel("Ch 2", "", "Sports", "SD"),
+			channel("Ch 1", "5.1", "Movies", "HD"),
+			channel("Ch 3", "5", "news", ""),
+			channel("Ch 20", "", "Kids", "SD"),
+		}
+	}
+
+	tests := []struct {
+		name     string
+		config   Configuration
+		expected string
+	}{
+		{"playlist order", Configuration{}, "[Ch 10 Ch 2 Ch 1 Ch 3 Ch 20]"},
+		{"name", Configuration{SortKeys: []string{"name"}}, "[Ch 1 Ch 2 Ch 3 Ch 10 Ch 20]"},
+		{"name reversed", Configuration{SortKeys: []string{"-Name"}}, "[Ch 20 Ch 10 Ch 3 Ch 2 Ch 1]"},
+		{"number, unnumbered last", Configuration{SortKeys: []string{"number"}}, "[Ch 10 Ch 3 Ch 1 Ch 2 Ch 20]"},
+		{"group then name", Configuration{SortKeys: []string{"group", "name"}}, "[Ch 20 Ch 1 Ch 3 Ch 10 Ch 2]"},
+		{"group order first", Configuration{GroupOrder: []string{"Sports", "NEWS"}}, "[Ch 2 Ch 10 Ch 3 Ch 20 Ch 1]"},
+		{"tag ignoring case", Configuration{SortKeys: []string{"tag.quality", "name"}}, "[Ch 3 Ch 1 Ch 10 Ch 2 Ch 20]"},
+		{"legacy sort key", Configuration{SortKey: "group-title", SortReverse: true}, "[Ch 2 Ch 10 Ch 3 Ch 1 Ch 20]"},
+		{"missing tag keeps playlist order", Configuration{SortKeys: []string{"tvg-id"}}, "[Ch 10 Ch 2 Ch 1 Ch 3 Ch 20]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			channels := playlist()
+			test.config.ChannelOrder().Sort(channels)
+
+			names := make([]string, 0, len(channels))
+			for _, channel := range channels {
+				names = append(names, channel.Name)
+			}
+			if actual := fmt.Sprint(names); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
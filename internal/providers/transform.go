@@ -0,0 +1,170 @@
+package providers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/tellytv/telly/internal/filter"
+	m3u "github.com/tellytv/telly/internal/m3uplus"
+)
+
+// TrackTransform rewrites tracks before they are filtered and parsed.
+// Field and Match replace the part of the name or tag matching the regular expression with Replace, which may
+// refer to groups as $1. Set and Unset add, change or remove tags. If When is set, the transform only applies to
+// tracks matching that filter expression. Tag names are compared ignoring case, like filter expressions do, as
+// neither config keys nor M3U attributes are reliably capitalised: setting tvg-id changes an existing tvg-ID tag,
+// tags that don't exist yet are added as written.
+type TrackTransform struct {
+	Name    string
+	When    string
+	Field   string
+	Match   string
+	Replace string
+	Set     map[string]string
+	Unset   []string
+}
+
+// TrackTransformer applies the compiled transforms of a provider in order.
+type TrackTransformer struct {
+	steps []transformStep
+}
+
+type transformStep struct {
+	name    string
+	when    *filter.Expression
+	field   string
+	match   *regexp.Regexp
+	replace string
+	set     map[string]string
+	unset   []string
+}
+
+// CompileTransforms compiles the Transforms of the configuration.
+func (i *Configuration) CompileTransforms() (*TrackTransformer, error) {
+	transformer := &TrackTransformer{}
+
+	for idx, transform := range i.Transforms {
+		step := transformStep{
+			name:    transform.Name,
+			field:   transform.Field,
+			replace: transform.Replace,
+			set:     transform.Set,
+			unset:   transform.Unset,
+		}
+		if step.name == "" {
+			step.name = fmt.Sprintf("transform %d", idx+1)
+		}
+
+		if transform.When != "" {
+			when, whenErr := filter.Compile(transform.When)
+			if whenErr != nil {
+				return nil, fmt.Errorf("%s: invalid When: %s", step.name, whenErr)
+			}
+			step.when = when
+		}
+
+		if transform.Match != "" {
+			if step.field == "" {
+				step.field = "name"
+			}
+			match, matchErr := regexp.Compile(transform.Match)
+			if matchErr != nil {
+				return nil, fmt.Errorf("%s: invalid Match: %s", step.name, matchErr)
+			}
+			step.match = match
+		}
+
+		if step.match == nil && len(step.set) == 0 && len(step.unset) == 0 {
+			return nil, fmt.Errorf("%s does nothing, it needs Match, Set or Unset", step.name)
+		}
+
+		transformer.steps = append(transformer.steps, step)
+	}
+
+	return transformer, nil
+}
+
+// Apply returns the transformed track and the names of the transforms that changed it.
+// The original track, including its tags, is left untouched.
+func (t *TrackTransformer) Apply(track m3u.Track) (m3u.Track, []string) {
+	if t == nil || len(t.steps) == 0 {
+		return track, nil
+	}
+
+	tags := make(map[string]string, len(track.Tags))
+	for key, value := range track.Tags {
+		tags[key] = value
+	}
+	track.Tags = tags
+
+	applied := make([]string, 0)
+
+	for _, step := range t.steps {
+		if step.when != nil && !step.when.Match(track) {
+			continue
+		}
+
+		changed := false
+
+		if step.match != nil {
+			before := trackField(track, step.field)
+			after := step.match.ReplaceAllString(before, step.replace)
+			if after != before {
+				setTrackField(&track, step.field, strings.TrimSpace(after))
+				changed = true
+			}
+		}
+
+		for key, value := range step.set {
+			key = tagKey(track.Tags, key)
+			if current, ok := track.Tags[key]; !ok || current != value {
+				track.Tags[key] = value
+				changed = true
+			}
+		}
+
+		for _, key := range step.unset {
+			key = tagKey(track.Tags, key)
+			if _, ok := track.Tags[key]; ok {
+				delete(track.Tags, key)
+				changed = true
+			}
+		}
+
+		if changed {
+			applied = append(applied, step.name)
+		}
+	}
+
+	return track, applied
+}
+
+// trackField returns the name of the track for "name", otherwise the tag named field, optionally prefixed by "tag.".
+func trackField(track m3u.Track, field string) string {
+	if strings.EqualFold(field, "name") {
+		return track.Name
+	}
+	return track.Tags[tagKey(track.Tags, strings.TrimPrefix(field, "tag."))]
+}
+
+func setTrackField(track *m3u.Track, field, value string) {
+	if strings.EqualFold(field, "name") {
+		track.Name = value
+		return
+	}
+	track.Tags[tagKey(track.Tags, strings.TrimPrefix(field, "tag."))] = value
+}
+
+// tagKey returns the key of the tag named key, ignoring case, or key itself if the track has no such tag.
+func tagKey(tags map[string]string, key string) string {
+	if _, ok := tags[key]; ok {
+		return key
+	}
+	for existing := range tags {
+		if strings.EqualFold(existing, key) {
+			return existing
+		}
+	}
+	return key
+}
@@ -0,0 +1,144 @@
+package providers
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	m3u "github.com/tellytv/telly/internal/m3uplus"
+)
+
+func testTrack() m3u.Track {
+	uri, _ := url.Parse("http://example.com/live/1234.ts")
+	return m3u.Track{
+		Name: "UK: BBC One |HD|",
+		URI:  uri,
+		Tags: map[string]string{
+			"tvg-ID":      "",
+			"tvg-logo":    "http://example.com/bbc1.png",
+			"group-title": "UK Entertainment",
+		},
+	}
+}
+
+func TestTrackTransformerApply(t *testing.T) {
+	tests := []struct {
+		name         string
+		transforms   []TrackTransform
+		expectedName string
+		expectedTags map[string]string
+		applied      []string
+	}{
+		{
+			name:         "match replaces the name",
+			transforms:   []TrackTransform{{Match: `^UK:\s*`}, {Name: "quality", Match: `\s*\|HD\|$`}},
+			expectedName: "BBC One",
+			expectedTags: map[string]string{"tvg-ID": "", "tvg-logo": "http://example.com/bbc1.png", "group-title": "UK Entertainment"},
+			applied:      []string{"transform 1", "quality"},
+		},
+		{
+			name:         "match on a tag with groups",
+			transforms:   []TrackTransform{{Field: "tag.group-title", Match: `^UK (\w+)$`, Replace: "$1 (UK)"}},
+			expectedName: "UK: BBC One |HD|",
+			expectedTags: map[string]string{"tvg-ID": "", "tvg-logo": "http://example.com/bbc1.png", "group-title": "Entertainment (UK)"},
+			applied:      []string{"transform 1"},
+		},
+		{
+			name:         "set, then unset, then replace within a transform",
+			transforms:   []TrackTransform{{Field: "tvg-id", Match: `^$`, Replace: "replaced", Set: map[string]string{"tvg-id": "set"}, Unset: []string{"tvg-id", "tvg-logo"}}},
+			expectedName: "UK: BBC One |HD|",
+			expectedTags: map[string]string{"group-title": "UK Entertainment"},
+			applied:      []string{"transform 1"},
+		},
+		{
+			name:         "later transforms see earlier changes",
+			transforms:   []TrackTransform{{Set: map[string]string{"tvg-id": "bbc1.uk"}}, {When: `tvg-id == "bbc1.uk"`, Unset: []string{"tvg-logo"}}},
+			expectedName: "UK: BBC One |HD|",
+			expectedTags: map[string]string{"tvg-ID": "bbc1.uk", "group-title": "UK Entertainment"},
+			applied:      []string{"transform 1", "transform 2"},
+		},
+		{
+			name:         "when skips tracks that don't match",
+			transforms:   []TrackTransform{{When: `group-title == "Sports"`, Set: map[string]string{"group-title": "Sport"}}},
+			expectedName: "UK: BBC One |HD|",
+			expectedTags: map[string]string{"tvg-ID": "", "tvg-logo": "http://example.com/bbc1.png", "group-title": "UK Entertainment"},
+			applied:      []string{},
+		},
+		{
+			name:         "setting the same value changes nothing",
+			transforms:   []TrackTransform{{Set: map[string]string{"group-title": "UK Entertainment", "tvg-chno": "101"}}},
+			expectedName: "UK: BBC One |HD|",
+			expectedTags: map[string]string{"tvg-ID": "", "tvg-logo": "http://example.com/bbc1.png", "group-title": "UK Entertainment", "tvg-chno": "101"},
+			applied:      []string{"transform 1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transformer, compileErr := (&Configuration{Transforms: test.transforms}).CompileTransforms()
+			if compileErr != nil {
+				t.Fatal(compileErr)
+			}
+
+			original := testTrack()
+			track, applied := transformer.Apply(original)
+
+			if track.Name != test.expectedName {
+				t.Errorf("expected name %q, got %q", test.expectedName, track.Name)
+			}
+			if !reflect.DeepEqual(track.Tags, test.expectedTags) {
+				t.Errorf("expected tags %v, got %v", test.expectedTags, track.Tags)
+			}
+			if !reflect.DeepEqual(applied, test.applied) {
+				t.Errorf("expected %v to be applied, got %v", test.applied, applied)
+			}
+			if !reflect.DeepEqual(original.Tags, testTrack().Tags) {
+				t.Errorf("expected the original tags to be left untouched, got %v", original.Tags)
+			}
+		})
+	}
+}
+
+func TestTrackTransformSetFromConfig(t *testing.T) {
+	config := viper.New()
+	config.SetConfigType("toml")
+	if readErr := config.ReadConfig(strings.NewReader(`
+[[Source]]
+  Provider = "Custom"
+  [[Source.Transforms]]
+    Set = { tvg-id = "bbc1.uk", Tvg-Chno = "101" }
+`)); readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	var cfgs []Configuration
+	if unmarshalErr := config.UnmarshalKey("source", &cfgs); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+
+	transformer, compileErr := cfgs[0].CompileTransforms()
+	if compileErr != nil {
+		t.Fatal(compileErr)
+	}
+
+	// However the config capitalises a tag, an existing one is changed in place and a new one is added as written.
+	track, _ := transformer.Apply(testTrack())
+	if track.Tags["tvg-ID"] != "bbc1.uk" || track.Tags["Tvg-Chno"] != "101" || len(track.Tags) != 4 {
+		t.Errorf("expected tvg-ID to be changed and Tvg-Chno added, got %v", track.Tags)
+	}
+}
+
+func TestInvalidTransforms(t *testing.T) {
+	for _, transform := range []TrackTransform{
+		{},
+		{Name: "noop", When: `tvg-id == ""`},
+		{Match: `(`},
+		{When: `tvg-id ==`, Unset: []string{"tvg-logo"}},
+	} {
+		if _, compileErr := (&Configuration{Transforms: []TrackTransform{transform}}).CompileTransforms(); compileErr == nil {
+			t.Errorf("expected %+v to be invalid", transform)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package xmltv
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// declarationPeekSize is how many bytes are inspected to find the XML declaration.
+const declarationPeekSize = 1024
+
+var encodingDeclRegex = regexp.MustCompile(`^\s*<\?xml[^>]*?encoding\s*=\s*["']([^"']+)["']`)
+
+// DecodeError is returned by Decode when the document could not be parsed.
+// It carries the position in the (UTF-8 converted) input where decoding failed.
+type DecodeError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("xmltv: line %d, column %d: %s", e.Line, e.Column, e.Err)
+}
+
+// Unwrap returns the underlying decoding error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decode parses an XMLTV document from the given reader.
+// The charset declared in the XML declaration is honoured, byte order marks are stripped
+// and invalid UTF-8 sequences are replaced with U+FFFD instead of aborting the parse.
+func Decode(r io.Reader) (*TV, error) {
+	utf8Reader, readerErr := NewUTF8Reader(r)
+	if readerErr != nil {
+		return nil, readerErr
+	}
+
+	decoder := xml.NewDecoder(utf8Reader)
+	// The input has already been converted to UTF-8, so ignore whatever the declaration says.
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	tv := new(TV)
+	if decodeErr := decoder.Decode(tv); decodeErr != nil {
+		if syntaxErr, ok := decodeErr.(*xml.SyntaxError); ok {
+			return nil, &DecodeError{Line: syntaxErr.Line, Err: fmt.Errorf("%s", syntaxErr.Msg)}
+		}
+		line, column := decoder.InputPos()
+		return nil, &DecodeError{Line: line, Column: column, Err: decodeErr}
+	}
+
+	return tv, nil
+}
+
+// NewUTF8Reader returns a reader that converts an XML document to UTF-8.
+// The source encoding is taken from a byte order mark if present, then the XML declaration, defaulting to UTF-8.
+func NewUTF8Reader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReaderSize(r, declarationPeekSize)
+
+	// Peek returns an error when the input is shorter than requested, which is fine here.
+	prefix, _ := buffered.Peek(declarationPeekSize)
+
+	var enc encoding.Encoding = unicode.UTF8
+	if matches := encodingDeclRegex.FindSubmatch(prefix); matches != nil {
+		label := strings.TrimSpace(string(matches[1]))
+		declared, _ := charset.Lookup(label)
+		if declared == nil {
+			return nil, fmt.Errorf("xmltv: unsupported charset %q", label)
+		}
+		enc = declared
+	}
+
+	// BOMOverride strips a byte order mark and decodes accordingly; without one it uses the declared charset.
+	// The trailing UTF-8 decoder replaces any remaining ill-formed sequences with U+FFFD.
+	return transform.NewReader(buffered, transform.Chain(unicode.BOMOverride(enc.NewDecoder()), unicode.UTF8.NewDecoder())), nil
+}
@@ -0,0 +1,100 @@
+package xmltv
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+var (
+	channelStart   = xml.StartElement{Name: xml.Name{Local: "channel"}}
+	programmeStart = xml.StartElement{Name: xml.Name{Local: "programme"}}
+)
+
+// Encoder writes a XMLTV document element by element so that the whole guide never has to be held in memory as XML.
+// Begin must be called first, followed by all channels, then all programmes and finally End.
+type Encoder struct {
+	w    io.Writer
+	enc  *xml.Encoder
+	root xml.StartElement
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	return &Encoder{w: w, enc: enc}
+}
+
+// Begin writes the XML header and the opening tv element using the attributes of tv.
+// No doctype is declared, as channels may have an lcn, which xmltv.dtd doesn't allow.
+func (e *Encoder) Begin(tv *TV) error {
+	if _, err := io.WriteString(e.w, xml.Header); err != nil {
+		return err
+	}
+
+	e.root = xml.StartElement{Name: xml.Name{Local: "tv"}}
+	for _, attr := range []xml.Attr{
+		{Name: xml.Name{Local: "date"}, Value: tv.Date},
+		{Name: xml.Name{Local: "source-info-url"}, Value: tv.SourceInfoURL},
+		{Name: xml.Name{Local: "source-info-name"}, Value: tv.SourceInfoName},
+		{Name: xml.Name{Local: "source-data-url"}, Value: tv.SourceDataURL},
+		{Name: xml.Name{Local: "generator-info-name"}, Value: tv.GeneratorInfoName},
+		{Name: xml.Name{Local: "generator-info-url"}, Value: tv.GeneratorInfoURL},
+	} {
+		if attr.Value != "" {
+			e.root.Attr = append(e.root.Attr, attr)
+		}
+	}
+
+	return e.enc.EncodeToken(e.root)
+}
+
+// EncodeChannel writes a single channel element.
+func (e *Encoder) EncodeChannel(channel *Channel) error {
+	if err := e.enc.EncodeElement(channel, channelStart); err != nil {
+		return fmt.Errorf("error encoding channel %s: %s", channel.ID, err)
+	}
+	return nil
+}
+
+// EncodeProgramme writes a single programme element.
+func (e *Encoder) EncodeProgramme(programme *Programme) error {
+	if err := e.enc.EncodeElement(programme, programmeStart); err != nil {
+		return fmt.Errorf("error encoding programme on channel %s: %s", programme.Channel, err)
+	}
+	return nil
+}
+
+// End closes the tv element and flushes any buffered output.
+func (e *Encoder) End() error {
+	if err := e.enc.EncodeToken(e.root.End()); err != nil {
+		return err
+	}
+	if err := e.enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n")
+	return err
+}
+
+// Encode writes the complete document for tv.
+func (e *Encoder) Encode(tv *TV) error {
+	if err := e.Begin(tv); err != nil {
+		return err
+	}
+
+	for idx := range tv.Channels {
+		if err := e.EncodeChannel(&tv.Channels[idx]); err != nil {
+			return err
+		}
+	}
+
+	for idx := range tv.Programmes {
+		if err := e.EncodeProgramme(&tv.Programmes[idx]); err != nil {
+			return err
+		}
+	}
+
+	return e.End()
+}
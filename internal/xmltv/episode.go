@@ -0,0 +1,335 @@
+package xmltv
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Episode numbering systems understood by Episode.
+const (
+	EpisodeSystemXMLTVNS         = "xmltv_ns"
+	EpisodeSystemOnScreen        = "onscreen"
+	EpisodeSystemDDProgID        = "dd_progid"
+	EpisodeSystemOriginalAirDate = "original-air-date"
+)
+
+// OriginalAirDateFormat is the layout original-air-date episode numbers are written with.
+const OriginalAirDateFormat = "2006-01-02 15:04:05"
+
+var (
+	ddProgIDRegex = regexp.MustCompile(`^(EP|SH|MV|SP)(\d{6,8})\.?(\d{4})(?:\.(\d+)/(\d+))?$`)
+	// Matches S01E02, s1 e2, 1x02 and "Season 1 Episode 2".
+	onScreenRegex = regexp.MustCompile(`(?i)\b(?:s(\d{1,3})\s?e(\d{1,4})|(\d{1,2})x(\d{1,3})|season\s+(\d{1,3}),?\s+episode\s+(\d{1,4}))\b`)
+	// Matches "Episode 12" and "Ep. 12" on their own.
+	onScreenEpisodeRegex = regexp.MustCompile(`(?i)\b(?:episode\s+|ep\.?\s*)(\d{1,4})\b`)
+	// Matches "Part 1 of 2" and "Pt. 1/2".
+	onScreenPartRegex = regexp.MustCompile(`(?i)\b(?:part|pt\.?)\s*(\d{1,2})(?:\s*(?:of|/)\s*(\d{1,2}))?\b`)
+)
+
+// DDProgID is a Tribune Media Services program ID as used in dd_progid episode numbers, e.g. EP01234567.0012.
+type DDProgID struct {
+	// Type is one of EP (episode), SH (show), MV (movie) or SP (sports).
+	Type      string
+	SeriesID  int
+	EpisodeID int
+	// Part and TotalParts are a telly extension, appended as .1/2.
+	Part       int
+	TotalParts int
+}
+
+// ParseDDProgID parses a dd_progid value or a 14 character TMS ID.
+func ParseDDProgID(value string) (DDProgID, error) {
+	matches := ddProgIDRegex.FindStringSubmatch(strings.TrimSpace(value))
+	if matches == nil {
+		return DDProgID{}, fmt.Errorf("invalid dd_progid: %s", value)
+	}
+
+	progID := DDProgID{Type: matches[1]}
+	progID.SeriesID, _ = strconv.Atoi(matches[2])
+	progID.EpisodeID, _ = strconv.Atoi(matches[3])
+	if matches[4] != "" {
+		progID.Part, _ = strconv.Atoi(matches[4])
+		progID.TotalParts, _ = strconv.Atoi(matches[5])
+	}
+
+	return progID, nil
+}
+
+// TMSID returns the 14 character ID Schedules Direct uses for the program.
+func (id DDProgID) TMSID() string {
+	return fmt.Sprintf("%s%08d%04d", id.Type, id.SeriesID, id.EpisodeID)
+}
+
+// String returns the dd_progid episode number value.
+func (id DDProgID) String() string {
+	value := fmt.Sprintf("%s%08d.%04d", id.Type, id.SeriesID, id.EpisodeID)
+	if id.Part > 0 {
+		value = fmt.Sprintf("%s.%d/%d", value, id.Part, id.TotalParts)
+	}
+	return value
+}
+
+// Episode is everything known about where a programme sits within its series.
+// Numbers are 1-indexed and zero when unknown, whatever the system they were parsed from uses.
+type Episode struct {
+	Season        int
+	TotalSeasons  int
+	Episode       int
+	TotalEpisodes int
+	Part          int
+	TotalParts    int
+	AirDate       time.Time
+	ProgID        *DDProgID
+}
+
+// IsZero reports whether nothing is known about the episode.
+func (e Episode) IsZero() bool {
+	return e.Season == 0 && e.Episode == 0 && e.Part == 0 && e.AirDate.IsZero() && e.ProgID == nil
+}
+
+// HasNumbers reports whether any season, episode or part number is known, so that it can be written as xmltv_ns.
+func (e Episode) HasNumbers() bool {
+	return e.Season > 0 || e.Episode > 0 || e.Part > 0
+}
+
+// Merge fills anything unknown in e from other.
+func (e Episode) Merge(other Episode) Episode {
+	if e.Season == 0 {
+		e.Season, e.TotalSeasons = other.Season, other.TotalSeasons
+	} else if e.TotalSeasons == 0 {
+		e.TotalSeasons = other.TotalSeasons
+	}
+	if e.Episode == 0 {
+		e.Episode, e.TotalEpisodes = other.Episode, other.TotalEpisodes
+	} else if e.TotalEpisodes == 0 {
+		e.TotalEpisodes = other.TotalEpisodes
+	}
+	if e.Part == 0 {
+		e.Part, e.TotalParts = other.Part, other.TotalParts
+	}
+	if e.AirDate.IsZero() {
+		e.AirDate = other.AirDate
+	}
+	if e.ProgID == nil {
+		e.ProgID = other.ProgID
+	}
+	return e
+}
+
+// ParseXMLTVNS parses a xmltv_ns value such as "0/3.11/22.0/2", where every number is 0-indexed.
+func ParseXMLTVNS(value string) (Episode, error) {
+	fields := strings.Split(strings.Replace(value, " ", "", -1), ".")
+	if len(fields) != 3 {
+		return Episode{}, fmt.Errorf("invalid xmltv_ns: %s", value)
+	}
+
+	var numbers [3][2]int
+	for idx, field := range fields {
+		number, total, parseErr := parseXMLTVNSField(field)
+		if parseErr != nil {
+			return Episode{}, fmt.Errorf("invalid xmltv_ns: %s: %s", value, parseErr)
+		}
+		numbers[idx] = [2]int{number, total}
+	}
+
+	episode := Episode{
+		Season:        numbers[0][0],
+		TotalSeasons:  numbers[0][1],
+		Episode:       numbers[1][0],
+		TotalEpisodes: numbers[1][1],
+		Part:          numbers[2][0],
+		TotalParts:    numbers[2][1],
+	}
+
+	if !episode.HasNumbers() {
+		return Episode{}, fmt.Errorf("invalid xmltv_ns: %s: no numbers", value)
+	}
+
+	return episode, nil
+}
+
+// parseXMLTVNSField returns the 1-indexed number and the total of a single xmltv_ns field, zero if absent.
+func parseXMLTVNSField(field string) (int, int, error) {
+	numberStr, totalStr := field, ""
+	if idx := strings.Index(field, "/"); idx >= 0 {
+		numberStr, totalStr = field[:idx], field[idx+1:]
+	}
+
+	number, total := 0, 0
+	if numberStr != "" {
+		parsed, parseErr := strconv.Atoi(numberStr)
+		if parseErr != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("bad number %q", numberStr)
+		}
+		number = parsed + 1
+	}
+	if totalStr != "" {
+		parsed, parseErr := strconv.Atoi(totalStr)
+		if parseErr != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("bad total %q", totalStr)
+		}
+		total = parsed
+	}
+
+	return number, total, nil
+}
+
+// XMLTVNS returns the xmltv_ns value for the episode, or an empty string if it has no numbers.
+func (e Episode) XMLTVNS() string {
+	if !e.HasNumbers() {
+		return ""
+	}
+
+	field := func(number, total int) string {
+		value := ""
+		if number > 0 {
+			value = strconv.Itoa(number - 1)
+		}
+		if total > 0 {
+			value = fmt.Sprintf("%s/%d", value, total)
+		}
+		return value
+	}
+
+	return fmt.Sprintf("%s.%s.%s", field(e.Season, e.TotalSeasons), field(e.Episode, e.TotalEpisodes), field(e.Part, e.TotalParts))
+}
+
+// ParseOnScreen finds episode numbers the way they are usually written for people, such as S01E02, 1x02 or "Ep. 5",
+// anywhere in value. Part numbers like "Part 1 of 2" are picked up too.
+func ParseOnScreen(value string) (Episode, error) {
+	var episode Episode
+
+	if matches := onScreenRegex.FindStringSubmatch(value); matches != nil {
+		for idx := 1; idx+1 < len(matches); idx += 2 {
+			if matches[idx] != "" {
+				episode.Season, _ = strconv.Atoi(matches[idx])
+				episode.Episode, _ = strconv.Atoi(matches[idx+1])
+				break
+			}
+		}
+	} else if matches := onScreenEpisodeRegex.FindStringSubmatch(value); matches != nil {
+		episode.Episode, _ = strconv.Atoi(matches[1])
+	}
+
+	if matches := onScreenPartRegex.FindStringSubmatch(value); matches != nil {
+		episode.Part, _ = strconv.Atoi(matches[1])
+		episode.TotalParts, _ = strconv.Atoi(matches[2])
+	}
+
+	if !episode.HasNumbers() {
+		return Episode{}, fmt.Errorf("no episode number found in %q", value)
+	}
+
+	return episode, nil
+}
+
+// OnScreen returns the episode number as it is usually shown to people, e.g. S01E02, or an empty string if unknown.
+func (e Episode) OnScreen() string {
+	if e.Episode == 0 {
+		return ""
+	}
+
+	value := fmt.Sprintf("E%02d", e.Episode)
+	if e.Season > 0 {
+		value = fmt.Sprintf("S%02d%s", e.Season, value)
+	}
+	if e.Part > 0 {
+		value = fmt.Sprintf("%s Part %d", value, e.Part)
+		if e.TotalParts > 0 {
+			value = fmt.Sprintf("%s of %d", value, e.TotalParts)
+		}
+	}
+
+	return value
+}
+
+// ParseOriginalAirDate parses an original-air-date value, with or without a time.
+func ParseOriginalAirDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{OriginalAirDateFormat, "2006-01-02", "20060102"} {
+		if airDate, parseErr := time.Parse(layout, value); parseErr == nil {
+			return airDate, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid original-air-date: %s", value)
+}
+
+// ParseEpisodeNum parses a single episode-num element of any understood system.
+func ParseEpisodeNum(epNum EpisodeNum) (Episode, error) {
+	switch epNum.System {
+	case EpisodeSystemXMLTVNS:
+		return ParseXMLTVNS(epNum.Value)
+	case EpisodeSystemOnScreen:
+		return ParseOnScreen(epNum.Value)
+	case EpisodeSystemDDProgID:
+		progID, parseErr := ParseDDProgID(epNum.Value)
+		if parseErr != nil {
+			return Episode{}, parseErr
+		}
+		return Episode{Part: progID.Part, TotalParts: progID.TotalParts, ProgID: &progID}, nil
+	case EpisodeSystemOriginalAirDate:
+		airDate, parseErr := ParseOriginalAirDate(epNum.Value)
+		return Episode{AirDate: airDate}, parseErr
+	}
+	return Episode{}, fmt.Errorf("unknown episode-num system %q", epNum.System)
+}
+
+// Episode returns what the episode-num elements of the programme say about the episode.
+// xmltv_ns takes precedence over onscreen, which takes precedence over dd_progid. Unparseable elements are skipped.
+func (p *Programme) Episode() Episode {
+	var episode Episode
+	for _, system := range []string{EpisodeSystemXMLTVNS, EpisodeSystemOnScreen, EpisodeSystemDDProgID, EpisodeSystemOriginalAirDate} {
+		for _, epNum := range p.EpisodeNums {
+			if epNum.System != system {
+				continue
+			}
+			if parsed, parseErr := ParseEpisodeNum(epNum); parseErr == nil {
+				episode = episode.Merge(parsed)
+			}
+		}
+	}
+	return episode
+}
+
+// SetEpisode replaces the episode-num elements of the programme with those describing e, in a consistent order.
+// Elements of systems Episode doesn't understand are kept, as are ones that couldn't be parsed unless e replaces them.
+func (p *Programme) SetEpisode(e Episode) {
+	epNums := make([]EpisodeNum, 0, len(p.EpisodeNums)+4)
+
+	if e.ProgID != nil {
+		epNums = append(epNums, EpisodeNum{System: EpisodeSystemDDProgID, Value: e.ProgID.String()})
+	}
+	if xmltvNS := e.XMLTVNS(); xmltvNS != "" {
+		epNums = append(epNums, EpisodeNum{System: EpisodeSystemXMLTVNS, Value: xmltvNS})
+	}
+	if onScreen := e.OnScreen(); onScreen != "" {
+		epNums = append(epNums, EpisodeNum{System: EpisodeSystemOnScreen, Value: onScreen})
+	}
+	if !e.AirDate.IsZero() {
+		epNums = append(epNums, EpisodeNum{System: EpisodeSystemOriginalAirDate, Value: e.AirDate.Format(OriginalAirDateFormat)})
+	}
+
+	written := make(map[string]bool)
+	for _, epNum := range epNums {
+		written[epNum.System] = true
+	}
+
+	for _, epNum := range p.EpisodeNums {
+		if _, parseErr := ParseEpisodeNum(epNum); parseErr != nil && !written[epNum.System] {
+			epNums = append(epNums, epNum)
+		}
+	}
+
+	p.EpisodeNums = epNums
+}
+
+// NormaliseEpisodeNums rewrites the episode-num elements of the programme so that every system agrees.
+func (p *Programme) NormaliseEpisodeNums() {
+	if len(p.EpisodeNums) == 0 {
+		return
+	}
+	p.SetEpisode(p.Episode())
+}
@@ -0,0 +1,57 @@
+package xmltv
+
+import "time"
+
+// Shift moves every timestamp of the programme by the given duration.
+func (p *Programme) Shift(d time.Duration) {
+	p.mapTimes(func(t time.Time) time.Time { return t.Add(d) })
+}
+
+// In converts every timestamp of the programme to the given location.
+// The instants are unchanged, only the offset they are written with differs.
+func (p *Programme) In(loc *time.Location) {
+	p.mapTimes(func(t time.Time) time.Time { return t.In(loc) })
+}
+
+// mapTimes replaces, rather than modifies, each timestamp since copies of a Programme share them.
+func (p *Programme) mapTimes(fn func(time.Time) time.Time) {
+	for _, t := range []**Time{&p.Start, &p.Stop, &p.PDCStart, &p.VPSStart} {
+		if *t != nil && !(*t).IsZero() {
+			*t = &Time{fn((*t).Time)}
+		}
+	}
+}
+
+// Airs reports whether any part of the programme is on air between from and to.
+// A zero from or to leaves that side of the window open.
+func (p *Programme) Airs(from, to time.Time) bool {
+	if p.Start == nil || p.Start.IsZero() {
+		return true
+	}
+
+	end := p.Start.Time
+	if p.Stop != nil && !p.Stop.IsZero() {
+		end = p.Stop.Time
+	}
+
+	if !from.IsZero() && end.Before(from) {
+		return false
+	}
+
+	if !to.IsZero() && !p.Start.Before(to) {
+		return false
+	}
+
+	return true
+}
+
+// FilterProgrammes returns the programmes that are on air between from and to.
+func FilterProgrammes(programmes []Programme, from, to time.Time) []Programme {
+	filtered := make([]Programme, 0, len(programmes))
+	for _, programme := range programmes {
+		if programme.Airs(from, to) {
+			filtered = append(filtered, programme)
+		}
+	}
+	return filtered
+}
@@ -7,8 +7,6 @@ import (
 	"os"
 	"strings"
 	"time"
-
-	"golang.org/x/net/html/charset"
 )
 
 // Time that holds the time which is parsed from XML
@@ -110,14 +108,12 @@ type TV struct {
 
 // LoadXML loads the XMLTV XML from file.
 func (t *TV) LoadXML(f *os.File) error {
-	decoder := xml.NewDecoder(f)
-	decoder.CharsetReader = charset.NewReaderLabel
-
-	err := decoder.Decode(&t)
+	tv, err := Decode(f)
 	if err != nil {
 		return err
 	}
 
+	*t = *tv
 	return nil
 }
 
@@ -130,7 +126,8 @@ type Channel struct {
 
 	// These fields are outside of the XMLTV spec.
 	// LCN is the local channel number. Plex will show it in place of the channel ID if it exists.
-	LCN int `xml:"lcn"                   json:"lcn,omitempty"`
+	// It is an extension, xmltv.dtd doesn't have it.
+	LCN int `xml:"lcn,omitempty"         json:"lcn,omitempty"`
 }
 
 // Programme details of a single programme transmission
@@ -256,6 +253,16 @@ type PreviouslyShown struct {
 	Channel string `xml:"channel,attr,omitempty" json:"channel,omitempty"`
 }
 
+// StartTime parses Start, which may be cut short after any part like other XMLTV dates, and reports whether it could.
+func (p *PreviouslyShown) StartTime() (time.Time, bool) {
+	for _, layout := range []string{"20060102150405 -0700", "20060102150405", "200601021504", "2006010215", "20060102"} {
+		if start, parseErr := time.Parse(layout, strings.TrimSpace(p.Start)); parseErr == nil {
+			return start, true
+		}
+	}
+	return time.Time{}, false
+}
+
 // Subtitle in a programme
 type Subtitle struct {
 	Language *CommonElement `xml:"language,omitempty"  json:"language,omitempty"`
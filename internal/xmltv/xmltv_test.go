@@ -4,8 +4,12 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -139,3 +143,169 @@ func TestDecode(t *testing.T) {
 		t.Errorf("%s\n%s\n", expected, actual)
 	}
 }
+
+func TestDecodeDeclaredCharset(t *testing.T) {
+	// "Télé" encoded as ISO-8859-1.
+	input := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<tv><channel id=\"one\"><display-name>T\xe9l\xe9</display-name></channel></tv>"
+
+	tv, err := Decode(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := tv.Channels[0].DisplayNames[0].Value; got != "Télé" {
+		t.Errorf("expected display name Télé, got %q", got)
+	}
+}
+
+func TestDecodeBOMAndInvalidUTF8(t *testing.T) {
+	input := "\xef\xbb\xbf<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<tv><channel id=\"one\"><display-name>Bad \xff byte</display-name></channel></tv>"
+
+	tv, err := Decode(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := tv.Channels[0].DisplayNames[0].Value; got != "Bad � byte" {
+		t.Errorf("expected invalid byte to be replaced, got %q", got)
+	}
+}
+
+func TestDecodeErrorLine(t *testing.T) {
+	input := "<?xml version=\"1.0\"?>\n<tv>\n<channel id=\"one\">\n</tv>"
+
+	_, err := Decode(strings.NewReader(input))
+	decodeErr, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("expected a *DecodeError, got %#v", err)
+	}
+
+	if decodeErr.Line != 4 {
+		t.Errorf("expected error on line 4, got %d", decodeErr.Line)
+	}
+}
+
+func TestFilterProgrammes(t *testing.T) {
+	now := time.Date(2018, 8, 1, 12, 0, 0, 0, time.UTC)
+	programme := func(start, stop time.Duration) Programme {
+		return Programme{Start: &Time{now.Add(start)}, Stop: &Time{now.Add(stop)}}
+	}
+
+	programmes := []Programme{
+		programme(-48*time.Hour, -47*time.Hour), // Long gone.
+		programme(-30*time.Minute, 30*time.Minute),
+		programme(2*time.Hour, 3*time.Hour),
+		programme(72*time.Hour, 73*time.Hour), // Too far ahead.
+	}
+
+	filtered := FilterProgrammes(programmes, now.Add(-6*time.Hour), now.Add(24*time.Hour))
+	if len(filtered) != 2 {
+		t.Fatalf("expected 2 programmes inside the window, got %d", len(filtered))
+	}
+
+	shifted := filtered[0]
+	shifted.Shift(time.Hour)
+	if !filtered[0].Start.Equal(now.Add(-30*time.Minute)) || !shifted.Start.Equal(now.Add(30*time.Minute)) {
+		t.Errorf("expected Shift to only move the shifted copy, got %s and %s", filtered[0].Start, shifted.Start)
+	}
+}
+
+func TestEncodeValidatesAgainstDTD(t *testing.T) {
+	xmllint, lookErr := exec.LookPath("xmllint")
+	if lookErr != nil {
+		t.Skip("xmllint is not installed")
+	}
+
+	f, err := os.Open("example.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tv, err := Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Programme IDs are not part of the DTD.
+	for idx := range tv.Programmes {
+		tv.Programmes[idx].ID = ""
+	}
+
+	dtd, err := filepath.Abs("xmltv.dtd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.TempFile("", "xmltv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+
+	if err := NewEncoder(out).Encode(tv); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+
+	if output, err := exec.Command(xmllint, "--noout", "--dtdvalid", dtd, out.Name()).CombinedOutput(); err != nil {
+		t.Errorf("encoded document is not valid: %s\n%s", err, output)
+	}
+}
+
+func TestParseEpisodeNums(t *testing.T) {
+	tests := []struct {
+		epNum    EpisodeNum
+		expected string
+	}{
+		{EpisodeNum{System: "xmltv_ns", Value: "0 . 11/22 . 0/2"}, "0.11/22.0/2"},
+		{EpisodeNum{System: "xmltv_ns", Value: ".4."}, ".4."},
+		{EpisodeNum{System: "onscreen", Value: "S02E05"}, "1.4."},
+		{EpisodeNum{System: "onscreen", Value: "3x10"}, "2.9."},
+		{EpisodeNum{System: "onscreen", Value: "Season 1, Episode 2 (Part 1 of 2)"}, "0.1.0/2"},
+		{EpisodeNum{System: "onscreen", Value: "Ep. 5"}, ".4."},
+	}
+
+	for _, test := range tests {
+		episode, err := ParseEpisodeNum(test.epNum)
+		if err != nil {
+			t.Errorf("%s %q: %s", test.epNum.System, test.epNum.Value, err)
+			continue
+		}
+		if actual := episode.XMLTVNS(); actual != test.expected {
+			t.Errorf("%s %q: expected xmltv_ns %q, got %q", test.epNum.System, test.epNum.Value, test.expected, actual)
+		}
+	}
+
+	for _, invalid := range []EpisodeNum{{System: "xmltv_ns", Value: ".."}, {System: "onscreen", Value: "Pilot"}, {System: "dd_progid", Value: "XX123"}} {
+		if _, err := ParseEpisodeNum(invalid); err == nil {
+			t.Errorf("expected %s %q to be invalid", invalid.System, invalid.Value)
+		}
+	}
+}
+
+func TestNormaliseEpisodeNums(t *testing.T) {
+	programme := Programme{EpisodeNums: []EpisodeNum{
+		{System: "onscreen", Value: "S01E02"},
+		{System: "dd_progid", Value: "EP01234567.0012"},
+		{System: "original-air-date", Value: "2018-08-01"},
+		{System: "imdb.com", Value: "tt0123456"},
+	}}
+
+	programme.NormaliseEpisodeNums()
+
+	expected := []EpisodeNum{
+		{System: "dd_progid", Value: "EP01234567.0012"},
+		{System: "xmltv_ns", Value: "0.1."},
+		{System: "onscreen", Value: "S01E02"},
+		{System: "original-air-date", Value: "2018-08-01 00:00:00"},
+		{System: "imdb.com", Value: "tt0123456"},
+	}
+	if !reflect.DeepEqual(programme.EpisodeNums, expected) {
+		t.Errorf("expected %v, got %v", expected, programme.EpisodeNums)
+	}
+
+	if tmsID := programme.Episode().ProgID.TMSID(); tmsID != "EP012345670012" {
+		t.Errorf("expected TMS ID EP012345670012, got %s", tmsID)
+	}
+}
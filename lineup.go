@@ -6,51 +6,65 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
-	"regexp"
-	"sort"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	schedulesdirect "github.com/tellytv/go.schedulesdirect"
+	"github.com/tellytv/telly/internal/filter"
 	m3u "github.com/tellytv/telly/internal/m3uplus"
 	"github.com/tellytv/telly/internal/providers"
 	"github.com/tellytv/telly/internal/xmltv"
 )
 
-// var channelNumberRegex = regexp.MustCompile(`^[0-9]+[[:space:]]?$`).MatchString
-// var callSignRegex = regexp.MustCompile(`^[A-Z0-9]+$`).MatchString
-// var hdRegex = regexp.MustCompile(`hd|4k`)
-var xmlNSRegex = regexp.MustCompile(`(\d).(\d).(?:(\d)/(\d))?`)
-var ddProgIDRegex = regexp.MustCompile(`(?m)(EP|SH|MV|SP)(\d{7,8}).(\d+).?(?:(\d).(\d))?`)
-
 // hdHomeRunLineupItem is a HDHomeRun specification compatible representation of a Track available in the lineup.
 type hdHomeRunLineupItem struct {
 	XMLName xml.Name `xml:"Program"    json:"-"`
 
-	AudioCodec  string             `xml:",omitempty" json:",omitempty"`
-	DRM         convertibleBoolean `xml:",omitempty" json:",string,omitempty"`
-	Favorite    convertibleBoolean `xml:",omitempty" json:",string,omitempty"`
-	GuideName   string             `xml:",omitempty" json:",omitempty"`
-	GuideNumber int                `xml:",omitempty" json:",string,omitempty"`
-	HD          convertibleBoolean `xml:",omitempty" json:",string,omitempty"`
-	URL         string             `xml:",omitempty" json:",omitempty"`
-	VideoCodec  string             `xml:",omitempty" json:",omitempty"`
+	AudioCodec    string             `xml:",omitempty" json:",omitempty"`
+	DRM           convertibleBoolean `xml:",omitempty" json:",string,omitempty"`
+	Favorite      convertibleBoolean `xml:",omitempty" json:",string,omitempty"`
+	GuideName     string             `xml:",omitempty" json:",omitempty"`
+	GuideNumber   string             `xml:",omitempty" json:",omitempty"`
+	HD            convertibleBoolean `xml:",omitempty" json:",string,omitempty"`
+	Modulation    string             `xml:",omitempty" json:",omitempty"`
+	ProgramNumber int                `xml:",omitempty" json:",omitempty"`
+	Quality       string             `xml:",omitempty" json:",omitempty"`
+	URL           string             `xml:",omitempty" json:",omitempty"`
+	VideoCodec    string             `xml:",omitempty" json:",omitempty"`
 
 	provider        providers.Provider
 	providerChannel providers.ProviderChannel
+	// streams are the ranked streams of a channel carried by several sources.
+	streams []channelStream
+}
+
+// streamURI returns where the channel is streamed from, the preferred stream if it is carried by several sources.
+func (i hdHomeRunLineupItem) streamURI() *url.URL {
+	if len(i.streams) > 0 && i.streams[0].uri != nil {
+		return i.streams[0].uri
+	}
+	return i.providerChannel.Track.URI
 }
 
 func newHDHRItem(provider *providers.Provider, providerChannel *providers.ProviderChannel) hdHomeRunLineupItem {
 	return hdHomeRunLineupItem{
 		DRM:             convertibleBoolean(false),
 		GuideName:       providerChannel.Name,
-		GuideNumber:     providerChannel.Number,
+		GuideNumber:     providerChannel.Number.String(),
 		Favorite:        convertibleBoolean(providerChannel.Favorite),
 		HD:              convertibleBoolean(providerChannel.HD),
-		URL:             fmt.Sprintf("http://%s/auto/v%d", viper.GetString("web.base-address"), providerChannel.Number),
+		Quality:         providerChannel.Quality,
+		VideoCodec:      providerChannel.VideoCodec,
+		AudioCodec:      providerChannel.AudioCodec,
+		URL:             fmt.Sprintf("http://%s/auto/v%s", viper.GetString("web.base-address"), providerChannel.Number),
 		provider:        *provider,
 		providerChannel: *providerChannel,
 	}
@@ -59,19 +73,138 @@ func newHDHRItem(provider *providers.Provider, providerChannel *providers.Provid
 // lineup contains the state of the application.
 type lineup struct {
 	Sources []providers.Provider
+	// LastScan is when the lineup was last successfully scanned.
+	LastScan time.Time
 
-	Scanning bool
-
-	// Stores the channel number for found channels without a number.
-	assignedChannelNumber int
+	// The first channel number given to found channels without a number.
+	startingChannelNumber int
 	// If true, use channel numbers found in EPG, if any, before assigning.
 	xmlTVChannelNumbers bool
+	// What happens to a channel that wants a number already in use.
+	collisionPolicy collisionPolicy
+	// Remembers the numbers given to channels so they don't change between scans.
+	channelState *channelNumberState
+	// Measures the quality of streams, nil unless probing is turned on.
+	prober *streamProber
+	// Favorites are numbered from this range, which other channels are never automatically given. Zero disables it.
+	favoriteStart int
+	favoriteEnd   int
+
+	// The compiled filter rules of each source.
+	trackFilters map[providers.Provider]filter.Rules
+	// The compiled transforms of each source.
+	trackTransforms map[providers.Provider]*providers.TrackTransformer
+
+	channels map[providers.ChannelNumber]hdHomeRunLineupItem
+	// How many channels each source, by name, had in the last scan, to notice sources that suddenly have none.
+	sourceChannels map[string]int
+
+	// EPGMatchReport lists, per provider, how each channel was matched to its EPG channel.
+	EPGMatchReport map[string][]epgMatchReportEntry
+	// ConsolidationReport lists, per consolidated channel number, its streams in the order they are preferred.
+	ConsolidationReport map[string][]channelStream
+	// If true, channels carried by several sources become a single channel.
+	consolidate bool
+
+	// TransformReport lists, per provider, the tracks changed by transforms and what they became.
+	TransformReport map[string][]transformReportEntry
+
+	sd      *sdSession
+	sdCache *sdProgramCache
+	// How titles, descriptions and credits from Schedules Direct are merged into programmes.
+	sdMergeOptions sdMergeOptions
+	// SchedulesDirectStatus reports failures talking to Schedules Direct and when it will be tried again.
+	SchedulesDirectStatus schedulesDirectStatus
+
+	// How far into the past and future programmes are exposed in the EPG, zero means unlimited.
+	epgPastWindow   time.Duration
+	epgFutureWindow time.Duration
+	// If set, all programme times are rewritten into this timezone.
+	epgLocation *time.Location
+
+	// If true, channels without any EPG get a generated channel and filler programmes.
+	epgPlaceholder         bool
+	epgPlaceholderLength   time.Duration
+	epgPlaceholderCategory string
 
-	channels map[int]hdHomeRunLineupItem
+	FfmpegEnabled bool
 
-	sd *schedulesdirect.Client
+	// The virtual tuners streams are served through.
+	tuners *tunerPool
+}
 
-	FfmpegEnabled bool
+// liveLineup is the lineup being served. Scans and reloads build a new lineup and swap it in whole, so handlers,
+// which load the current lineup once per request, never see one that is half scanned or half reloaded.
+type liveLineup struct {
+	current atomic.Value
+	// Only one scan or reload runs at a time.
+	scanMu      sync.Mutex
+	scanRunning int32
+}
+
+func newLiveLineup(l *lineup) *liveLineup {
+	live := &liveLineup{}
+	live.current.Store(l)
+	return live
+}
+
+// load returns the current lineup, which must not be changed.
+func (live *liveLineup) load() *lineup {
+	return live.current.Load().(*lineup)
+}
+
+// scanning reports whether a scan or reload is running.
+func (live *liveLineup) scanning() bool {
+	return atomic.LoadInt32(&live.scanRunning) == 1
+}
+
+// rescan scans the sources of the current lineup again and swaps in the result.
+func (live *liveLineup) rescan() error {
+	return live.swap(func() (*lineup, error) {
+		next := live.load().clone()
+		return next, next.Scan()
+	})
+}
+
+// swap replaces the current lineup with the one built, unless building it fails.
+func (live *liveLineup) swap(build func() (*lineup, error)) error {
+	live.scanMu.Lock()
+	defer live.scanMu.Unlock()
+
+	atomic.StoreInt32(&live.scanRunning, 1)
+	defer atomic.StoreInt32(&live.scanRunning, 0)
+
+	next, buildErr := build()
+	if buildErr != nil {
+		return buildErr
+	}
+	live.current.Store(next)
+	return nil
+}
+
+// clone returns a copy of the lineup to scan again, sharing its configuration but not its channels or reports.
+func (l *lineup) clone() *lineup {
+	next := *l
+	next.channels = make(map[providers.ChannelNumber]hdHomeRunLineupItem)
+	next.EPGMatchReport = make(map[string][]epgMatchReportEntry)
+	next.TransformReport = make(map[string][]transformReportEntry)
+	next.ConsolidationReport = make(map[string][]channelStream)
+	return &next
+}
+
+// epgMatchReportEntry records which rule matched a channel to which EPG channel.
+type epgMatchReportEntry struct {
+	Channel    string
+	EPGChannel string `json:",omitempty"`
+	Rule       providers.EPGMatchRule
+}
+
+// transformReportEntry records how transforms rewrote a track.
+type transformReportEntry struct {
+	Original   string
+	Name       string
+	Tags       map[string]string
+	Transforms []string
 }
 
 // newLineup returns a new lineup for the given config struct.
@@ -103,19 +236,106 @@ func newLineup() *lineup {
 	}
 
 	lineup := &lineup{
-		assignedChannelNumber: viper.GetInt("iptv.starting-channel"),
-		xmlTVChannelNumbers:   viper.GetBool("iptv.xmltv-channels"),
-		channels:              make(map[int]hdHomeRunLineupItem),
-		FfmpegEnabled:         useFFMpeg,
+		startingChannelNumber:  viper.GetInt("iptv.starting-channel"),
+		xmlTVChannelNumbers:    viper.GetBool("iptv.xmltv-channels"),
+		collisionPolicy:        collisionNext,
+		favoriteStart:          viper.GetInt("iptv.favorite-start"),
+		favoriteEnd:            viper.GetInt("iptv.favorite-end"),
+		channels:               make(map[providers.ChannelNumber]hdHomeRunLineupItem),
+		trackFilters:           make(map[providers.Provider]filter.Rules),
+		trackTransforms:        make(map[providers.Provider]*providers.TrackTransformer),
+		TransformReport:        make(map[string][]transformReportEntry),
+		EPGMatchReport:         make(map[string][]epgMatchReportEntry),
+		epgPastWindow:          viper.GetDuration("epg.past-window"),
+		epgFutureWindow:        viper.GetDuration("epg.future-window"),
+		epgPlaceholder:         viper.GetBool("epg.placeholder"),
+		epgPlaceholderLength:   time.Hour,
+		epgPlaceholderCategory: viper.GetString("epg.placeholder-category"),
+		FfmpegEnabled:          useFFMpeg,
+		prober:                 newStreamProber(),
+		tuners:                 newTunerPool(viper.GetInt("iptv.streams"), time.Minute),
+		consolidate:            viper.GetBool("iptv.consolidate"),
+		ConsolidationReport:    make(map[string][]channelStream),
+	}
+
+	if lineup.favoriteStart > 0 && lineup.favoriteEnd < lineup.favoriteStart {
+		lineup.favoriteEnd = lineup.favoriteStart + 99
+	}
+
+	if viper.IsSet("iptv.channel-collision") {
+		policy, policyErr := parseCollisionPolicy(viper.GetString("iptv.channel-collision"))
+		if policyErr != nil {
+			log.WithError(policyErr).Panicln("invalid iptv.channel-collision")
+		}
+		lineup.collisionPolicy = policy
+	}
+
+	statePath := viper.GetString("iptv.state-file")
+	if !viper.IsSet("iptv.state-file") {
+		if configFile := viper.ConfigFileUsed(); configFile != "" {
+			statePath = filepath.Join(filepath.Dir(configFile), "telly.channel-numbers.json")
+		} else if cacheDir, cacheDirErr := os.UserCacheDir(); cacheDirErr == nil {
+			statePath = filepath.Join(cacheDir, "telly", "channel-numbers.json")
+		}
+	}
+
+	if statePath != "" {
+		log.Infof("Remembering channel numbers in %s", statePath)
+		lineup.channelState = loadChannelNumberState(statePath)
+	}
+
+	if viper.IsSet("iptv.redirect-hold") {
+		lineup.tuners.redirectHold = viper.GetDuration("iptv.redirect-hold")
+	}
+
+	if viper.IsSet("epg.placeholder-length") {
+		lineup.epgPlaceholderLength = viper.GetDuration("epg.placeholder-length")
+	}
+
+	if viper.IsSet("epg.timezone") {
+		location, locationErr := time.LoadLocation(viper.GetString("epg.timezone"))
+		if locationErr != nil {
+			log.WithError(locationErr).Panicln("unable to load the configured EPG timezone")
+		}
+
+		lineup.epgLocation = location
 	}
 
 	if viper.IsSet("schedulesdirect.username") && viper.IsSet("schedulesdirect.password") {
-		sdClient, sdClientErr := schedulesdirect.NewClient(viper.GetString("schedulesdirect.username"), viper.GetString("schedulesdirect.password"))
-		if sdClientErr != nil {
-			log.WithError(sdClientErr).Panicln("error setting up schedules direct client")
+		sdSession, sdSessionErr := newSDSession(viper.GetString("schedulesdirect.username"), viper.GetString("schedulesdirect.password"))
+		if sdSessionErr != nil {
+			log.WithError(sdSessionErr).Panicln("error setting up schedules direct client")
+		}
+
+		lineup.sd = sdSession
+
+		cachePath := viper.GetString("schedulesdirect.cache-file")
+		if !viper.IsSet("schedulesdirect.cache-file") {
+			if cacheDir, cacheDirErr := os.UserCacheDir(); cacheDirErr == nil {
+				cachePath = filepath.Join(cacheDir, "telly", "schedulesdirect-programs.json")
+			}
+		}
+
+		cacheExpiry := sdDefaultCacheExpiry
+		if viper.IsSet("schedulesdirect.cache-expiry") {
+			cacheExpiry = viper.GetDuration("schedulesdirect.cache-expiry")
+		}
+
+		if cachePath != "" {
+			lineup.sdCache = loadSDProgramCache(cachePath, cacheExpiry)
+		}
+
+		lineup.sdMergeOptions = sdMergeOptions{
+			Languages:          viper.GetStringSlice("schedulesdirect.languages"),
+			LongestDescription: true,
+			Artwork:            newSDArtworkPolicy(),
 		}
 
-		lineup.sd = sdClient
+		longest, policyErr := parseDescriptionPolicy(viper.GetString("schedulesdirect.description-policy"))
+		if policyErr != nil {
+			log.WithError(policyErr).Panicln("invalid schedulesdirect.description-policy")
+		}
+		lineup.sdMergeOptions.LongestDescription = longest
 	}
 
 	for _, cfg := range cfgs {
@@ -124,65 +344,206 @@ func newLineup() *lineup {
 			panic(providerErr)
 		}
 
+		rules, rulesErr := cfg.CompileFilterRules(provider.RegexKey())
+		if rulesErr != nil {
+			log.WithError(rulesErr).Panicf("invalid filter for source %s", provider.Name())
+		}
+
+		transformer, transformErr := cfg.CompileTransforms()
+		if transformErr != nil {
+			log.WithError(transformErr).Panicf("invalid transform for source %s", provider.Name())
+		}
+
+		lineup.trackFilters[provider] = rules
+		lineup.trackTransforms[provider] = transformer
 		lineup.Sources = append(lineup.Sources, provider)
 	}
 
 	return lineup
 }
 
-// Scan processes all sources.
-func (l *lineup) Scan() error {
+// checkLineupConfig reports what in the configuration would keep newLineup from building a lineup, so a reloaded
+// configuration can be checked before it replaces the running one.
+func checkLineupConfig(config *viper.Viper) error {
+	if config.IsSet("log.level") {
+		if _, levelErr := logrus.ParseLevel(config.GetString("log.level")); levelErr != nil {
+			return levelErr
+		}
+	}
 
-	l.Scanning = true
+	if config.IsSet("iptv.channel-collision") {
+		if _, policyErr := parseCollisionPolicy(config.GetString("iptv.channel-collision")); policyErr != nil {
+			return policyErr
+		}
+	}
 
-	totalAddedChannels := 0
+	if config.IsSet("epg.timezone") {
+		if _, locationErr := time.LoadLocation(config.GetString("epg.timezone")); locationErr != nil {
+			return fmt.Errorf("unable to load the configured EPG timezone: %s", locationErr)
+		}
+	}
+
+	if _, policyErr := parseDescriptionPolicy(config.GetString("schedulesdirect.description-policy")); policyErr != nil {
+		return policyErr
+	}
+
+	var cfgs []providers.Configuration
+	if unmarshalErr := config.UnmarshalKey("source", &cfgs); unmarshalErr != nil {
+		return fmt.Errorf("unable to unmarshal source configuration: %s", unmarshalErr)
+	}
+
+	for _, cfg := range cfgs {
+		provider, providerErr := cfg.GetProvider()
+		if providerErr != nil {
+			return providerErr
+		}
+		if _, rulesErr := cfg.CompileFilterRules(provider.RegexKey()); rulesErr != nil {
+			return fmt.Errorf("invalid filter for source %s: %s", provider.Name(), rulesErr)
+		}
+		if _, transformErr := cfg.CompileTransforms(); transformErr != nil {
+			return fmt.Errorf("invalid transform for source %s: %s", provider.Name(), transformErr)
+		}
+	}
+
+	return nil
+}
+
+// parseCollisionPolicy parses the iptv.channel-collision setting.
+func parseCollisionPolicy(value string) (collisionPolicy, error) {
+	switch policy := collisionPolicy(strings.ToLower(value)); policy {
+	case collisionNext, collisionSkip:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown channel collision policy %s, must be next or skip", policy)
+	}
+}
+
+// parseDescriptionPolicy parses the schedulesdirect.description-policy setting, reporting whether the longest
+// description is preferred.
+func parseDescriptionPolicy(value string) (bool, error) {
+	switch policy := strings.ToLower(value); policy {
+	case "", "longest":
+		return true, nil
+	case "shortest":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown Schedules Direct description policy %s, must be longest or shortest", policy)
+	}
+}
+
+// epgWindow returns the period of time that programmes must air in to be exposed in the EPG.
+func (l *lineup) epgWindow(now time.Time) (time.Time, time.Time) {
+	var from, to time.Time
+	if l.epgPastWindow > 0 {
+		from = now.Add(-l.epgPastWindow)
+	}
+	if l.epgFutureWindow > 0 {
+		to = now.Add(l.epgFutureWindow)
+	}
+	return from, to
+}
+
+// epgMovesWithTime reports whether the EPG contents depend on the current time rather than only on the last scan.
+func (l *lineup) epgMovesWithTime() bool {
+	return l.epgPastWindow > 0 || l.epgFutureWindow > 0 || l.epgPlaceholder
+}
+
+// maxChannels is the most channels, not counting favorites, Plex deals well with.
+const maxChannels = 420
+
+// sourceScanError lists the sources a scan got no channels from, either because they failed or because they have
+// none anymore.
+type sourceScanError []string
+
+func (e sourceScanError) Error() string {
+	return fmt.Sprintf("no channels from %s", strings.Join(e, "; "))
+}
+
+// Scan processes all sources. If a source fails, or a source that had channels in the last scan has none anymore, the
+// lineup is still scanned but a sourceScanError is returned.
+func (l *lineup) Scan() error {
+	pending := make([]pendingChannel, 0)
+	sourceChannels := make(map[string]int, len(l.Sources))
+	failed := make(sourceScanError, 0)
 
 	for _, provider := range l.Sources {
 		addedChannels, providerErr := l.processProvider(provider)
+		sourceChannels[provider.Name()] = len(addedChannels)
 		if providerErr != nil {
 			log.WithError(providerErr).Errorln("error when processing provider")
+			failed = append(failed, fmt.Sprintf("source %s: %s", provider.Name(), providerErr))
+			// Keep the last count, so the source keeps failing scans until it has channels again.
+			sourceChannels[provider.Name()] = l.sourceChannels[provider.Name()]
+		} else if len(addedChannels) == 0 && l.sourceChannels[provider.Name()] > 0 {
+			failed = append(failed, fmt.Sprintf("source %s, which had %d channels", provider.Name(), l.sourceChannels[provider.Name()]))
+			sourceChannels[provider.Name()] = l.sourceChannels[provider.Name()]
+		}
+		for _, channel := range addedChannels {
+			pending = append(pending, pendingChannel{provider: provider, channel: channel})
+		}
+	}
+
+	l.prober.probe(pending)
+
+	if l.consolidate {
+		pending = l.consolidateChannels(pending)
+	}
+
+	l.channels = l.assignChannelNumbers(pending)
+	if l.consolidate {
+		l.ConsolidationReport = consolidationReport(l.channels)
+	}
+
+	// Favorites don't count towards the limit, so they can always be added.
+	totalAddedChannels := 0
+	for _, channel := range l.channels {
+		if !channel.providerChannel.Favorite {
+			totalAddedChannels++
 		}
-		totalAddedChannels = totalAddedChannels + addedChannels
 	}
 
-	if totalAddedChannels > 420 {
-		log.Panicf("telly has loaded more than 420 channels (%d) into the lineup. Plex does not deal well with more than this amount and will more than likely hang when trying to fetch channels. You must use regular expressions to filter out channels. You can also start another Telly instance.", totalAddedChannels)
+	if totalAddedChannels > maxChannels {
+		return fmt.Errorf("telly has loaded more than %d channels (%d, not counting favorites) into the lineup. Plex does not deal well with more than this amount and will more than likely hang when trying to fetch channels. You must use regular expressions to filter out channels. You can also start another Telly instance", maxChannels, totalAddedChannels)
 	}
 
-	l.Scanning = false
+	l.sourceChannels = sourceChannels
+	l.LastScan = time.Now()
 
+	if len(failed) > 0 {
+		return failed
+	}
 	return nil
 }
 
-func (l *lineup) processProvider(provider providers.Provider) (int, error) {
-	addedChannels := 0
+// processProvider returns the channels of the provider that passed the filters, without channel numbers assigned yet.
+func (l *lineup) processProvider(provider providers.Provider) ([]*providers.ProviderChannel, error) {
+	addedChannels := make([]*providers.ProviderChannel, 0)
 	m3u, channelMap, programmeMap, prepareErr := l.prepareProvider(provider)
 	if prepareErr != nil {
 		log.WithError(prepareErr).Errorln("error when preparing provider")
-		return 0, prepareErr
+		return addedChannels, prepareErr
 	}
 
-	if provider.Configuration().SortKey != "" {
-		sortKey := provider.Configuration().SortKey
-		sort.Slice(m3u.Tracks, func(i, j int) bool {
-			if _, ok := m3u.Tracks[i].Tags[sortKey]; ok {
-				log.Panicf("the provided sort key (%s) doesn't exist in the M3U!", sortKey)
-				return false
-			}
-			ii := m3u.Tracks[i].Tags[sortKey]
-			jj := m3u.Tracks[j].Tags[sortKey]
-			if provider.Configuration().SortReverse {
-				return ii < jj
-			}
-			return ii > jj
-		})
-	}
+	providerConfig := provider.Configuration()
+	epgMatcher := providers.NewEPGMatcher(channelMap, providerConfig.EPGOverrideMap())
+	matchReport := make([]epgMatchReportEntry, 0)
+	matchCounts := make(map[providers.EPGMatchRule]int)
+
+	transformReport := make([]transformReportEntry, 0)
 
 	successChannels := []string{}
 	failedChannels := []string{}
 
 	for _, track := range m3u.Tracks {
-		// First, we run the filter.
+		// First, we rewrite the track.
+		original := track.Name
+		var applied []string
+		track, applied = l.trackTransforms[provider].Apply(track)
+		if len(applied) > 0 {
+			transformReport = append(transformReport, transformReportEntry{Original: original, Name: track.Name, Tags: track.Tags, Transforms: applied})
+		}
+
+		// Then we run the filter.
 		if !l.FilterTrack(provider, track) {
 			failedChannels = append(failedChannels, track.Name)
 			continue
@@ -191,10 +552,16 @@ func (l *lineup) processProvider(provider providers.Provider) (int, error) {
 		}
 
 		// Then we do the provider specific translation to a hdHomeRunLineupItem.
-		channel, channelErr := provider.ParseTrack(track, channelMap)
+		channel, channelErr := provider.ParseTrack(track, epgMatcher)
 		if channelErr != nil {
 			return addedChannels, channelErr
 		}
+		channel.Favorite = providerConfig.IsFavorite(track)
+
+		if len(channelMap) > 0 {
+			matchReport = append(matchReport, epgMatchReportEntry{Channel: channel.Name, EPGChannel: channel.EPGMatch, Rule: channel.EPGMatchRule})
+			matchCounts[channel.EPGMatchRule]++
+		}
 
 		channel, processErr := l.processProviderChannel(channel, programmeMap)
 		if processErr != nil {
@@ -204,17 +571,35 @@ func (l *lineup) processProvider(provider providers.Provider) (int, error) {
 			log.Infof("Channel %s was returned empty from the provider (%s)", track.Name, provider.Name())
 			continue
 		}
-		addedChannels = addedChannels + 1
-
-		l.channels[channel.Number] = newHDHRItem(&provider, channel)
+		addedChannels = append(addedChannels, channel)
 	}
 
+	// The order of the channels decides the order automatic channel numbers are given in.
+	providerConfig.ChannelOrder().Sort(addedChannels)
+
 	log.Debugf("These channels (%d) passed the filter and successfully parsed: %s", len(successChannels), strings.Join(successChannels, ", "))
 	log.Debugf("These channels (%d) did NOT pass the filter: %s", len(failedChannels), strings.Join(failedChannels, ", "))
 
-	log.Infof("Loaded %d channels into the lineup from %s", addedChannels, provider.Name())
+	log.Infof("Loaded %d channels into the lineup from %s", len(addedChannels), provider.Name())
+
+	l.TransformReport[provider.Name()] = transformReport
+	if len(transformReport) > 0 {
+		log.Infof("Transforms changed %d tracks from %s", len(transformReport), provider.Name())
+	}
+
+	if len(channelMap) > 0 {
+		l.EPGMatchReport[provider.Name()] = matchReport
+		log.Infof("Matched channels to EPG from %s: %d by override, %d by ID, %d by display name, %d unmatched", provider.Name(), matchCounts[providers.EPGMatchOverride], matchCounts[providers.EPGMatchID], matchCounts[providers.EPGMatchName], matchCounts[providers.EPGMatchNone])
+		for _, entry := range matchReport {
+			if entry.Rule == providers.EPGMatchNone {
+				log.Debugf("Channel %s could not be matched to the EPG", entry.Channel)
+			} else {
+				log.Debugf("Channel %s matched EPG channel %s by %s", entry.Channel, entry.EPGChannel, entry.Rule)
+			}
+		}
+	}
 
-	if addedChannels == 0 {
+	if len(addedChannels) == 0 {
 		log.Infof("Check your filter; %d channels were blocked by it", len(failedChannels))
 	}
 
@@ -260,13 +645,18 @@ func (l *lineup) processProviderChannel(channel *providers.ProviderChannel, prog
 		channel.EPGProgrammes = programmeMap[channel.EPGMatch]
 	}
 
-	if !l.xmlTVChannelNumbers || channel.Number == 0 {
-		channel.Number = l.assignedChannelNumber
-		l.assignedChannelNumber = l.assignedChannelNumber + 1
+	return channel, nil
+}
+
+// finishChannel fills in everything that depends on the channel number, once it is assigned.
+func (l *lineup) finishChannel(channel *providers.ProviderChannel) {
+	if channel.EPGChannel == nil && l.epgPlaceholder {
+		channel.EPGChannel = placeholderChannel(channel)
+		channel.EPGPlaceholder = true
 	}
 
 	if channel.EPGChannel != nil && channel.EPGChannel.LCN == 0 {
-		channel.EPGChannel.LCN = channel.Number
+		channel.EPGChannel.LCN = channel.Number.Major
 	}
 
 	if channel.Logo != "" && channel.EPGChannel != nil && !containsIcon(channel.EPGChannel.Icons, channel.Logo) {
@@ -275,62 +665,94 @@ func (l *lineup) processProviderChannel(channel *providers.ProviderChannel, prog
 		}
 		channel.EPGChannel.Icons = append(channel.EPGChannel.Icons, xmltv.Icon{Source: channel.Logo})
 	}
-
-	return channel, nil
 }
 
+// FilterTrack reports whether the track passes the filter rules of the provider.
 func (l *lineup) FilterTrack(provider providers.Provider, track m3u.Track) bool {
-	config := provider.Configuration()
-	if config.Filter == "" && len(config.IncludeOnly) == 0 {
-		return true
+	admitted, rule := l.trackFilters[provider].Evaluate(track)
+	if rule != nil {
+		log.Debugf("Track %s was decided by filter rule %s", track.Name, rule)
 	}
+	return admitted
+}
 
-	if v, ok := track.Tags[config.IncludeOnlyTag]; len(config.IncludeOnly) > 0 && ok {
-		return contains(config.IncludeOnly, v)
-	}
+// dryRunFilters prints, for every track of every source, whether it would be in the lineup and which rule decided.
+func (l *lineup) dryRunFilters(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
 
-	filterRegex, regexErr := regexp.Compile(config.Filter)
-	if regexErr != nil {
-		log.WithError(regexErr).Panicln("your regex is invalid")
-		return false
-	}
+	for _, provider := range l.Sources {
+		reader, m3uErr := getM3U(provider.PlaylistURL(), provider.Configuration().CacheFiles)
+		if m3uErr != nil {
+			return m3uErr
+		}
 
-	if config.FilterRaw {
-		return filterRegex.MatchString(track.Raw)
-	}
+		playlist, decodeErr := m3u.Decode(reader)
+		reader.Close()
+		if decodeErr != nil {
+			return decodeErr
+		}
 
-	log.Debugf("track.Tags %+v", track.Tags)
+		fmt.Fprintf(tw, "SOURCE %s\t\t\n", provider.Name())
 
-	filterKey := provider.RegexKey()
-	if config.FilterKey != "" {
-		filterKey = config.FilterKey
-	}
+		admittedCount := 0
+		for _, track := range playlist.Tracks {
+			track, _ = l.trackTransforms[provider].Apply(track)
+			admitted, rule := l.trackFilters[provider].Evaluate(track)
 
-	if key, ok := track.Tags[filterKey]; key != "" && !ok {
-		log.Warnf("the provided filter key (%s) does not exist or is blank, skipping track: %s", config.FilterKey, track.Raw)
-		return false
-	}
+			verdict := "REJECT"
+			if admitted {
+				verdict = "ADMIT"
+				admittedCount++
+			}
+
+			reason := "no rule matched"
+			if rule != nil {
+				reason = rule.String()
+			}
 
-	log.Debugf("Checking if filter (%s) matches string %s", config.Filter, track.Tags[filterKey])
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", verdict, track.Name, reason)
+		}
 
-	return filterRegex.MatchString(track.Tags[filterKey])
+		fmt.Fprintf(tw, "%d of %d tracks admitted\t\t\n\n", admittedCount, len(playlist.Tracks))
+	}
 
+	return tw.Flush()
 }
 
 func (l *lineup) prepareEPG(provider providers.Provider, cacheFiles bool) (map[string]xmltv.Channel, map[string][]xmltv.Programme, error) {
 	var epg *xmltv.TV
 	epgChannelMap := make(map[string]xmltv.Channel)
 	epgProgrammeMap := make(map[string][]xmltv.Programme)
-	if provider.EPGURL() != "" {
+	sdConfig := provider.Configuration().SchedulesDirect
+	if provider.EPGURL() != "" || (sdConfig != nil && len(sdConfig.Lineups) > 0) {
+		augmentWithSD := viper.IsSet("schedulesdirect.username") && viper.IsSet("schedulesdirect.password")
+
 		var epgErr error
-		epg, epgErr = getXMLTV(provider.EPGURL(), cacheFiles)
+		if provider.EPGURL() != "" {
+			epg, epgErr = getXMLTV(provider.EPGURL(), cacheFiles)
+		} else {
+			epg, epgErr = l.getSchedulesDirectXMLTV(sdConfig)
+			// Programmes built from Schedules Direct already carry everything it knows about them.
+			augmentWithSD = false
+			if epgErr == nil {
+				l.sd.keepGuide(provider.Name(), epg)
+			} else if epg = l.sd.lastGuide(provider.Name()); epg != nil {
+				log.WithError(epgErr).Warnf("unable to build the guide for %s from Schedules Direct, using the last one built", provider.Name())
+				epgErr = nil
+			} else {
+				log.WithError(epgErr).Errorf("unable to build the guide for %s from Schedules Direct, its channels will have no EPG", provider.Name())
+				return epgChannelMap, epgProgrammeMap, nil
+			}
+		}
 		if epgErr != nil {
 			return epgChannelMap, epgProgrammeMap, epgErr
 		}
 
-		augmentWithSD := viper.IsSet("schedulesdirect.username") && viper.IsSet("schedulesdirect.password")
+		if augmentWithSD && !l.sdReady() {
+			augmentWithSD = false
+		}
 
-		sdEligible := make(map[string]xmltv.Programme)    // TMSID:programme
+		sdEligible := make(map[string][]xmltv.Programme)  // TMSID:[]programme
 		haveAllInfo := make(map[string][]xmltv.Programme) // channel number:[]programme
 
 		for _, channel := range epg.Channels {
@@ -338,27 +760,13 @@ func (l *lineup) prepareEPG(provider providers.Provider, cacheFiles bool) (map[s
 
 			for _, programme := range epg.Programmes {
 				if programme.Channel == channel.ID {
-					ddProgID := ""
+					var progID *xmltv.DDProgID
 					if augmentWithSD {
-						for _, epNum := range programme.EpisodeNums {
-							if epNum.System == "dd_progid" {
-								ddProgID = epNum.Value
-							}
-						}
+						progID = programme.Episode().ProgID
 					}
-					if augmentWithSD == true && ddProgID != "" {
-						idType, uniqID, epID, _, _, extractErr := extractDDProgID(ddProgID)
-						if extractErr != nil {
-							log.WithError(extractErr).Errorln("error extracting dd_progid")
-							continue
-						}
-						cleanID := fmt.Sprintf("%s%s%s", idType, padNumberWithZero(uniqID, 8), padNumberWithZero(epID, 4))
-						if len(cleanID) < 14 {
-							log.Warnf("found an invalid TMS ID/dd_progid, expected length of exactly 14, got %d: %s\n", len(cleanID), cleanID)
-							continue
-						}
-
-						sdEligible[cleanID] = programme
+					if progID != nil {
+						tmsID := progID.TMSID()
+						sdEligible[tmsID] = append(sdEligible[tmsID], programme)
 					} else {
 						haveAllInfo[channel.ID] = append(haveAllInfo[channel.ID], programme)
 					}
@@ -370,101 +778,58 @@ func (l *lineup) prepareEPG(provider providers.Provider, cacheFiles bool) (map[s
 			tmsIDs := make([]string, 0)
 
 			for tmsID := range sdEligible {
-				idType, uniqID, epID, _, _, extractErr := extractDDProgID(tmsID)
-				if extractErr != nil {
-					log.WithError(extractErr).Errorln("error extracting dd_progid")
-					continue
-				}
-				cleanID := fmt.Sprintf("%s%s%s", idType, padNumberWithZero(uniqID, 8), padNumberWithZero(epID, 4))
-				if len(cleanID) < 14 {
-					log.Warnf("found an invalid TMS ID/dd_progid, expected length of exactly 14, got %d: %s\n", len(cleanID), cleanID)
-					continue
-				}
-				tmsIDs = append(tmsIDs, cleanID)
+				tmsIDs = append(tmsIDs, tmsID)
 			}
 
-			log.Infof("Requesting guide data for %d programs from Schedules Direct", len(tmsIDs))
-
-			allResponses := make([]schedulesdirect.ProgramInfo, 0)
-
-			artworkMap := make(map[string][]schedulesdirect.ProgramArtwork)
-
-			chunks := chunkStringSlice(tmsIDs, 5000)
-
-			log.Infof("Making %d requests to Schedules Direct for program information, this might take a while", len(chunks))
-
-			for _, chunk := range chunks {
-				moreInfo, moreInfoErr := l.sd.GetProgramInfo(chunk)
-				if moreInfoErr != nil {
-					log.WithError(moreInfoErr).Errorln("Error when getting more program details from Schedules Direct")
-					return epgChannelMap, epgProgrammeMap, moreInfoErr
+			// Channels of guides made from Schedules Direct, like tv_grab_zz_sdjson's, are its stations, whose schedules
+			// tell which cached programs changed. Programs of other channels are cached for a shorter time instead.
+			stationIDs := make([]string, 0)
+			for _, channel := range epg.Channels {
+				if stationID, ok := providers.SchedulesDirectStationID(channel.ID); ok {
+					stationIDs = append(stationIDs, stationID)
 				}
-
-				log.Debugf("received %d responses for chunk", len(moreInfo))
-
-				allResponses = append(allResponses, moreInfo...)
 			}
 
-			artworkTMSIDs := make([]string, 0)
-
-			for _, entry := range allResponses {
-				if entry.HasArtwork() {
-					artworkTMSIDs = append(artworkTMSIDs, entry.ProgramID)
-				}
+			md5s, md5sErr := l.getSchedulesDirectMD5s(stationIDs, sdScheduleDates(sdEligible, time.Now()))
+			if md5sErr != nil {
+				log.WithError(md5sErr).Warnln("unable to get schedules from Schedules Direct to tell which programs changed")
 			}
 
-			chunks = chunkStringSlice(artworkTMSIDs, 500)
-
-			log.Infof("Making %d requests to Schedules Direct for artwork, this might take a while", len(chunks))
+			log.Infof("Requesting guide data for %d programs from Schedules Direct", len(tmsIDs))
 
-			for _, chunk := range chunks {
-				artwork, artworkErr := l.sd.GetArtworkForProgramIDs(chunk)
-				if artworkErr != nil {
-					log.WithError(artworkErr).Errorln("Error when getting program artwork from Schedules Direct")
-					return epgChannelMap, epgProgrammeMap, artworkErr
-				}
+			allResponses, artworkMap, programsErr := l.getSchedulesDirectPrograms(tmsIDs, md5s)
 
-				for _, artworks := range artwork {
-					if artworks.ProgramID == "" || artworks.Artwork == nil {
-						continue
+			if programsErr == nil {
+				for _, sdResponse := range allResponses {
+					for _, programme := range sdEligible[sdResponse.ProgramID] {
+						mergedProgramme := MergeSchedulesDirectAndXMLTVProgramme(&programme, sdResponse, artworkMap[sdResponse.ProgramID], l.sdMergeOptions)
+						haveAllInfo[mergedProgramme.Channel] = append(haveAllInfo[mergedProgramme.Channel], *mergedProgramme)
 					}
-					artworkMap[artworks.ProgramID] = append(artworkMap[artworks.ProgramID], *artworks.Artwork...)
+					delete(sdEligible, sdResponse.ProgramID)
 				}
+			} else {
+				log.WithError(programsErr).Warnln("Schedules Direct is unavailable, using programmes from the XMLTV as they are")
 			}
 
-			log.Debugf("Got %d responses from SD", len(allResponses))
-
-			for _, sdResponse := range allResponses {
-				programme := sdEligible[sdResponse.ProgramID]
-				mergedProgramme := MergeSchedulesDirectAndXMLTVProgramme(&programme, sdResponse, artworkMap[sdResponse.ProgramID])
-				haveAllInfo[mergedProgramme.Channel] = append(haveAllInfo[mergedProgramme.Channel], *mergedProgramme)
+			// Anything Schedules Direct didn't return information for is kept as it came.
+			for _, programmes := range sdEligible {
+				for _, programme := range programmes {
+					haveAllInfo[programme.Channel] = append(haveAllInfo[programme.Channel], programme)
+				}
 			}
 		}
 
 		for _, programmes := range haveAllInfo {
 			for _, programme := range programmes {
 				processedProgram := *provider.ProcessProgramme(programme)
-				hasXMLTV := false
-				itemType := ""
-				for _, epNum := range processedProgram.EpisodeNums {
-					if epNum.System == "dd_progid" {
-						idType, _, _, _, _, extractErr := extractDDProgID(epNum.Value)
-						if extractErr != nil {
-							log.WithError(extractErr).Errorln("error extracting dd_progid")
-							continue
-						}
-						itemType = idType
-					}
-					if epNum.System == "xmltv_ns" {
-						hasXMLTV = true
-					}
+				if timeShift := provider.Configuration().EPGTimeShift; timeShift != 0 {
+					processedProgram.Shift(timeShift)
 				}
-				if (itemType == "SH" || itemType == "EP") && !hasXMLTV {
-					t := time.Time(processedProgram.Date)
-					if !t.IsZero() {
-						processedProgram.EpisodeNums = append(processedProgram.EpisodeNums, xmltv.EpisodeNum{System: "original-air-date", Value: t.Format("2006-01-02 15:04:05")})
-					}
+				if l.epgLocation != nil {
+					processedProgram.In(l.epgLocation)
 				}
+				applyPlexConventions(&processedProgram, classifyProgramme(&processedProgram))
+				processedProgram.NormaliseEpisodeNums()
 				epgProgrammeMap[programme.Channel] = append(epgProgrammeMap[programme.Channel], processedProgram)
 			}
 		}
@@ -494,11 +859,11 @@ func getXMLTV(path string, cacheFiles bool) (*xmltv.TV, error) {
 		return nil, err
 	}
 
-	decoder := xml.NewDecoder(file)
-	tvSetup := new(xmltv.TV)
-	if err := decoder.Decode(tvSetup); err != nil {
-		log.WithError(err).Errorln("Could not decode xmltv programme")
-		return nil, err
+	tvSetup, decodeErr := xmltv.Decode(file)
+	if decodeErr != nil {
+		log.WithError(decodeErr).Errorln("Could not decode xmltv programme")
+		file.Close()
+		return nil, decodeErr
 	}
 
 	if closeXMLErr := file.Close(); closeXMLErr != nil {
@@ -588,253 +953,78 @@ func chunkStringSlice(sl []string, chunkSize int) [][]string {
 	return divided
 }
 
-func MergeSchedulesDirectAndXMLTVProgramme(programme *xmltv.Programme, sdProgram schedulesdirect.ProgramInfo, artworks []schedulesdirect.ProgramArtwork) *xmltv.Programme {
-
-	allTitles := make([]string, 0)
-
-	for _, title := range programme.Titles {
-		allTitles = append(allTitles, title.Value)
-	}
+// MergeSchedulesDirectAndXMLTVProgramme adds the Schedules Direct program information to the programme.
+func MergeSchedulesDirectAndXMLTVProgramme(programme *xmltv.Programme, sdProgram schedulesdirect.ProgramInfo, artworks []schedulesdirect.ProgramArtwork, options sdMergeOptions) *xmltv.Programme {
+	sdLang := schedulesDirectLanguage(sdProgram)
 
+	titles := programme.Titles
 	for _, title := range sdProgram.Titles {
-		allTitles = append(allTitles, title.Title120)
-	}
-
-	for _, title := range UniqueStrings(allTitles) {
-		programme.Titles = append(programme.Titles, xmltv.CommonElement{Value: title})
+		titles = appendUniqueElement(titles, xmltv.CommonElement{Lang: sdLang, Value: title.Title120})
 	}
+	programme.Titles = sortByLanguage(titles, options.Languages)
 
-	allKeywords := make([]string, 0)
-
-	for _, keyword := range programme.Keywords {
-		allKeywords = append(allKeywords, keyword.Value)
-	}
-
-	for _, keywords := range sdProgram.Keywords {
-		for _, keyword := range keywords {
-			allKeywords = append(allKeywords, keyword)
-		}
-	}
-
-	for _, keyword := range UniqueStrings(allKeywords) {
-		programme.Keywords = append(programme.Keywords, xmltv.CommonElement{Value: keyword})
-	}
-
-	// FIXME: We should really be making sure that we passthrough languages.
-	allDescriptions := make([]string, 0)
-
-	for _, description := range programme.Descriptions {
-		allDescriptions = append(allDescriptions, description.Value)
-	}
-
-	for _, descriptions := range sdProgram.Descriptions {
-		for _, description := range descriptions {
-			if description.Description != "" {
-				allDescriptions = append(allDescriptions, description.Description)
+	descriptions := programme.Descriptions
+	for _, key := range sortedDescriptionKeys(sdProgram.Descriptions) {
+		for _, description := range sdProgram.Descriptions[key] {
+			if description.Description == "" {
+				continue
 			}
-			if description.Description != "" {
-				allDescriptions = append(allDescriptions, description.Description)
+			lang := description.Language
+			if lang == "" {
+				lang = sdLang
 			}
+			descriptions = append(descriptions, xmltv.CommonElement{Lang: lang, Value: description.Description})
 		}
 	}
+	programme.Descriptions = sortByLanguage(pickDescriptions(descriptions, options.LongestDescription), options.Languages)
 
-	for _, description := range UniqueStrings(allDescriptions) {
-		programme.Descriptions = append(programme.Descriptions, xmltv.CommonElement{Value: description})
-	}
-
-	allRatings := make(map[string]string, 0)
-
-	for _, rating := range programme.Ratings {
-		allRatings[rating.System] = rating.Value
-	}
-
-	for _, rating := range sdProgram.ContentRating {
-		allRatings[rating.Body] = rating.Code
-	}
-
-	for system, rating := range allRatings {
-		programme.Ratings = append(programme.Ratings, xmltv.Rating{Value: rating, System: system})
-	}
-
-	for _, artwork := range artworks {
-		programme.Icons = append(programme.Icons, xmltv.Icon{
-			Source: getImageURL(artwork.URI),
-			Width:  artwork.Width,
-			Height: artwork.Height,
-		})
+	for _, key := range sortedKeywordKeys(sdProgram.Keywords) {
+		for _, keyword := range sdProgram.Keywords[key] {
+			programme.Keywords = appendUniqueElement(programme.Keywords, xmltv.CommonElement{Value: keyword})
+		}
 	}
 
-	hasXMLTVNS := false
-	ddProgID := ""
-
-	for _, epNum := range programme.EpisodeNums {
-		if epNum.System == "xmltv_ns" {
-			hasXMLTVNS = true
-		} else if epNum.System == "dd_progid" {
-			ddProgID = epNum.Value
-		}
+	// Schedules Direct genres are always in English.
+	for _, genre := range sdProgram.Genres {
+		programme.Categories = appendUniqueElement(programme.Categories, xmltv.CommonElement{Lang: "en", Value: genre})
 	}
 
-	if !hasXMLTVNS {
-		seasonNumber := 0
-		episodeNumber := 0
-		totalSeasons := 0
-		totalEpisodes := 0
-		numbersFilled := false
+	programme.Credits = mergeSchedulesDirectCredits(programme.Credits, sdProgram.Cast, sdProgram.Crew)
 
-		for _, meta := range sdProgram.Metadata {
-			for _, metadata := range meta {
-				if metadata.Season > 0 {
-					seasonNumber = metadata.Season - 1 // SD metadata isnt 0 index
-					numbersFilled = true
-				}
-				if metadata.Episode > 0 {
-					episodeNumber = metadata.Episode - 1
-					numbersFilled = true
-				}
-				if metadata.TotalEpisodes > 0 {
-					totalEpisodes = metadata.TotalEpisodes
-					numbersFilled = true
-				}
-				if metadata.TotalSeasons > 0 {
-					totalSeasons = metadata.TotalSeasons
-					numbersFilled = true
-				}
+	for _, rating := range sdProgram.ContentRating {
+		hasSystem := false
+		for idx := range programme.Ratings {
+			if programme.Ratings[idx].System == rating.Body {
+				programme.Ratings[idx].Value = rating.Code
+				hasSystem = true
 			}
 		}
-
-		if numbersFilled {
-			seasonNumberStr := fmt.Sprintf("%d", seasonNumber)
-			if totalSeasons > 0 {
-				seasonNumberStr = fmt.Sprintf("%d/%d", seasonNumber, totalSeasons)
-			}
-			episodeNumberStr := fmt.Sprintf("%d", episodeNumber)
-			if totalEpisodes > 0 {
-				episodeNumberStr = fmt.Sprintf("%d/%d", episodeNumber, totalEpisodes)
-			}
-
-			partNumber := 0
-			totalParts := 0
-
-			if ddProgID != "" {
-				var extractErr error
-				_, _, _, partNumber, totalParts, extractErr = extractDDProgID(ddProgID)
-				if extractErr != nil {
-					panic(extractErr)
-				}
-			}
-
-			partStr := "0"
-			if partNumber > 0 {
-				partStr = fmt.Sprintf("%d", partNumber)
-				if totalParts > 0 {
-					partStr = fmt.Sprintf("%d/%d", partNumber, totalParts)
-				}
-			}
-
-			xmlTVNS := fmt.Sprintf("%s.%s.%s", seasonNumberStr, episodeNumberStr, partStr)
-			programme.EpisodeNums = append(programme.EpisodeNums, xmltv.EpisodeNum{System: "xmltv_ns", Value: xmlTVNS})
+		if !hasSystem {
+			programme.Ratings = append(programme.Ratings, xmltv.Rating{Value: rating.Code, System: rating.Body})
 		}
 	}
 
-	return programme
-}
-
-func extractXMLTVNS(str string) (int, int, int, int, error) {
-	matches := xmlNSRegex.FindAllStringSubmatch(str, -1)
-
-	if len(matches) == 0 {
-		return 0, 0, 0, 0, fmt.Errorf("invalid xmltv_ns: %s", str)
-	}
-
-	season, seasonErr := strconv.Atoi(matches[0][1])
-	if seasonErr != nil {
-		return 0, 0, 0, 0, seasonErr
-	}
-
-	episode, episodeErr := strconv.Atoi(matches[0][2])
-	if episodeErr != nil {
-		return 0, 0, 0, 0, episodeErr
-	}
-
-	currentPartNum := 0
-	totalPartsNum := 0
-
-	if len(matches[0]) > 2 && matches[0][3] != "" {
-		currentPart, currentPartErr := strconv.Atoi(matches[0][3])
-		if currentPartErr != nil {
-			return 0, 0, 0, 0, currentPartErr
-		}
-		currentPartNum = currentPart
-	}
+	programme.Icons = append(programme.Icons, options.Artwork.icons(artworks)...)
 
-	if len(matches[0]) > 3 && matches[0][4] != "" {
-		totalParts, totalPartsErr := strconv.Atoi(matches[0][4])
-		if totalPartsErr != nil {
-			return 0, 0, 0, 0, totalPartsErr
+	var sdEpisode xmltv.Episode
+	for _, meta := range sdProgram.Metadata {
+		for _, metadata := range meta {
+			sdEpisode = sdEpisode.Merge(xmltv.Episode{
+				Season:        metadata.Season,
+				TotalSeasons:  metadata.TotalSeasons,
+				Episode:       metadata.Episode,
+				TotalEpisodes: metadata.TotalEpisodes,
+			})
 		}
-		totalPartsNum = totalParts
-	}
-
-	// if season > 0 {
-	// 	season = season - 1
-	// }
-
-	// if episode > 0 {
-	// 	episode = episode - 1
-	// }
-
-	// if currentPartNum > 0 {
-	// 	currentPartNum = currentPartNum - 1
-	// }
-
-	// if totalPartsNum > 0 {
-	// 	totalPartsNum = totalPartsNum - 1
-	// }
-
-	return season, episode, currentPartNum, totalPartsNum, nil
-}
-
-// extractDDProgID returns type, ID, episode ID, part number, total parts, error.
-func extractDDProgID(progID string) (string, int, int, int, int, error) {
-	matches := ddProgIDRegex.FindAllStringSubmatch(progID, -1)
-
-	if len(matches) == 0 {
-		return "", 0, 0, 0, 0, fmt.Errorf("invalid dd_progid: %s", progID)
-	}
-
-	itemType := matches[0][1]
-
-	itemID, itemIDErr := strconv.Atoi(matches[0][2])
-	if itemIDErr != nil {
-		return itemType, 0, 0, 0, 0, itemIDErr
-	}
-
-	specificID, specificIDErr := strconv.Atoi(matches[0][3])
-	if specificIDErr != nil {
-		return itemType, itemID, 0, 0, 0, specificIDErr
 	}
 
-	currentPartNum := 0
-	totalPartsNum := 0
-
-	if len(matches[0]) > 2 && matches[0][4] != "" {
-		currentPart, currentPartErr := strconv.Atoi(matches[0][4])
-		if currentPartErr != nil {
-			return itemType, itemID, specificID, 0, 0, currentPartErr
-		}
-		currentPartNum = currentPart
+	if sdProgram.OriginalAirDate != nil && sdProgram.OriginalAirDate.Time != nil {
+		sdEpisode.AirDate = *sdProgram.OriginalAirDate.Time
 	}
 
-	if len(matches[0]) > 3 && matches[0][5] != "" {
-		totalParts, totalPartsErr := strconv.Atoi(matches[0][5])
-		if totalPartsErr != nil {
-			return itemType, itemID, specificID, currentPartNum, 0, totalPartsErr
-		}
-		totalPartsNum = totalParts
-	}
+	programme.SetEpisode(programme.Episode().Merge(sdEpisode))
 
-	return itemType, itemID, specificID, currentPartNum, totalPartsNum, nil
+	return programme
 }
 
 func UniqueStrings(input []string) []string {
@@ -858,27 +1048,6 @@ func getImageURL(imageURI string) string {
 	return fmt.Sprint(schedulesdirect.DefaultBaseURL, schedulesdirect.APIVersion, "/image/", imageURI)
 }
 
-func padNumberWithZero(value int, expectedLength int) string {
-	padded := fmt.Sprintf("%02d", value)
-	valLength := countDigits(value)
-	if valLength != expectedLength {
-		return fmt.Sprintf("%s%d", strings.Repeat("0", expectedLength-valLength), value)
-	}
-	return padded
-}
-
-func countDigits(i int) int {
-	count := 0
-	if i == 0 {
-		count = 1
-	}
-	for i != 0 {
-		i /= 10
-		count = count + 1
-	}
-	return count
-}
-
 func contains(s []string, e string) bool {
 	for _, ss := range s {
 		if e == ss {
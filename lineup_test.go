@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	m3u "github.com/tellytv/telly/internal/m3uplus"
+	"github.com/tellytv/telly/internal/providers"
+)
+
+func testProvider(t *testing.T, cfg providers.Configuration) providers.Provider {
+	t.Helper()
+	cfg.Provider = "custom"
+	provider, providerErr := cfg.GetProvider()
+	if providerErr != nil {
+		t.Fatal(providerErr)
+	}
+	return provider
+}
+
+func testChannel(name, tvgID, number string) *providers.ProviderChannel {
+	uri, _ := url.Parse("http://example.com/" + url.PathEscape(name) + ".ts")
+	channel := &providers.ProviderChannel{
+		Name:      name,
+		StreamURL: uri.String(),
+		Track:     m3u.Track{Name: name, URI: uri, Tags: map[string]string{}},
+	}
+	if tvgID != "" {
+		channel.Track.Tags["tvg-id"] = tvgID
+	}
+	if number != "" {
+		channel.Number, _ = providers.ParseChannelNumber(number)
+	}
+	return channel
+}
+
+func testLineup(sources ...providers.Provider) *lineup {
+	return &lineup{
+		Sources:               sources,
+		startingChannelNumber: 100,
+		xmlTVChannelNumbers:   true,
+		collisionPolicy:       collisionNext,
+		channels:              make(map[providers.ChannelNumber]hdHomeRunLineupItem),
+		EPGMatchReport:        make(map[string][]epgMatchReportEntry),
+		TransformReport:       make(map[string][]transformReportEntry),
+		ConsolidationReport:   make(map[string][]channelStream),
+		epgPlaceholderLength:  time.Hour,
+		tuners:                newTunerPool(2, time.Minute),
+	}
+}
+
+func TestRescanKeepsLineupWhenSourcesFail(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "telly")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "playlist.m3u")
+	writePlaylist := func(channels int) {
+		m3u := &strings.Builder{}
+		m3u.WriteString("#EXTM3U\n")
+		for idx := 0; idx < channels; idx++ {
+			fmt.Fprintf(m3u, "#EXTINF:-1 tvg-id=\"ch%d\",Channel %d\nhttp://example.com/%d.ts\n", idx, idx, idx)
+		}
+		if writeErr := ioutil.WriteFile(path, []byte(m3u.String()), 0644); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+
+	source := testProvider(t, providers.Configuration{Name: "a", M3U: path})
+	initial := testLineup(source)
+	initial.FfmpegEnabled = true
+
+	// At start there is nothing to keep, so the lineup is served without the failed source.
+	if scanErr := initial.Scan(); scanErr == nil {
+		t.Errorf("expected a missing playlist to fail the scan")
+	} else if _, sourcesFailed := scanErr.(sourceScanError); !sourcesFailed || initial.LastScan.IsZero() {
+		t.Errorf("expected the lineup to be scanned without the source, got %v", scanErr)
+	}
+
+	writePlaylist(2)
+	if scanErr := initial.Scan(); scanErr != nil {
+		t.Fatal(scanErr)
+	}
+	live := newLiveLineup(initial)
+
+	tests := []struct {
+		name     string
+		channels int
+		fails    bool
+	}{
+		{"source has no channels anymore", 0, true},
+		{"source fails", -1, true},
+		{"source still fails", -1, true},
+		{"source is back", 1, false},
+		{"source loses its only channel", 0, true},
+	}
+
+	for _, test := range tests {
+		if test.channels < 0 {
+			os.Remove(path)
+		} else {
+			writePlaylist(test.channels)
+		}
+
+		previous := live.load()
+		rescanErr := live.rescan()
+		if failed := rescanErr != nil; failed != test.fails {
+			t.Errorf("%s: expected failing to be %t, got %v", test.name, test.fails, rescanErr)
+		}
+		if test.fails && live.load() != previous {
+			t.Errorf("%s: expected the previous lineup to be kept", test.name)
+		}
+		if !test.fails && len(live.load().channels) != test.channels {
+			t.Errorf("%s: expected %d channels, got %d", test.name, test.channels, len(live.load().channels))
+		}
+	}
+}
@@ -8,12 +8,14 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/version"
 	"github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/tellytv/telly/internal/hdhomerun"
 )
 
 var (
@@ -64,6 +66,8 @@ func main() {
 	flag.String("discovery.device-firmware-name", "hdhomeruntc_atsc", "Firmware name exposed via discovery. $(TELLY_DISCOVERY_DEVICE_FIRMWARE_NAME)")
 	flag.String("discovery.device-firmware-version", "20150826", "Firmware version exposed via discovery. $(TELLY_DISCOVERY_DEVICE_FIRMWARE_VERSION)")
 	flag.Bool("discovery.ssdp", true, "Turn on SSDP announcement of telly to the local network $(TELLY_DISCOVERY_SSDP)")
+	flag.Bool("discovery.hdhomerun", true, "Answer HDHomeRun discovery requests, which HDHomeRun apps and DVRs use to find tuners $(TELLY_DISCOVERY_HDHOMERUN)")
+	flag.String("discovery.hdhomerun-address", fmt.Sprintf(":%d", hdhomerun.DiscoverPort), "UDP address to answer HDHomeRun discovery requests on $(TELLY_DISCOVERY_HDHOMERUN_ADDRESS)")
 
 	// Regex/filtering flags
 	flag.Bool("filter.regex-inclusive", false, "Whether the provided regex is inclusive (whitelisting) or exclusive (blacklisting). If true (--filter.regex-inclusive), only channels matching the provided regex pattern will be exposed. If false (--no-filter.regex-inclusive), only channels NOT matching the provided pattern will be exposed. $(TELLY_FILTER_REGEX_INCLUSIVE)")
@@ -72,6 +76,7 @@ func main() {
 	// Web flags
 	flag.StringP("web.listen-address", "l", "localhost:6077", "Address to listen on for web interface and telemetry $(TELLY_WEB_LISTEN_ADDRESS)")
 	flag.StringP("web.base-address", "b", "localhost:6077", "The address to expose via discovery. Useful with reverse proxy $(TELLY_WEB_BASE_ADDRESS)")
+	flag.Duration("web.drain-period", 10*time.Second, "How long running streams may continue once telly is asked to stop $(TELLY_WEB_DRAIN_PERIOD)")
 
 	// Log flags
 	flag.String("log.level", logrus.InfoLevel.String(), "Only log messages with the given severity or above. Valid levels: [debug, info, warn, error, fatal] $(TELLY_LOG_LEVEL)")
@@ -86,6 +91,7 @@ func main() {
 	// Misc flags
 	flag.StringP("config.file", "c", "", "Path to your config file. If not set, configuration is searched for in the current working directory, $HOME/.telly/ and /etc/telly/. If provided, it will override all other arguments and environment variables. $(TELLY_CONFIG_FILE)")
 	flag.Bool("version", false, "Show application version")
+	flag.Bool("filter.dry-run", false, "Show which filter rule admits or rejects each track of every source, then exit $(TELLY_FILTER_DRY_RUN)")
 
 	flag.CommandLine.AddGoFlagSet(fflag.CommandLine)
 
@@ -153,7 +159,7 @@ func main() {
 
 	validateConfig()
 
-	viper.Set("discovery.device-uuid", fmt.Sprintf("%s-AE2A-4E54-BBC9-33AF7D5D6A92", viper.GetString("discovery.device-id")))
+	viper.Set("discovery.device-uuid", deviceUUID(viper.GetString("discovery.device-id")))
 
 	if log.Level == logrus.DebugLevel {
 		js, jsErr := json.MarshalIndent(viper.AllSettings(), "", "    ")
@@ -165,11 +171,21 @@ func main() {
 
 	lineup := newLineup()
 
+	if viper.GetBool("filter.dry-run") {
+		if dryRunErr := lineup.dryRunFilters(os.Stdout); dryRunErr != nil {
+			log.WithError(dryRunErr).Fatalln("error running filters")
+		}
+		os.Exit(0)
+	}
+
 	if scanErr := lineup.Scan(); scanErr != nil {
-		log.WithError(scanErr).Panicln("Error scanning lineup!")
+		if _, sourcesFailed := scanErr.(sourceScanError); !sourcesFailed {
+			log.WithError(scanErr).Panicln("Error scanning lineup!")
+		}
+		log.WithError(scanErr).Errorln("Serving the lineup without the channels of the failed sources")
 	}
 
-	serve(lineup)
+	serve(newLiveLineup(lineup))
 }
 
 func validateConfig() {
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/tellytv/telly/internal/providers"
+)
+
+// collisionPolicy decides what happens when a channel wants a number that is already taken.
+type collisionPolicy string
+
+const (
+	// collisionNext gives the later channel the next free number.
+	collisionNext collisionPolicy = "next"
+	// collisionSkip leaves the later channel out of the lineup.
+	collisionSkip collisionPolicy = "skip"
+)
+
+// pendingChannel is a channel that passed the filters and is waiting for its number.
+type pendingChannel struct {
+	provider providers.Provider
+	channel  *providers.ProviderChannel
+	// streams are the ranked streams of a channel carried by several sources, nil otherwise.
+	streams []channelStream
+}
+
+// channelNumberer hands out channel numbers for a single scan.
+type channelNumberer struct {
+	policy   collisionPolicy
+	taken    map[providers.ChannelNumber]string
+	counters map[string]int
+	next     int
+	state    *channelNumberState
+
+	// Numbers in this range are only automatically given to favorites, it is disabled if favoriteStart is zero.
+	favoriteStart int
+	favoriteEnd   int
+}
+
+// mappedChannelNumber returns the number the ChannelMap of the provider gives the channel, by tvg-id or name.
+func mappedChannelNumber(config providers.Configuration, channel *providers.ProviderChannel) (providers.ChannelNumber, bool) {
+	if len(config.ChannelMap) == 0 {
+		return providers.ChannelNumber{}, false
+	}
+
+	// Config keys are lowercased by viper, so compare case insensitively.
+	entries := make(map[string]string)
+	for key, value := range config.ChannelMap {
+		entries[strings.ToLower(key)] = value
+	}
+
+	for _, key := range []string{channel.Track.Tags["tvg-id"], channel.Name} {
+		value, ok := entries[strings.ToLower(key)]
+		if !ok || key == "" {
+			continue
+		}
+
+		number, parseErr := providers.ParseChannelNumber(value)
+		if parseErr != nil {
+			log.WithError(parseErr).Errorf("ignoring the channel map entry for %s", key)
+			return providers.ChannelNumber{}, false
+		}
+
+		return number, true
+	}
+
+	return providers.ChannelNumber{}, false
+}
+
+// assignChannelNumbers numbers every pending channel and returns the resulting lineup.
+// Numbers from the channel maps are handed out first, then numbers from the favorite range, numbers from the playlists
+// and finally automatic numbers, each in source and playlist order, so that collisions are always resolved the same way.
+func (l *lineup) assignChannelNumbers(pending []pendingChannel) map[providers.ChannelNumber]hdHomeRunLineupItem {
+	numberer := &channelNumberer{
+		policy:   l.collisionPolicy,
+		taken:    make(map[providers.ChannelNumber]string),
+		counters: make(map[string]int),
+		next:     l.startingChannelNumber,
+		state:    l.channelState,
+
+		favoriteStart: l.favoriteStart,
+		favoriteEnd:   l.favoriteEnd,
+	}
+
+	identities := channelIdentities(pending)
+
+	// done marks channels that have been given a number or were left out because of a collision.
+	done := make([]bool, len(pending))
+	dropped := make([]bool, len(pending))
+
+	for idx, entry := range pending {
+		if number, ok := mappedChannelNumber(entry.provider.Configuration(), entry.channel); ok {
+			done[idx] = true
+			dropped[idx] = !numberer.claim(entry, identities[idx], number)
+		}
+	}
+
+	// Favorites are numbered from their reserved range before anything else can take those numbers.
+	for idx, entry := range pending {
+		if done[idx] || !entry.channel.Favorite || numberer.favoriteStart == 0 {
+			continue
+		}
+		number, ok := numberer.favorite(identities[idx])
+		if !ok {
+			log.Warnf("Favorite channel %s from %s has no number left in the favorite range, numbering it like any other channel", entry.channel.Name, entry.provider.Name())
+			continue
+		}
+		entry.channel.Number = number
+		numberer.taken[number] = entry.channel.Name
+		done[idx] = true
+	}
+
+	for idx, entry := range pending {
+		if done[idx] || !l.xmlTVChannelNumbers || entry.channel.Number.IsZero() {
+			continue
+		}
+		done[idx] = true
+		dropped[idx] = !numberer.claim(entry, identities[idx], entry.channel.Number.Add(entry.provider.Configuration().ChannelOffset))
+	}
+
+	// Channels that were numbered automatically before keep their number, if it's still free.
+	for idx, entry := range pending {
+		if done[idx] {
+			continue
+		}
+		if number, ok := numberer.state.get(identities[idx]); ok && !numberer.inFavoriteRange(number) {
+			if _, taken := numberer.taken[number]; !taken {
+				entry.channel.Number = number
+				numberer.taken[number] = entry.channel.Name
+				done[idx] = true
+			}
+		}
+	}
+
+	for idx, entry := range pending {
+		if done[idx] {
+			continue
+		}
+		number, ok := numberer.automatic(entry, identities[idx])
+		if !ok {
+			log.Errorf("Channel %s from %s has no number left in the configured channel range and was left out of the lineup", entry.channel.Name, entry.provider.Name())
+			dropped[idx] = true
+			continue
+		}
+		entry.channel.Number = number
+		numberer.taken[number] = entry.channel.Name
+	}
+
+	channels := make(map[providers.ChannelNumber]hdHomeRunLineupItem)
+	for idx, entry := range pending {
+		if dropped[idx] {
+			continue
+		}
+		l.finishChannel(entry.channel)
+		item := newHDHRItem(&pending[idx].provider, entry.channel)
+		item.streams = entry.streams
+		channels[entry.channel.Number] = item
+		numberer.state.put(identities[idx], entry.channel.Number)
+	}
+
+	if saveErr := numberer.state.save(); saveErr != nil {
+		log.WithError(saveErr).Errorln("unable to save channel number state, numbers may change on restart")
+	}
+
+	return channels
+}
+
+// claim gives the channel the wanted number, resolving a collision according to the policy.
+// It returns false if the channel has to be left out of the lineup.
+func (n *channelNumberer) claim(entry pendingChannel, identity string, wanted providers.ChannelNumber) bool {
+	holder, taken := n.taken[wanted]
+	if !taken {
+		entry.channel.Number = wanted
+		n.taken[wanted] = entry.channel.Name
+		return true
+	}
+
+	if n.policy == collisionSkip {
+		log.Warnf("Channel %s from %s wants number %s which is already used by %s, leaving it out of the lineup", entry.channel.Name, entry.provider.Name(), wanted, holder)
+		return false
+	}
+
+	// Like automatic numbers, the next number stays out of the favorite range and away from remembered channels.
+	number := wanted.Next()
+	for !n.free(number, identity) || (number.Minor == 0 && n.inFavoriteRange(number)) {
+		number = number.Next()
+	}
+
+	log.Warnf("Channel %s from %s wants number %s which is already used by %s, using %s instead", entry.channel.Name, entry.provider.Name(), wanted, holder, number)
+
+	entry.channel.Number = number
+	n.taken[number] = entry.channel.Name
+	return true
+}
+
+// free reports whether number can be given to the channel with the given identity.
+// Numbers remembered for other channels are kept free for when those channels come back.
+func (n *channelNumberer) free(number providers.ChannelNumber, identity string) bool {
+	_, taken := n.taken[number]
+	return !taken && !n.state.isReserved(number, identity)
+}
+
+// inFavoriteRange reports whether number is reserved for favorites.
+func (n *channelNumberer) inFavoriteRange(number providers.ChannelNumber) bool {
+	return n.favoriteStart > 0 && number.Major >= n.favoriteStart && number.Major <= n.favoriteEnd
+}
+
+// favorite returns the number a favorite had before if it's still in the favorite range, otherwise the first free one.
+func (n *channelNumberer) favorite(identity string) (providers.ChannelNumber, bool) {
+	if number, ok := n.state.get(identity); ok && n.inFavoriteRange(number) {
+		if _, taken := n.taken[number]; !taken {
+			return number, true
+		}
+	}
+
+	for major := n.favoriteStart; major <= n.favoriteEnd; major++ {
+		number := providers.ChannelNumber{Major: major}
+		if n.free(number, identity) {
+			return number, true
+		}
+	}
+
+	return providers.ChannelNumber{}, false
+}
+
+// automatic returns the next free number, from the channel range of the provider if it has one.
+func (n *channelNumberer) automatic(entry pendingChannel, identity string) (providers.ChannelNumber, bool) {
+	config := entry.provider.Configuration()
+
+	if config.ChannelStart > 0 {
+		counter, ok := n.counters[entry.provider.Name()]
+		if !ok {
+			counter = config.ChannelStart
+		}
+		for ; config.ChannelEnd == 0 || counter <= config.ChannelEnd; counter++ {
+			number := providers.ChannelNumber{Major: counter}
+			if n.free(number, identity) && !n.inFavoriteRange(number) {
+				n.counters[entry.provider.Name()] = counter + 1
+				return number, true
+			}
+		}
+		n.counters[entry.provider.Name()] = counter
+		return providers.ChannelNumber{}, false
+	}
+
+	for {
+		number := providers.ChannelNumber{Major: n.next}
+		n.next++
+		if n.free(number, identity) && !n.inFavoriteRange(number) {
+			return number, true
+		}
+	}
+}
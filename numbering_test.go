@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tellytv/telly/internal/providers"
+)
+
+// testPending describes a channel waiting for its number, from the provider with the index.
+type testPending struct {
+	provider int
+	name     string
+	tvgID    string
+	number   string
+	favorite bool
+}
+
+func TestAssignChannelNumbers(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(l *lineup)
+		providers []providers.Configuration
+		channels  []testPending
+		// expected maps channel names to their number, or to "" if they were left out.
+		expected map[string]string
+	}{
+		{
+			name:      "playlist numbers, then automatic numbers",
+			providers: []providers.Configuration{{Name: "a"}},
+			channels:  []testPending{{name: "A", number: "5"}, {name: "B"}, {name: "C", number: "5"}, {name: "D"}},
+			expected:  map[string]string{"A": "5", "B": "100", "C": "6", "D": "101"},
+		},
+		{
+			name:      "skipped collisions",
+			configure: func(l *lineup) { l.collisionPolicy = collisionSkip },
+			providers: []providers.Configuration{{Name: "a"}},
+			channels:  []testPending{{name: "A", number: "5"}, {name: "B"}, {name: "C", number: "5"}},
+			expected:  map[string]string{"A": "5", "B": "100", "C": ""},
+		},
+		{
+			name:      "next sub-channel on collision",
+			providers: []providers.Configuration{{Name: "a"}},
+			channels:  []testPending{{name: "A", number: "5.1"}, {name: "B", number: "5.2"}, {name: "C", number: "5.1"}},
+			expected:  map[string]string{"A": "5.1", "B": "5.2", "C": "5.3"},
+		},
+		{
+			name:      "channel map before playlist numbers",
+			providers: []providers.Configuration{{Name: "a", ChannelMap: map[string]string{"bbc1.uk": "5", "channel c": "7.1", "broken": "x"}}},
+			channels:  []testPending{{name: "A", number: "5"}, {name: "B", tvgID: "bbc1.uk"}, {name: "Channel C", number: "9"}, {name: "Broken"}},
+			expected:  map[string]string{"A": "6", "B": "5", "Channel C": "7.1", "Broken": "100"},
+		},
+		{
+			name:      "favorite range before playlist numbers",
+			configure: func(l *lineup) { l.favoriteStart, l.favoriteEnd = 1, 2 },
+			providers: []providers.Configuration{{Name: "a"}},
+			channels: []testPending{
+				{name: "X", number: "1"},
+				{name: "F1", number: "50", favorite: true},
+				{name: "F2", favorite: true},
+				{name: "F3", favorite: true},
+				{name: "Y"},
+			},
+			expected: map[string]string{"X": "3", "F1": "1", "F2": "2", "F3": "100", "Y": "101"},
+		},
+		{
+			name:      "channel map before the favorite range",
+			configure: func(l *lineup) { l.favoriteStart, l.favoriteEnd = 1, 2 },
+			providers: []providers.Configuration{{Name: "a", ChannelMap: map[string]string{"mapped": "1"}}},
+			channels:  []testPending{{name: "F", favorite: true}, {name: "Mapped"}},
+			expected:  map[string]string{"F": "2", "Mapped": "1"},
+		},
+		{
+			name:      "offset applies to playlist numbers only",
+			providers: []providers.Configuration{{Name: "a", ChannelOffset: 1000}},
+			channels:  []testPending{{name: "A", number: "5"}, {name: "B", number: "5.1"}, {name: "C"}},
+			expected:  map[string]string{"A": "1005", "B": "1005.1", "C": "100"},
+		},
+		{
+			name:      "playlist numbers ignored",
+			configure: func(l *lineup) { l.xmlTVChannelNumbers = false },
+			providers: []providers.Configuration{{Name: "a"}},
+			channels:  []testPending{{name: "A", number: "5"}, {name: "B"}},
+			expected:  map[string]string{"A": "100", "B": "101"},
+		},
+		{
+			name:      "channel range per source",
+			providers: []providers.Configuration{{Name: "a"}, {Name: "b", ChannelStart: 2000, ChannelEnd: 2001}},
+			channels:  []testPending{{provider: 1, name: "B1"}, {name: "A1"}, {provider: 1, name: "B2", number: "2000"}, {provider: 1, name: "B3"}, {provider: 1, name: "B4"}},
+			expected:  map[string]string{"A1": "100", "B1": "2001", "B2": "2000", "B3": "", "B4": ""},
+		},
+		{
+			name:      "automatic numbers skip the favorite range",
+			configure: func(l *lineup) { l.startingChannelNumber, l.favoriteStart, l.favoriteEnd = 1, 1, 2 },
+			providers: []providers.Configuration{{Name: "a"}},
+			channels:  []testPending{{name: "A"}, {name: "B", number: "2"}},
+			expected:  map[string]string{"A": "3", "B": "2"},
+		},
+		{
+			name:      "next number on collision skips the favorite range",
+			configure: func(l *lineup) { l.favoriteStart, l.favoriteEnd = 6, 7 },
+			providers: []providers.Configuration{{Name: "a"}},
+			channels:  []testPending{{name: "A", number: "5"}, {name: "B", number: "5"}, {name: "C", number: "5.1"}, {name: "D", number: "5.1"}},
+			expected:  map[string]string{"A": "5", "B": "8", "C": "5.1", "D": "5.2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sources := make([]providers.Provider, 0, len(test.providers))
+			for _, cfg := range test.providers {
+				sources = append(sources, testProvider(t, cfg))
+			}
+
+			l := testLineup(sources...)
+			if test.configure != nil {
+				test.configure(l)
+			}
+
+			channels := l.assignChannelNumbers(testPendingChannels(sources, test.channels))
+
+			if actual := channelNumbersByName(channels); fmt.Sprint(actual) != fmt.Sprint(withoutLeftOut(test.expected)) {
+				t.Errorf("expected %v, got %v", withoutLeftOut(test.expected), actual)
+			}
+		})
+	}
+}
+
+func TestScanChannelLimit(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "telly")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+
+	playlist := func(channels, favorites int) string {
+		m3u := &strings.Builder{}
+		m3u.WriteString("#EXTM3U\n")
+		for idx := 0; idx < channels+favorites; idx++ {
+			group := "Channels"
+			if idx >= channels {
+				group = "Favorites"
+			}
+			fmt.Fprintf(m3u, "#EXTINF:-1 tvg-id=\"ch%d\" group-title=\"%s\",Channel %d\nhttp://example.com/%d.ts\n", idx, group, idx, idx)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%d-%d.m3u", channels, favorites))
+		if writeErr := ioutil.WriteFile(path, []byte(m3u.String()), 0644); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		return path
+	}
+
+	tests := []struct {
+		channels  int
+		favorites int
+		tooMany   bool
+	}{
+		{420, 0, false},
+		{420, 30, false},
+		{421, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d channels and %d favorites", test.channels, test.favorites), func(t *testing.T) {
+			source := testProvider(t, providers.Configuration{
+				Name:        "a",
+				M3U:         playlist(test.channels, test.favorites),
+				FavoriteTag: "group-title",
+				Favorites:   []string{"Favorites"},
+			})
+			l := testLineup(source)
+			l.FfmpegEnabled = true
+
+			scanErr := l.Scan()
+			if tooMany := scanErr != nil; tooMany != test.tooMany {
+				t.Errorf("expected refusing too many channels to be %t, got %v", test.tooMany, scanErr)
+			}
+			if !test.tooMany && len(l.channels) != test.channels+test.favorites {
+				t.Errorf("expected %d channels, got %d", test.channels+test.favorites, len(l.channels))
+			}
+		})
+	}
+}
+
+func testPendingChannels(sources []providers.Provider, channels []testPending) []pendingChannel {
+	pending := make([]pendingChannel, 0, len(channels))
+	for _, entry := range channels {
+		channel := testChannel(entry.name, entry.tvgID, entry.number)
+		channel.Favorite = entry.favorite
+		pending = append(pending, pendingChannel{provider: sources[entry.provider], channel: channel})
+	}
+	return pending
+}
+
+func channelNumbersByName(channels map[providers.ChannelNumber]hdHomeRunLineupItem) map[string]string {
+	numbers := make(map[string]string, len(channels))
+	for number, channel := range channels {
+		numbers[channel.providerChannel.Name] = number.String()
+	}
+	return numbers
+}
+
+func withoutLeftOut(expected map[string]string) map[string]string {
+	numbers := make(map[string]string, len(expected))
+	for name, number := range expected {
+		if number != "" {
+			numbers[name] = number
+		}
+	}
+	return numbers
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tellytv/telly/internal/providers"
+)
+
+// ffprobeCodecs maps ffprobe codec names to the names HDHomeRun clients expect.
+var ffprobeCodecs = map[string]string{
+	"h264":       "H264",
+	"hevc":       "HEVC",
+	"mpeg2video": "MPEG2",
+	"ac3":        "AC3",
+	"eac3":       "EAC3",
+	"aac":        "AAC",
+	"mp2":        "MPEG",
+	"mp3":        "MPEG",
+}
+
+// streamProber measures the quality of streams with ffprobe, remembering the results between scans.
+// A nil prober is valid and never probes anything.
+type streamProber struct {
+	timeout     time.Duration
+	concurrency int
+
+	mutex   sync.Mutex
+	results map[string]providers.StreamQuality
+	// failed holds the streams that couldn't be probed in the last scan.
+	failed map[string]bool
+}
+
+// newStreamProber returns a prober if probing is turned on.
+func newStreamProber() *streamProber {
+	if !viper.GetBool("iptv.probe") {
+		return nil
+	}
+
+	if _, lookErr := exec.LookPath("ffprobe"); lookErr != nil {
+		log.WithError(lookErr).Warnln("iptv.probe is set but ffprobe could not be found, stream quality will only be guessed from channel names")
+		return nil
+	}
+
+	prober := &streamProber{
+		timeout:     15 * time.Second,
+		concurrency: viper.GetInt("iptv.streams"),
+		results:     make(map[string]providers.StreamQuality),
+		failed:      make(map[string]bool),
+	}
+	if viper.IsSet("iptv.probe-timeout") {
+		prober.timeout = viper.GetDuration("iptv.probe-timeout")
+	}
+	// Providers limit concurrent connections, so never probe more streams at once than there are tuners.
+	if prober.concurrency < 1 {
+		prober.concurrency = 1
+	}
+
+	return prober
+}
+
+// probe fills in the quality of the pending channels from their streams. Streams that can't be probed keep the
+// quality guessed from their names.
+func (p *streamProber) probe(pending []pendingChannel) {
+	if p == nil {
+		return
+	}
+
+	start := time.Now()
+	slots := make(chan struct{}, p.concurrency)
+	var wg sync.WaitGroup
+
+	for _, entry := range pending {
+		channel := entry.channel
+
+		p.mutex.Lock()
+		quality, known := p.results[channel.StreamURL]
+		p.mutex.Unlock()
+
+		if known {
+			channel.SetQuality(quality.Merge(providers.DetectQuality(channel.Track)))
+			continue
+		}
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+			defer cancel()
+
+			quality, probeErr := probeStream(ctx, channel.StreamURL)
+			if probeErr != nil {
+				log.WithError(probeErr).Debugf("unable to probe the stream of %s", channel.Name)
+				p.mutex.Lock()
+				p.failed[channel.StreamURL] = true
+				p.mutex.Unlock()
+				return
+			}
+
+			p.mutex.Lock()
+			p.results[channel.StreamURL] = quality
+			delete(p.failed, channel.StreamURL)
+			p.mutex.Unlock()
+
+			channel.SetQuality(quality.Merge(providers.DetectQuality(channel.Track)))
+		}()
+	}
+
+	wg.Wait()
+
+	log.Infof("Probed the streams of %d channels in %s", len(pending), time.Since(start).Round(time.Second))
+}
+
+// healthy reports whether the stream could be probed the last time it was tried, streams never probed are healthy.
+func (p *streamProber) healthy(streamURL string) bool {
+	if p == nil {
+		return true
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return !p.failed[streamURL]
+}
+
+// probeStream asks ffprobe for the resolution and codecs of a stream.
+func probeStream(ctx context.Context, streamURL string) (providers.StreamQuality, error) {
+	quality := providers.StreamQuality{}
+
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_entries", "stream=codec_type,codec_name,height", streamURL)
+	output, runErr := cmd.Output()
+	if runErr != nil {
+		if ctx.Err() != nil {
+			return quality, ctx.Err()
+		}
+		return quality, runErr
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+	}
+	if unmarshalErr := json.Unmarshal(output, &result); unmarshalErr != nil {
+		return quality, fmt.Errorf("unable to parse ffprobe output: %s", unmarshalErr)
+	}
+
+	for _, stream := range result.Streams {
+		codec, ok := ffprobeCodecs[stream.CodecName]
+		if !ok {
+			codec = strings.ToUpper(stream.CodecName)
+		}
+
+		switch stream.CodecType {
+		case "video":
+			if stream.Height > quality.Height {
+				quality.Height = stream.Height
+				quality.Resolution = providers.QualityFromHeight(stream.Height)
+				quality.VideoCodec = codec
+			}
+		case "audio":
+			if quality.AudioCodec == "" {
+				quality.AudioCodec = codec
+			}
+		}
+	}
+
+	if quality.Height == 0 && quality.AudioCodec == "" {
+		return quality, fmt.Errorf("ffprobe found no audio or video in the stream")
+	}
+
+	return quality, nil
+}
@@ -3,12 +3,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os/exec"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,14 +17,14 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/gobuffalo/packr"
-	ssdp "github.com/koron/go-ssdp"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	ginprometheus "github.com/tellytv/telly/internal/go-gin-prometheus"
-	"github.com/tellytv/telly/internal/xmltv"
+	"github.com/tellytv/telly/internal/hdhomerun"
+	"github.com/tellytv/telly/internal/providers"
 )
 
-func serve(lineup *lineup) {
+func serve(live *liveLineup) {
 	discoveryData := getDiscoveryData()
 
 	log.Debugln("creating device xml")
@@ -48,53 +49,67 @@ func serve(lineup *lineup) {
 
 	router.GET("/", deviceXML(upnp))
 	router.GET("/discover.json", discovery(discoveryData))
-	router.GET("/lineup_status.json", func(c *gin.Context) {
-		payload := LineupStatus{
-			ScanInProgress: convertibleBoolean(false),
-			ScanPossible:   convertibleBoolean(true),
-			Source:         "Cable",
-			SourceList:     []string{"Cable"},
-		}
-		if lineup.Scanning {
-			payload = LineupStatus{
-				ScanInProgress: convertibleBoolean(true),
-				// Gotta fake out Plex.
-				Progress: 50,
-				Found:    50,
-			}
-		}
-
-		c.JSON(http.StatusOK, payload)
-	})
-	router.POST("/lineup.post", func(c *gin.Context) {
-		scanAction := c.Query("scan")
-		if scanAction == "start" {
-			if refreshErr := lineup.Scan(); refreshErr != nil {
-				c.AbortWithError(http.StatusInternalServerError, refreshErr)
-			}
-			c.AbortWithStatus(http.StatusOK)
-			return
-		} else if scanAction == "abort" {
-			c.AbortWithStatus(http.StatusOK)
-			return
-		}
-		c.String(http.StatusBadRequest, "%s is not a valid scan command", scanAction)
-	})
+	router.GET("/lineup_status.json", lineupStatus(live))
+	router.POST("/lineup.post", scanLineup(live))
 	router.GET("/device.xml", deviceXML(upnp))
-	router.GET("/lineup.json", serveLineup(lineup))
-	router.GET("/lineup.xml", serveLineup(lineup))
-	router.GET("/auto/:channelID", stream(lineup))
-	router.GET("/epg.xml", xmlTV(lineup))
+	router.GET("/lineup.json", serveLineup(live, false))
+	router.GET("/lineup.xml", serveLineup(live, false))
+	router.GET("/auto/:channelID", stream(live, autoTuner, false))
+	router.GET("/status.json", serveTunerStatus(live))
+	router.GET("/epg.xml", xmlTV(live, false, false))
+	router.GET("/epg.xml.gz", xmlTV(live, true, false))
+	router.GET(sdImageProxyPath+"*image", sdImageProxy(live))
 	router.GET("/debug.json", func(c *gin.Context) {
-		c.JSON(http.StatusOK, lineup)
+		c.JSON(http.StatusOK, live.load())
 	})
 
+	// The favorites are also exposed as a second tuner of their own, so they can be added to Plex as a separate DVR.
+	if viper.GetBool("iptv.favorites-lineup") {
+		favoritesData := getFavoritesDiscoveryData()
+		favoritesUPNP := favoritesData.UPNP()
+
+		favorites := router.Group(favoritesPath)
+		favorites.GET("/", deviceXML(favoritesUPNP))
+		favorites.GET("/device.xml", deviceXML(favoritesUPNP))
+		favorites.GET("/discover.json", discovery(favoritesData))
+		favorites.GET("/lineup_status.json", lineupStatus(live))
+		favorites.POST("/lineup.post", scanLineup(live))
+		favorites.GET("/lineup.json", serveLineup(live, true))
+		favorites.GET("/lineup.xml", serveLineup(live, true))
+		favorites.GET("/auto/:channelID", stream(live, autoTuner, true))
+		favorites.GET("/status.json", serveTunerStatus(live))
+		registerTuners(favorites, live, true)
+		favorites.GET("/epg.xml", xmlTV(live, false, true))
+		favorites.GET("/epg.xml.gz", xmlTV(live, true, true))
+	}
+
+	registerTuners(router, live, false)
+	registerUPNP(router, live)
+
+	var advertiser *ssdpAdvertiser
 	if viper.GetBool("discovery.ssdp") {
-		if _, ssdpErr := setupSSDP(viper.GetString("web.base-address"), viper.GetString("discovery.device-friendly-name"), viper.GetString("discovery.device-uuid")); ssdpErr != nil {
+		advertised := []DiscoveryData{discoveryData}
+		if viper.GetBool("iptv.favorites-lineup") {
+			advertised = append(advertised, getFavoritesDiscoveryData())
+		}
+		var ssdpErr error
+		if advertiser, ssdpErr = setupSSDP(advertised...); ssdpErr != nil {
 			log.WithError(ssdpErr).Errorln("telly cannot advertise over ssdp")
 		}
 	}
 
+	var discoveryConn net.PacketConn
+	if viper.GetBool("discovery.hdhomerun") {
+		discoverable := []DiscoveryData{discoveryData}
+		if viper.GetBool("iptv.favorites-lineup") {
+			discoverable = append(discoverable, getFavoritesDiscoveryData())
+		}
+		var discoveryErr error
+		if discoveryConn, discoveryErr = setupHDHomeRunDiscovery(viper.GetString("discovery.hdhomerun-address"), discoverable...); discoveryErr != nil {
+			log.WithError(discoveryErr).Errorln("telly cannot advertise over the HDHomeRun discovery protocol")
+		}
+	}
+
 	box := packr.NewBox("./frontend/dist/telly-fe")
 
 	router.StaticFS("/manage", box)
@@ -102,11 +117,17 @@ func serve(lineup *lineup) {
 	log.Infof("telly is live and on the air!")
 	log.Infof("Broadcasting from http://%s/", viper.GetString("web.base-address"))
 	log.Infof("EPG URL: http://%s/epg.xml", viper.GetString("web.base-address"))
+	log.Infof("Compressed EPG URL: http://%s/epg.xml.gz", viper.GetString("web.base-address"))
 	log.Infof("Lineup JSON: http://%s/lineup.json", viper.GetString("web.base-address"))
+	if viper.GetBool("iptv.favorites-lineup") {
+		log.Infof("Favorites tuner: http://%s%s/", viper.GetString("web.base-address"), favoritesPath)
+	}
 
-	if err := router.Run(viper.GetString("web.listen-address")); err != nil {
-		log.WithError(err).Panicln("Error starting up web server")
+	server := &http.Server{
+		Addr:    viper.GetString("web.listen-address"),
+		Handler: router,
 	}
+	runServer(server, live, advertiser, discoveryConn)
 }
 
 func deviceXML(deviceXML UPNP) gin.HandlerFunc {
@@ -121,24 +142,85 @@ func discovery(data DiscoveryData) gin.HandlerFunc {
 	}
 }
 
+func lineupStatus(live *liveLineup) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payload := LineupStatus{
+			ScanInProgress: convertibleBoolean(false),
+			ScanPossible:   convertibleBoolean(true),
+			Source:         "Cable",
+			SourceList:     []string{"Cable"},
+		}
+		if live.scanning() {
+			payload = LineupStatus{
+				ScanInProgress: convertibleBoolean(true),
+				// Gotta fake out Plex.
+				Progress: 50,
+				Found:    50,
+			}
+		}
+
+		c.JSON(http.StatusOK, payload)
+	}
+}
+
+func scanLineup(live *liveLineup) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scanAction := c.Query("scan")
+		if scanAction == "start" {
+			// Scanning can take a while, clients follow it through lineup_status.json. A scan that fails, for instance
+			// because a source is down, keeps the current lineup.
+			if !live.scanning() {
+				go func() {
+					if refreshErr := live.rescan(); refreshErr != nil {
+						log.WithError(refreshErr).Errorln("error rescanning, keeping the current lineup")
+					}
+				}()
+			}
+			c.AbortWithStatus(http.StatusOK)
+			return
+		} else if scanAction == "abort" {
+			c.AbortWithStatus(http.StatusOK)
+			return
+		}
+		c.String(http.StatusBadRequest, "%s is not a valid scan command", scanAction)
+	}
+}
+
 type hdhrLineupContainer struct {
 	XMLName  xml.Name `xml:"Lineup"    json:"-"`
 	Programs []hdHomeRunLineupItem
 }
 
-func serveLineup(lineup *lineup) gin.HandlerFunc {
+// serveLineup serves the lineup as JSON or XML, depending on the extension requested.
+// Every channel telly finds is enabled and unprotected, so show=found, demo and unprotected all list the whole lineup.
+// With tuning, channels also say how they are tuned, which for telly is always over IP.
+func serveLineup(live *liveLineup, favoritesOnly bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		lineup := live.load()
+		switch show := c.Query("show"); show {
+		case "", "found", "demo", "unprotected":
+		default:
+			c.String(http.StatusBadRequest, "%s is not a valid lineup to show", show)
+			return
+		}
+		_, tuning := c.GetQuery("tuning")
+
 		channels := make([]hdHomeRunLineupItem, 0)
-		for _, channel := range lineup.channels {
+		for _, channel := range lineup.sortedChannels() {
+			if favoritesOnly && !channel.providerChannel.Favorite {
+				continue
+			}
+			if tuning {
+				channel.Modulation = "ip"
+				channel.ProgramNumber = len(channels) + 1
+			}
 			channels = append(channels, channel)
 		}
-		sort.Slice(channels, func(i, j int) bool {
-			return channels[i].GuideNumber < channels[j].GuideNumber
-		})
-		if strings.HasSuffix(c.Request.URL.String(), ".xml") {
+		if strings.HasSuffix(c.Request.URL.Path, ".xml") {
 			buf, marshallErr := xml.MarshalIndent(hdhrLineupContainer{Programs: channels}, "", "\t")
 			if marshallErr != nil {
 				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("error marshalling lineup to XML"))
+				return
 			}
 			c.Data(http.StatusOK, "application/xml", []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n"+string(buf)))
 			return
@@ -147,102 +229,231 @@ func serveLineup(lineup *lineup) gin.HandlerFunc {
 	}
 }
 
-func xmlTV(lineup *lineup) gin.HandlerFunc {
-	epg := &xmltv.TV{
-		GeneratorInfoName: namespaceWithVersion,
-		GeneratorInfoURL:  "https://github.com/tellytv/telly",
+func xmlTV(live *liveLineup, gzipped, favoritesOnly bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lineup := live.load()
+		now := time.Now()
+		if lineup.epgLocation != nil {
+			now = now.In(lineup.epgLocation)
+		}
+
+		lastModified := lineup.LastScan
+		if lineup.epgMovesWithTime() {
+			// Only move the window every hour so that clients can cache the guide in between.
+			now = now.Truncate(time.Hour)
+			if now.After(lastModified) {
+				lastModified = now
+			}
+		}
+
+		compress := gzipped || acceptsGzip(c.GetHeader("Accept-Encoding"))
+		c.Header("Vary", "Accept-Encoding")
+
+		// Until the lineup has been scanned there is no version of the guide that clients could revalidate.
+		if !lineup.LastScan.IsZero() {
+			etag := fmt.Sprintf(`"%x"`, lastModified.UnixNano())
+			if compress {
+				etag = fmt.Sprintf(`"%x-gzip"`, lastModified.UnixNano())
+			}
+
+			c.Header("ETag", etag)
+			c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+
+			if notModified(c.Request, etag, lastModified) {
+				c.Status(http.StatusNotModified)
+				return
+			}
+		}
+
+		var w io.Writer = c.Writer
+		if compress {
+			if gzipped {
+				c.Header("Content-Type", "application/gzip")
+			} else {
+				c.Header("Content-Type", "application/xml")
+				c.Header("Content-Encoding", "gzip")
+			}
+			gz := gzip.NewWriter(c.Writer)
+			defer gz.Close()
+			w = gz
+		} else {
+			c.Header("Content-Type", "application/xml")
+		}
+
+		c.Status(http.StatusOK)
+
+		if writeErr := writeEPG(w, lineup, now, favoritesOnly); writeErr != nil {
+			log.WithError(writeErr).Errorln("error writing EPG")
+		}
 	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows a gzipped response. Codings given a q-value of 0 are
+// refused, and gzip is accepted through * unless it is listed itself.
+func acceptsGzip(acceptEncoding string) bool {
+	accepted, wildcard := false, false
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(entry, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			if name, value := splitParam(param); name == "q" {
+				if parsed, parseErr := strconv.ParseFloat(value, 64); parseErr == nil {
+					quality = parsed
+				}
+			}
+		}
 
-	for _, channel := range lineup.channels {
-		if channel.providerChannel.EPGChannel != nil {
-			epg.Channels = append(epg.Channels, *channel.providerChannel.EPGChannel)
-			epg.Programmes = append(epg.Programmes, channel.providerChannel.EPGProgrammes...)
+		switch coding {
+		case "gzip", "x-gzip":
+			return quality > 0
+		case "*":
+			wildcard, accepted = true, quality > 0
 		}
 	}
+	return wildcard && accepted
+}
 
-	sort.Slice(epg.Channels, func(i, j int) bool { return epg.Channels[i].LCN < epg.Channels[j].LCN })
+// splitParam splits a header parameter like q=0.5 into its lowercased name and its value.
+func splitParam(param string) (string, string) {
+	parts := strings.SplitN(param, "=", 2)
+	if len(parts) != 2 {
+		return strings.ToLower(strings.TrimSpace(param)), ""
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+}
 
-	return func(c *gin.Context) {
-		buf, marshallErr := xml.MarshalIndent(epg, "", "\t")
-		if marshallErr != nil {
-			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("error marshalling EPG to XML"))
+// notModified reports whether the conditional headers of the request match the current version of a resource.
+func notModified(req *http.Request, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
 		}
-		c.Data(http.StatusOK, "application/xml", []byte(xml.Header+`<!DOCTYPE tv SYSTEM "xmltv.dtd">`+"\n"+string(buf)))
+		return false
+	}
+
+	if ifModifiedSince, parseErr := http.ParseTime(req.Header.Get("If-Modified-Since")); parseErr == nil {
+		return !lastModified.Truncate(time.Second).After(ifModifiedSince)
+	}
+
+	return false
+}
+
+// registerTuners serves streams from a specific tuner at /tunerN/vCHANNEL, like a real HDHomeRun.
+func registerTuners(router gin.IRouter, live *liveLineup, favoritesOnly bool) {
+	for idx := 0; idx < live.load().tuners.count(); idx++ {
+		router.GET(fmt.Sprintf("/tuner%d/:channelID", idx), stream(live, idx, favoritesOnly))
 	}
 }
 
-func stream(lineup *lineup) gin.HandlerFunc {
+func serveTunerStatus(live *liveLineup) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, live.load().tuners.status())
+	}
+}
+
+// stream serves a channel from the tuner, or any free one for autoTuner.
+// Streams going through ffmpeg occupy their tuner until they end. Redirected clients fetch the stream from its source
+// themselves, so their tuner is only held for iptv.redirect-hold, and duration or transcode can't be honoured.
+func stream(live *liveLineup, tuner int, favoritesOnly bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lineup := live.load()
 		channelIDStr := c.Param("channelID")[1:]
-		channelID, channelIDErr := strconv.Atoi(channelIDStr)
+		channelID, channelIDErr := providers.ParseChannelNumber(channelIDStr)
 		if channelIDErr != nil {
 			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("that (%s) doesn't appear to be a valid channel number", channelIDStr))
 			return
 		}
 
-		if channel, ok := lineup.channels[channelID]; ok {
-			channelURI := channel.providerChannel.Track.URI
+		options, optionsErr := parseStreamOptions(c.Query("duration"), c.Query("transcode"))
+		if optionsErr != nil {
+			c.AbortWithError(http.StatusBadRequest, optionsErr)
+			return
+		}
+
+		channel, ok := lineup.channels[channelID]
+		if !ok || (favoritesOnly && !channel.providerChannel.Favorite) {
+			c.AbortWithError(http.StatusNotFound, fmt.Errorf("unknown channel number %s", channelID))
+			return
+		}
+
+		channelURI := channel.streamURI()
 
-			log.Infof("Serving channel number %d", channelID)
+		log.Infof("Serving channel number %s", channelID)
 
-			if !lineup.FfmpegEnabled {
-				log.Debugf("Redirecting caller to %s", channelURI)
-				c.Redirect(http.StatusMovedPermanently, channelURI.String())
+		if !lineup.FfmpegEnabled {
+			if options.isSet() {
+				c.AbortWithError(http.StatusNotImplemented, fmt.Errorf("duration and transcode need ffmpeg, which is disabled"))
 				return
 			}
-
-			log.Infoln("Remuxing stream with ffmpeg")
-			run := exec.Command("ffmpeg", "-i", "pipe:0", "-c:v", "copy", "-f", "mpegts", "pipe:1")
-			log.Debugf("Executing ffmpeg as \"%s\"", strings.Join(run.Args, " "))
-			ffmpegout, err := run.StdoutPipe()
-			if err != nil {
-				log.WithError(err).Errorln("StdoutPipe Error")
+			if _, tunerErr := lineup.tuners.hold(tuner, channel, targetIP(c.Request.RemoteAddr)); tunerErr != nil {
+				c.Header("X-HDHomeRun-Error", tunerErr.Error())
+				c.AbortWithError(http.StatusServiceUnavailable, tunerErr)
 				return
 			}
+			log.Debugf("Redirecting caller to %s", channelURI)
+			c.Redirect(http.StatusMovedPermanently, channelURI.String())
+			return
+		}
 
-			stderr, stderrErr := run.StderrPipe()
-			if stderrErr != nil {
-				log.WithError(stderrErr).Errorln("Error creating ffmpeg stderr pipe")
-			}
+		session, tunerErr := lineup.tuners.acquire(c.Request.Context(), tuner, channel, targetIP(c.Request.RemoteAddr))
+		if tunerErr != nil {
+			c.Header("X-HDHomeRun-Error", tunerErr.Error())
+			c.AbortWithError(http.StatusServiceUnavailable, tunerErr)
+			return
+		}
+		defer session.release()
+
+		log.Infof("Remuxing stream with ffmpeg on tuner%d", session.index)
+		// The context is done when the client goes away or telly stops, which kills ffmpeg.
+		run := exec.CommandContext(session.ctx, "ffmpeg", options.ffmpegArgs(channelURI.String())...)
+		log.Debugf("Executing ffmpeg as \"%s\"", strings.Join(run.Args, " "))
+		ffmpegout, err := run.StdoutPipe()
+		if err != nil {
+			log.WithError(err).Errorln("StdoutPipe Error")
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
 
-			if startErr := run.Start(); startErr != nil {
-				log.WithError(startErr).Errorln("Error starting ffmpeg")
-				return
-			}
-			defer run.Wait()
+		stderr, stderrErr := run.StderrPipe()
+		if stderrErr != nil {
+			log.WithError(stderrErr).Errorln("Error creating ffmpeg stderr pipe")
+			c.AbortWithError(http.StatusInternalServerError, stderrErr)
+			return
+		}
 
-			go func() {
-				scanner := bufio.NewScanner(stderr)
-				scanner.Split(split)
-				for scanner.Scan() {
-					log.Println(scanner.Text())
-				}
-			}()
+		if startErr := run.Start(); startErr != nil {
+			log.WithError(startErr).Errorln("Error starting ffmpeg")
+			c.AbortWithError(http.StatusInternalServerError, startErr)
+			return
+		}
 
-			continueStream := true
-			c.Header("Content-Type", `video/mpeg; codecs="avc1.4D401E"`)
+		go func() {
+			scanner := bufio.NewScanner(stderr)
+			scanner.Split(split)
+			for scanner.Scan() {
+				log.Println(scanner.Text())
+			}
+		}()
 
-			c.Stream(func(w io.Writer) bool {
-				defer func() {
-					log.Infoln("Stopped streaming", channelID)
-					if killErr := run.Process.Kill(); killErr != nil {
-						panic(killErr)
-					}
-					continueStream = false
-					return
-				}()
-				if _, copyErr := io.Copy(w, ffmpegout); copyErr != nil {
-					log.WithError(copyErr).Errorln("Error when copying data")
-					continueStream = false
-					return false
-				}
-				return continueStream
-			})
+		c.Header("Content-Type", `video/mpeg; codecs="avc1.4D401E"`)
+		c.Status(http.StatusOK)
 
-			return
+		if _, copyErr := session.copyStream(c.Writer, ffmpegout); copyErr != nil {
+			log.WithError(copyErr).Errorln("Error when copying data")
 		}
 
-		c.AbortWithError(http.StatusNotFound, fmt.Errorf("unknown channel number %d", channelID))
+		// ffmpeg has usually finished or been killed by now, so an error only means there was nothing to stop.
+		run.Process.Kill()
+		if waitErr := run.Wait(); waitErr != nil {
+			log.WithError(waitErr).Debugln("ffmpeg exited")
+		}
+
+		log.Infoln("Stopped streaming", channelID)
 	}
 }
 
@@ -278,32 +489,46 @@ func ginrus() gin.HandlerFunc {
 	}
 }
 
-func setupSSDP(baseAddress, deviceName, deviceUUID string) (*ssdp.Advertiser, error) {
-	log.Debugf("Advertising telly as %s (%s)", deviceName, deviceUUID)
+// setupHDHomeRunDiscovery answers HDHomeRun discovery requests for the devices on the UDP address, until the returned
+// connection is closed.
+func setupHDHomeRunDiscovery(address string, devices ...DiscoveryData) (net.PacketConn, error) {
+	hdhrDevices := make([]hdhomerun.Device, 0, len(devices))
+	for _, data := range devices {
+		deviceID, idErr := hdhomerun.ParseDeviceID(data.DeviceID)
+		if idErr != nil {
+			return nil, idErr
+		}
+		if !hdhomerun.ValidDeviceID(deviceID) {
+			log.Warnf("Device ID %s doesn't have a valid HDHomeRun checksum, some clients may ignore it", data.DeviceID)
+		}
 
-	adv, err := ssdp.Advertise(
-		"upnp:rootdevice",
-		fmt.Sprintf("uuid:%s::upnp:rootdevice", deviceUUID),
-		fmt.Sprintf("http://%s/device.xml", baseAddress),
-		deviceName,
-		1800)
+		hdhrDevices = append(hdhrDevices, hdhomerun.Device{
+			ID:         deviceID,
+			TunerCount: data.TunerCount,
+			BaseURL:    data.BaseURL,
+			LineupURL:  data.LineupURL,
+			DeviceAuth: data.DeviceAuth,
+		})
+	}
 
-	if err != nil {
-		return nil, err
+	conn, listenErr := net.ListenPacket("udp4", address)
+	if listenErr != nil {
+		return nil, listenErr
 	}
 
-	go func(advertiser *ssdp.Advertiser) {
-		aliveTick := time.Tick(15 * time.Second)
+	log.Debugf("Answering HDHomeRun discovery requests on %s", conn.LocalAddr())
 
-		for {
-			<-aliveTick
-			if err := advertiser.Alive(); err != nil {
-				log.WithError(err).Panicln("error when sending ssdp heartbeat")
-			}
+	go func() {
+		serveErr := hdhomerun.Serve(conn, func(replyErr error) {
+			log.WithError(replyErr).Warnln("error answering HDHomeRun discovery request")
+		}, hdhrDevices...)
+		// Closing the connection on shutdown is the expected way to stop.
+		if !strings.Contains(serveErr.Error(), "use of closed network connection") {
+			log.WithError(serveErr).Errorln("stopped answering HDHomeRun discovery requests")
 		}
-	}(adv)
+	}()
 
-	return adv, nil
+	return conn, nil
 }
 
 func split(data []byte, atEOF bool) (advance int, token []byte, spliterror error) {
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tellytv/telly/internal/providers"
+)
+
+func testRouter(t *testing.T) (*gin.Engine, *liveLineup) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	provider := testProvider(t, providers.Configuration{Name: "test"})
+	l := testLineup(provider)
+	favorite := testChannel("BBC One", "bbc1", "1")
+	favorite.Favorite = true
+	l.channels = l.assignChannelNumbers([]pendingChannel{
+		{provider: provider, channel: testChannel("BBC Two", "bbc2", "2")},
+		{provider: provider, channel: favorite},
+		{provider: provider, channel: testChannel("ITV", "itv", "3.1")},
+	})
+	live := newLiveLineup(l)
+
+	router := gin.New()
+	router.GET("/lineup.json", serveLineup(live, false))
+	router.GET("/lineup.xml", serveLineup(live, false))
+	router.GET("/favorites/lineup.json", serveLineup(live, true))
+	router.GET("/epg.xml", xmlTV(live, false, false))
+	router.GET("/auto/:channelID", stream(live, autoTuner, false))
+	router.GET("/favorites/auto/:channelID", stream(live, autoTuner, true))
+	registerTuners(router, live, false)
+	return router, live
+}
+
+func TestServeLineup(t *testing.T) {
+	router, _ := testRouter(t)
+
+	tests := []struct {
+		url      string
+		code     int
+		expected string
+	}{
+		{"/lineup.json", http.StatusOK, "1 BBC One  0|2 BBC Two  0|3.1 ITV  0"},
+		{"/lineup.json?show=found", http.StatusOK, "1 BBC One  0|2 BBC Two  0|3.1 ITV  0"},
+		{"/lineup.json?tuning", http.StatusOK, "1 BBC One ip 1|2 BBC Two ip 2|3.1 ITV ip 3"},
+		{"/favorites/lineup.json?tuning", http.StatusOK, "1 BBC One ip 1"},
+		{"/lineup.json?show=everything", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if recorder.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.url, test.code, recorder.Code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		var channels []hdHomeRunLineupItem
+		if unmarshalErr := json.Unmarshal(recorder.Body.Bytes(), &channels); unmarshalErr != nil {
+			t.Fatal(unmarshalErr)
+		}
+		entries := make([]string, 0, len(channels))
+		for _, channel := range channels {
+			entries = append(entries, strings.Join([]string{channel.GuideNumber, channel.GuideName, channel.Modulation, strconv.Itoa(channel.ProgramNumber)}, " "))
+		}
+		if actual := strings.Join(entries, "|"); actual != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.url, test.expected, actual)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/lineup.xml?tuning", nil))
+	if body := recorder.Body.String(); recorder.Code != http.StatusOK || !strings.Contains(body, "<Modulation>ip</Modulation>") || !strings.Contains(body, "<ProgramNumber>3</ProgramNumber>") {
+		t.Errorf("expected the XML lineup to have tuning details, got %d: %s", recorder.Code, body)
+	}
+}
+
+func TestStreamWithoutFFmpeg(t *testing.T) {
+	router, live := testRouter(t)
+
+	tests := []struct {
+		url      string
+		code     int
+		location string
+		hdhrErr  string
+	}{
+		{"/auto/v2?duration=60", http.StatusNotImplemented, "", ""},
+		{"/auto/v2?transcode=mobile", http.StatusNotImplemented, "", ""},
+		{"/auto/v2?transcode=ultra", http.StatusBadRequest, "", ""},
+		{"/auto/vx", http.StatusBadRequest, "", ""},
+		{"/auto/v9", http.StatusNotFound, "", ""},
+		{"/favorites/auto/v2", http.StatusNotFound, "", ""},
+		{"/favorites/auto/v1", http.StatusMovedPermanently, "http://example.com/BBC%20One.ts", ""},
+		{"/tuner1/v3.1", http.StatusMovedPermanently, "http://example.com/ITV.ts", ""},
+		{"/tuner1/v2", http.StatusServiceUnavailable, "", errTunerInUse.Error()},
+		{"/auto/v2", http.StatusServiceUnavailable, "", errAllTunersInUse.Error()},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if recorder.Code != test.code || recorder.Header().Get("Location") != test.location || recorder.Header().Get("X-HDHomeRun-Error") != test.hdhrErr {
+			t.Errorf("%s: expected %d to %q with error %q, got %d to %q with error %q", test.url, test.code, test.location, test.hdhrErr,
+				recorder.Code, recorder.Header().Get("Location"), recorder.Header().Get("X-HDHomeRun-Error"))
+		}
+	}
+
+	statuses := live.load().tuners.status()
+	if statuses[0].VctNumber != "1" || statuses[1].VctNumber != "3.1" {
+		t.Errorf("expected the redirected channels to hold their tuners, got %+v", statuses)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"GZIP", true},
+		{"x-gzip", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0, deflate", false},
+		{"identity", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*, gzip;q=0", false},
+	}
+
+	for _, test := range tests {
+		if actual := acceptsGzip(test.header); actual != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.header, test.expected, actual)
+		}
+	}
+}
+
+func TestXMLTVValidators(t *testing.T) {
+	router, live := testRouter(t)
+
+	request := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/epg.xml", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Before the first scan there is nothing to revalidate against.
+	recorder := request("If-Modified-Since", time.Now().UTC().Format(http.TimeFormat))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("before scanning: expected %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if etag := recorder.Header().Get("ETag"); etag != "" {
+		t.Errorf("before scanning: expected no ETag, got %s", etag)
+	}
+	if lastModified := recorder.Header().Get("Last-Modified"); lastModified != "" {
+		t.Errorf("before scanning: expected no Last-Modified, got %s", lastModified)
+	}
+
+	scanned := *live.load()
+	scanned.LastScan = time.Now().Add(-time.Minute)
+	if swapErr := live.swap(func() (*lineup, error) { return &scanned, nil }); swapErr != nil {
+		t.Fatal(swapErr)
+	}
+
+	recorder = request("Accept-Encoding", "gzip;q=0")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("after scanning: expected %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if encoding := recorder.Header().Get("Content-Encoding"); encoding != "" {
+		t.Errorf("gzip;q=0: expected an uncompressed guide, got %s", encoding)
+	}
+	etag := recorder.Header().Get("ETag")
+	if etag == "" || strings.Contains(etag, "gzip") {
+		t.Errorf("gzip;q=0: expected the uncompressed ETag, got %q", etag)
+	}
+
+	if recorder = request("If-None-Match", etag); recorder.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: expected %d, got %d", http.StatusNotModified, recorder.Code)
+	}
+}
@@ -0,0 +1,791 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	schedulesdirect "github.com/tellytv/go.schedulesdirect"
+	"github.com/tellytv/telly/internal/providers"
+	"github.com/tellytv/telly/internal/xmltv"
+)
+
+const (
+	// sdDefaultDays is how many days of schedules are requested when a source doesn't say.
+	sdDefaultDays = 7
+
+	// sdRetryBackoff is how long Schedules Direct is left alone after the first failure, doubling for each further failure.
+	sdRetryBackoff = 5 * time.Minute
+	// sdMaxRetryBackoff caps the time between attempts.
+	sdMaxRetryBackoff = 6 * time.Hour
+)
+
+// errSDTokenRejected is returned for requests outside of the client library that Schedules Direct refused the token of.
+var errSDTokenRejected = errors.New("schedules direct rejected the token")
+
+// sdSession is the Schedules Direct client that scans and the image proxy share. Every request that sends the token
+// holds the read lock, so the token is only replaced, under the write lock, while no request is using it.
+// It also keeps what has to outlive the lineup of a single scan: the failures Schedules Direct is backed off for and
+// the guide last built for each source, which is served again while Schedules Direct fails.
+type sdSession struct {
+	mu       sync.RWMutex
+	client   *schedulesdirect.Client
+	username string
+	password string
+
+	stateMu sync.Mutex
+	status  schedulesDirectStatus
+	guides  map[string]*xmltv.TV
+}
+
+func newSDSession(username, password string) (*sdSession, error) {
+	client, clientErr := schedulesdirect.NewClient(username, password)
+	if clientErr != nil {
+		return nil, clientErr
+	}
+	return &sdSession{client: client, username: username, password: password}, nil
+}
+
+// do runs fn with the client. If the token was rejected a new one is requested and fn is run once more.
+func (s *sdSession) do(fn func(client *schedulesdirect.Client) error) error {
+	token, err := s.run(fn)
+	if !isSDTokenError(err) {
+		return err
+	}
+
+	if renewErr := s.renewToken(token); renewErr != nil {
+		return fmt.Errorf("error getting a new token from schedules direct: %s", renewErr)
+	}
+
+	_, err = s.run(fn)
+	return err
+}
+
+// run runs fn under the read lock and returns the token it was run with.
+func (s *sdSession) run(fn func(client *schedulesdirect.Client) error) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.client.Token, fn(s.client)
+}
+
+// renewToken logs in again, unless a concurrent request already replaced the rejected token.
+func (s *sdSession) renewToken(rejected string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client.Token != rejected {
+		return nil
+	}
+
+	token, tokenErr := s.client.GetToken(s.username, s.password)
+	if tokenErr != nil {
+		return tokenErr
+	}
+	s.client.Token = token
+	return nil
+}
+
+// isSDTokenError reports whether Schedules Direct refused a request because of its token.
+func isSDTokenError(err error) bool {
+	if err == errSDTokenRejected {
+		return true
+	}
+	if resp, ok := err.(*schedulesdirect.BaseResponse); ok {
+		return resp.Code == schedulesdirect.ErrTokenExpired || resp.Code == schedulesdirect.ErrTokenMissing
+	}
+	return false
+}
+
+// schedulesDirectStatus tracks consecutive Schedules Direct failures.
+type schedulesDirectStatus struct {
+	ConsecutiveFailures int
+	LastError           string `json:",omitempty"`
+	LastFailure         time.Time
+	RetryAfter          time.Time
+}
+
+// sdReady reports whether Schedules Direct should be asked for data, or is still being backed off from after failures.
+func (l *lineup) sdReady() bool {
+	if l.sd == nil {
+		return false
+	}
+
+	l.SchedulesDirectStatus = l.sd.currentStatus()
+	if time.Now().Before(l.SchedulesDirectStatus.RetryAfter) {
+		log.Warnf("Schedules Direct failed %d times in a row, not trying again until %s", l.SchedulesDirectStatus.ConsecutiveFailures, l.SchedulesDirectStatus.RetryAfter.Format(time.RFC3339))
+		return false
+	}
+
+	return true
+}
+
+// sdFailed records a Schedules Direct failure and backs off exponentially.
+func (l *lineup) sdFailed(err error) {
+	l.SchedulesDirectStatus = l.sd.failed(err)
+}
+
+// sdSucceeded clears the failure count after a successful exchange with Schedules Direct.
+func (l *lineup) sdSucceeded() {
+	l.SchedulesDirectStatus = l.sd.succeeded()
+}
+
+// currentStatus returns the failures Schedules Direct is being backed off for.
+func (s *sdSession) currentStatus() schedulesDirectStatus {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.status
+}
+
+// failed records a failure and returns the updated status.
+func (s *sdSession) failed(err error) schedulesDirectStatus {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	status := &s.status
+	status.ConsecutiveFailures++
+	status.LastError = err.Error()
+	status.LastFailure = time.Now()
+
+	backoff := sdRetryBackoff
+	for i := 1; i < status.ConsecutiveFailures && backoff < sdMaxRetryBackoff; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > sdMaxRetryBackoff {
+		backoff = sdMaxRetryBackoff
+	}
+
+	status.RetryAfter = status.LastFailure.Add(backoff)
+	return *status
+}
+
+// succeeded clears the failure count and returns the updated status.
+func (s *sdSession) succeeded() schedulesDirectStatus {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.status.ConsecutiveFailures = 0
+	s.status.RetryAfter = time.Time{}
+	return s.status
+}
+
+// lastGuide returns the guide last built for the source, or nil if there is none.
+func (s *sdSession) lastGuide(source string) *xmltv.TV {
+	if s == nil {
+		return nil
+	}
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.guides[source]
+}
+
+// keepGuide remembers the guide built for the source, which must not be changed afterwards.
+func (s *sdSession) keepGuide(source string, guide *xmltv.TV) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if s.guides == nil {
+		s.guides = make(map[string]*xmltv.TV)
+	}
+	s.guides[source] = guide
+}
+
+// getSchedulesDirectPrograms returns program information and artwork for the given TMS IDs.
+// Programs found in the cache, unchanged according to md5s if known, are not requested again.
+// The returned map is keyed by TMS ID.
+func (l *lineup) getSchedulesDirectPrograms(tmsIDs []string, md5s map[string]string) ([]schedulesdirect.ProgramInfo, map[string][]schedulesdirect.ProgramArtwork, error) {
+	allResponses := make([]schedulesdirect.ProgramInfo, 0)
+
+	artworkMap := make(map[string][]schedulesdirect.ProgramArtwork)
+
+	missingIDs := make([]string, 0)
+
+	for _, tmsID := range tmsIDs {
+		if cached, ok := l.sdCache.get(tmsID, md5s[tmsID]); ok {
+			allResponses = append(allResponses, cached.Info)
+			if len(cached.Artwork) > 0 {
+				artworkMap[tmsID] = cached.Artwork
+			}
+			continue
+		}
+		missingIDs = append(missingIDs, tmsID)
+	}
+
+	hits, misses := l.sdCache.stats()
+	if l.sdCache != nil {
+		log.Infof("Schedules Direct cache had %d hits and %d misses", hits, misses)
+	}
+
+	fetched := make([]schedulesdirect.ProgramInfo, 0)
+
+	chunks := chunkStringSlice(missingIDs, 5000)
+
+	log.Infof("Making %d requests to Schedules Direct for program information, this might take a while", len(chunks))
+
+	for _, chunk := range chunks {
+		var moreInfo []schedulesdirect.ProgramInfo
+		moreInfoErr := l.sd.do(func(client *schedulesdirect.Client) (err error) {
+			moreInfo, err = client.GetProgramInfo(chunk)
+			return err
+		})
+		if moreInfoErr != nil {
+			log.WithError(moreInfoErr).Errorln("Error when getting more program details from Schedules Direct")
+			l.sdFailed(moreInfoErr)
+			return nil, nil, moreInfoErr
+		}
+
+		log.Debugf("received %d responses for chunk", len(moreInfo))
+
+		fetched = append(fetched, moreInfo...)
+	}
+
+	artworkTMSIDs := make([]string, 0)
+
+	for _, entry := range fetched {
+		if entry.HasArtwork() {
+			artworkTMSIDs = append(artworkTMSIDs, entry.ProgramID)
+		}
+	}
+
+	chunks = chunkStringSlice(artworkTMSIDs, 500)
+
+	var artworkErr error
+
+	log.Infof("Making %d requests to Schedules Direct for artwork, this might take a while", len(chunks))
+
+	for _, chunk := range chunks {
+		var artwork []schedulesdirect.ProgramArtworkResponse
+		chunkErr := l.sd.do(func(client *schedulesdirect.Client) (err error) {
+			artwork, err = client.GetArtworkForProgramIDs(chunk)
+			return err
+		})
+		if chunkErr != nil {
+			// Program information alone is still worth having, so carry on without artwork.
+			log.WithError(chunkErr).Errorln("Error when getting program artwork from Schedules Direct, continuing without it")
+			l.sdFailed(chunkErr)
+			artworkErr = chunkErr
+			break
+		}
+
+		for _, artworks := range artwork {
+			if artworks.ProgramID == "" || artworks.Artwork == nil {
+				continue
+			}
+			artworkMap[artworks.ProgramID] = append(artworkMap[artworks.ProgramID], *artworks.Artwork...)
+		}
+	}
+
+	log.Debugf("Got %d responses from SD", len(fetched))
+
+	for _, entry := range fetched {
+		// Don't cache programs whose artwork failed to load, so it is requested again next time.
+		if artworkErr != nil && entry.HasArtwork() && len(artworkMap[entry.ProgramID]) == 0 {
+			continue
+		}
+		l.sdCache.put(entry, artworkMap[entry.ProgramID])
+	}
+
+	if artworkErr == nil {
+		l.sdSucceeded()
+	}
+
+	if saveErr := l.sdCache.save(); saveErr != nil {
+		log.WithError(saveErr).Warnln("unable to save the Schedules Direct cache")
+	}
+
+	return append(allResponses, fetched...), artworkMap, nil
+}
+
+// getSchedulesDirectXMLTV builds a complete XMLTV guide from the Schedules Direct lineups and stations in the config.
+func (l *lineup) getSchedulesDirectXMLTV(config *providers.SchedulesDirectConfiguration) (*xmltv.TV, error) {
+	if l.sd == nil {
+		return nil, fmt.Errorf("a Schedules Direct guide was requested but no Schedules Direct credentials are configured")
+	}
+
+	if !l.sdReady() {
+		return nil, fmt.Errorf("backing off from Schedules Direct after %d failures: %s", l.SchedulesDirectStatus.ConsecutiveFailures, l.SchedulesDirectStatus.LastError)
+	}
+
+	if addErr := l.ensureSchedulesDirectLineups(config.Lineups); addErr != nil {
+		l.sdFailed(addErr)
+		return nil, addErr
+	}
+
+	wantedStations := make(map[string]bool)
+	for _, station := range config.Stations {
+		wantedStations[station] = true
+	}
+
+	epg := &xmltv.TV{
+		SourceInfoName: "Schedules Direct",
+		SourceInfoURL:  "https://www.schedulesdirect.org",
+	}
+
+	stationIDs := make([]string, 0)
+	channelIDs := make(map[string]string) // station ID:XMLTV channel ID
+
+	for _, lineupID := range config.Lineups {
+		log.Infof("Loading channels of Schedules Direct lineup %s", lineupID)
+
+		var channels *schedulesdirect.ChannelResponse
+		channelsErr := l.sd.do(func(client *schedulesdirect.Client) (err error) {
+			channels, err = client.GetChannels(lineupID, false)
+			return err
+		})
+		if channelsErr != nil {
+			l.sdFailed(channelsErr)
+			return nil, fmt.Errorf("error getting channels for Schedules Direct lineup %s: %s", lineupID, channelsErr)
+		}
+
+		for _, station := range channels.Stations {
+			if _, seen := channelIDs[station.StationID]; seen {
+				continue
+			}
+			if len(wantedStations) > 0 && !wantedStations[station.StationID] && !wantedStations[station.CallSign] {
+				continue
+			}
+
+			channel := schedulesDirectChannel(station)
+			epg.Channels = append(epg.Channels, channel)
+			channelIDs[station.StationID] = channel.ID
+			stationIDs = append(stationIDs, station.StationID)
+		}
+	}
+
+	days := config.Days
+	if days <= 0 {
+		days = sdDefaultDays
+	}
+
+	dates := make([]string, 0, days)
+	today := time.Now().UTC()
+	for day := 0; day < days; day++ {
+		dates = append(dates, today.AddDate(0, 0, day).Format("2006-01-02"))
+	}
+
+	log.Infof("Requesting %d days of schedules for %d stations from Schedules Direct", days, len(stationIDs))
+
+	airings := make([]xmltv.Programme, 0)
+	tmsIDs := make([]string, 0)
+	md5s := make(map[string]string) // TMS ID:MD5 of the current program information
+
+	schedules, schedulesErr := l.getSchedulesDirectSchedules(stationIDs, dates)
+	if schedulesErr != nil {
+		return nil, schedulesErr
+	}
+
+	for _, schedule := range schedules {
+		for _, program := range schedule.Programs {
+			if program.AirDateTime == nil || program.ProgramID == "" {
+				continue
+			}
+
+			airings = append(airings, schedulesDirectAiring(channelIDs[schedule.StationID], program))
+
+			if _, seen := md5s[program.ProgramID]; !seen {
+				md5s[program.ProgramID] = program.MD5
+				tmsIDs = append(tmsIDs, program.ProgramID)
+			}
+		}
+	}
+
+	programInfos, artworkMap, programsErr := l.getSchedulesDirectPrograms(tmsIDs, md5s)
+	if programsErr != nil {
+		return nil, programsErr
+	}
+
+	infoMap := make(map[string]schedulesdirect.ProgramInfo)
+	for _, info := range programInfos {
+		infoMap[info.ProgramID] = info
+	}
+
+	for _, airing := range airings {
+		programID := airing.ID
+		airing.ID = ""
+
+		info, ok := infoMap[programID]
+		if !ok {
+			log.Debugf("Schedules Direct returned no program information for %s, skipping", programID)
+			continue
+		}
+
+		if info.EpisodeTitle150 != "" {
+			airing.SecondaryTitles = append(airing.SecondaryTitles, xmltv.CommonElement{Value: info.EpisodeTitle150})
+		}
+
+		epg.Programmes = append(epg.Programmes, *MergeSchedulesDirectAndXMLTVProgramme(&airing, info, artworkMap[programID], l.sdMergeOptions))
+	}
+
+	log.Infof("Built a guide of %d channels and %d programmes from Schedules Direct", len(epg.Channels), len(epg.Programmes))
+
+	return epg, nil
+}
+
+// getSchedulesDirectSchedules returns what the stations air on the dates, formatted as 2006-01-02.
+func (l *lineup) getSchedulesDirectSchedules(stationIDs, dates []string) ([]schedulesdirect.Schedule, error) {
+	allSchedules := make([]schedulesdirect.Schedule, 0)
+
+	for _, chunk := range chunkStringSlice(stationIDs, 500) {
+		requests := make([]schedulesdirect.StationScheduleRequest, 0, len(chunk))
+		for _, stationID := range chunk {
+			requests = append(requests, schedulesdirect.StationScheduleRequest{StationID: stationID, Dates: dates})
+		}
+
+		var schedules []schedulesdirect.Schedule
+		schedulesErr := l.sd.do(func(client *schedulesdirect.Client) (err error) {
+			schedules, err = client.GetSchedules(requests)
+			return err
+		})
+		if schedulesErr != nil {
+			l.sdFailed(schedulesErr)
+			return nil, fmt.Errorf("error getting schedules from Schedules Direct: %s", schedulesErr)
+		}
+
+		allSchedules = append(allSchedules, schedules...)
+	}
+
+	return allSchedules, nil
+}
+
+// getSchedulesDirectMD5s returns, by TMS ID, the MD5 of the current information of the programs the stations air on
+// the dates, so programs cached for XMLTV enrichment are only requested again when they changed.
+func (l *lineup) getSchedulesDirectMD5s(stationIDs, dates []string) (map[string]string, error) {
+	md5s := make(map[string]string)
+	if len(stationIDs) == 0 || len(dates) == 0 {
+		return md5s, nil
+	}
+
+	schedules, schedulesErr := l.getSchedulesDirectSchedules(stationIDs, dates)
+	if schedulesErr != nil {
+		return nil, schedulesErr
+	}
+
+	for _, schedule := range schedules {
+		for _, program := range schedule.Programs {
+			if program.ProgramID != "" && program.MD5 != "" {
+				md5s[program.ProgramID] = program.MD5
+			}
+		}
+	}
+
+	return md5s, nil
+}
+
+// sdScheduleDates returns the days, from today on, that the programmes air on, formatted as schedules are requested.
+func sdScheduleDates(programmes map[string][]xmltv.Programme, now time.Time) []string {
+	today := now.UTC().Format("2006-01-02")
+	seen := make(map[string]bool)
+	dates := make([]string, 0)
+
+	for _, airings := range programmes {
+		for _, programme := range airings {
+			if programme.Start == nil {
+				continue
+			}
+			if date := programme.Start.Time.UTC().Format("2006-01-02"); date >= today && !seen[date] {
+				seen[date] = true
+				dates = append(dates, date)
+			}
+		}
+	}
+
+	sort.Strings(dates)
+	return dates
+}
+
+// ensureSchedulesDirectLineups adds any lineups that aren't on the Schedules Direct account yet.
+func (l *lineup) ensureSchedulesDirectLineups(lineupIDs []string) error {
+	var existing *schedulesdirect.LineupResponse
+	existingErr := l.sd.do(func(client *schedulesdirect.Client) (err error) {
+		existing, err = client.GetLineups()
+		return err
+	})
+	if existingErr != nil {
+		return fmt.Errorf("error getting lineups from Schedules Direct: %s", existingErr)
+	}
+
+	onAccount := make(map[string]bool)
+	for _, lineup := range existing.Lineups {
+		onAccount[lineup.Lineup] = true
+	}
+
+	for _, lineupID := range lineupIDs {
+		if onAccount[lineupID] {
+			continue
+		}
+
+		log.Infof("Adding lineup %s to the Schedules Direct account", lineupID)
+
+		addErr := l.sd.do(func(client *schedulesdirect.Client) error {
+			_, err := client.AddLineup(lineupID)
+			return err
+		})
+		if addErr != nil {
+			return fmt.Errorf("error adding lineup %s to Schedules Direct: %s", lineupID, addErr)
+		}
+	}
+
+	return nil
+}
+
+// schedulesDirectChannel converts a Schedules Direct station to a XMLTV channel.
+// The call sign is included as a display name so tracks can be matched by it. The channel number and the affiliate are
+// left out, as tracks of other stations would match them; the lineup number is set as lcn once it's assigned.
+func schedulesDirectChannel(station schedulesdirect.Station) xmltv.Channel {
+	channel := xmltv.Channel{
+		ID: providers.SchedulesDirectChannelID(station.StationID),
+	}
+
+	for _, name := range []string{station.CallSign, station.Name} {
+		if name != "" {
+			channel.DisplayNames = append(channel.DisplayNames, xmltv.CommonElement{Value: name})
+		}
+	}
+
+	if station.Logo != nil && station.Logo.URL != "" {
+		channel.Icons = append(channel.Icons, xmltv.Icon{Source: station.Logo.URL, Width: station.Logo.Width, Height: station.Logo.Height})
+	}
+
+	return channel
+}
+
+// schedulesDirectAiring converts a scheduled Schedules Direct program to a XMLTV programme without any program information.
+// The TMS ID is stashed in the programme ID until the program information is merged in.
+func schedulesDirectAiring(channelID string, program schedulesdirect.Program) xmltv.Programme {
+	start := *program.AirDateTime
+
+	airing := xmltv.Programme{
+		ID:      program.ProgramID,
+		Channel: channelID,
+		Start:   &xmltv.Time{Time: start},
+		Stop:    &xmltv.Time{Time: start.Add(time.Duration(program.Duration) * time.Second)},
+	}
+
+	if progID, parseErr := xmltv.ParseDDProgID(program.ProgramID); parseErr == nil {
+		airing.SetEpisode(xmltv.Episode{ProgID: &progID})
+	}
+
+	if program.New {
+		isNew := xmltv.ElementPresent(true)
+		airing.New = &isNew
+	}
+
+	return airing
+}
+
+// sdMergeOptions controls how Schedules Direct metadata is merged into programmes.
+type sdMergeOptions struct {
+	// Languages is the preferred order of languages, elements in other languages follow these.
+	Languages []string
+	// LongestDescription keeps the longest description per language instead of the shortest.
+	LongestDescription bool
+	// Artwork decides which images are added as icons.
+	Artwork sdArtworkPolicy
+}
+
+// schedulesDirectLanguage returns the language most of the program's descriptions are in, if any.
+func schedulesDirectLanguage(sdProgram schedulesdirect.ProgramInfo) string {
+	counts := make(map[string]int)
+	best := ""
+	for _, key := range sortedDescriptionKeys(sdProgram.Descriptions) {
+		for _, description := range sdProgram.Descriptions[key] {
+			if description.Language == "" {
+				continue
+			}
+			counts[description.Language]++
+			if counts[description.Language] > counts[best] {
+				best = description.Language
+			}
+		}
+	}
+	return best
+}
+
+// appendUniqueElement appends element unless an element with the same value, ignoring case, and a compatible language exists.
+func appendUniqueElement(elements []xmltv.CommonElement, element xmltv.CommonElement) []xmltv.CommonElement {
+	if element.Value == "" {
+		return elements
+	}
+	for _, existing := range elements {
+		sameLang := existing.Lang == element.Lang || existing.Lang == "" || element.Lang == ""
+		if sameLang && strings.EqualFold(existing.Value, element.Value) {
+			return elements
+		}
+	}
+	return append(elements, element)
+}
+
+// pickDescriptions keeps a single description per language, either the longest or the shortest. Descriptions without
+// a language that are repeated with one, as Schedules Direct often repeats those of the XMLTV, are dropped first, so
+// they don't stay behind as a description of their own.
+func pickDescriptions(descriptions []xmltv.CommonElement, longest bool) []xmltv.CommonElement {
+	picked := make([]xmltv.CommonElement, 0)
+	index := make(map[string]int)
+
+	withLang := make(map[string]bool)
+	for _, description := range descriptions {
+		if description.Lang != "" {
+			withLang[strings.ToLower(strings.TrimSpace(description.Value))] = true
+		}
+	}
+
+	for _, description := range descriptions {
+		if description.Value == "" || (description.Lang == "" && withLang[strings.ToLower(strings.TrimSpace(description.Value))]) {
+			continue
+		}
+
+		idx, ok := index[description.Lang]
+		if !ok {
+			index[description.Lang] = len(picked)
+			picked = append(picked, description)
+			continue
+		}
+
+		current := len(picked[idx].Value)
+		if (longest && len(description.Value) > current) || (!longest && len(description.Value) < current) {
+			picked[idx] = description
+		}
+	}
+
+	return picked
+}
+
+// sortByLanguage orders elements by the given language preference, keeping the original order otherwise.
+func sortByLanguage(elements []xmltv.CommonElement, languages []string) []xmltv.CommonElement {
+	if len(languages) == 0 {
+		return elements
+	}
+
+	rank := func(lang string) int {
+		for idx, preferred := range languages {
+			if strings.EqualFold(preferred, lang) {
+				return idx
+			}
+		}
+		return len(languages)
+	}
+
+	sort.SliceStable(elements, func(i, j int) bool { return rank(elements[i].Lang) < rank(elements[j].Lang) })
+
+	return elements
+}
+
+// mergeSchedulesDirectCredits adds the Schedules Direct cast and crew to the credits, in billing order.
+func mergeSchedulesDirectCredits(credits *xmltv.Credits, cast, crew []schedulesdirect.Person) *xmltv.Credits {
+	if len(cast) == 0 && len(crew) == 0 {
+		return credits
+	}
+
+	if credits == nil {
+		credits = &xmltv.Credits{}
+	}
+
+	type billedPerson struct {
+		schedulesdirect.Person
+		inCast bool
+	}
+
+	people := make([]billedPerson, 0, len(cast)+len(crew))
+	for _, person := range cast {
+		people = append(people, billedPerson{person, true})
+	}
+	for _, person := range crew {
+		people = append(people, billedPerson{person, false})
+	}
+	sort.SliceStable(people, func(i, j int) bool { return billingOrder(people[i].Person) < billingOrder(people[j].Person) })
+
+	// Schedules Direct often lists a person more than once in the same role.
+	seen := make(map[string]bool)
+
+	for _, person := range people {
+		key := strings.ToLower(person.Name + "\x00" + person.Role + "\x00" + person.CharacterName)
+		if person.Name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		switch strings.ToLower(person.Role) {
+		case "director", "co-director":
+			credits.Directors = appendUniqueString(credits.Directors, person.Name)
+		case "writer", "screenwriter", "co-writer", "story", "teleplay", "creator":
+			credits.Writers = appendUniqueString(credits.Writers, person.Name)
+		case "adaptation":
+			credits.Adapters = appendUniqueString(credits.Adapters, person.Name)
+		case "producer", "executive producer", "co-producer", "co-executive producer", "supervising producer":
+			credits.Producers = appendUniqueString(credits.Producers, person.Name)
+		case "composer", "music", "original music":
+			credits.Composers = appendUniqueString(credits.Composers, person.Name)
+		case "editor", "film editor":
+			credits.Editors = appendUniqueString(credits.Editors, person.Name)
+		case "host", "co-host", "presenter", "anchor", "narrator":
+			credits.Presenters = appendUniqueString(credits.Presenters, person.Name)
+		case "commentator", "analyst":
+			credits.Commentators = appendUniqueString(credits.Commentators, person.Name)
+		case "guest", "guest star", "guest voice", "musical guest":
+			credits.Guests = appendUniqueString(credits.Guests, person.Name)
+		default:
+			// Anyone else in the cast is an actor, other crew roles have no place in XMLTV.
+			if person.inCast {
+				credits.Actors = appendUniqueActor(credits.Actors, xmltv.Actor{Role: person.CharacterName, Value: person.Name})
+			}
+		}
+	}
+
+	return credits
+}
+
+// appendUniqueActor appends actor unless they are already credited for the same character. An actor credited
+// without a character is taken to be the same credit, which gets the character filled in.
+func appendUniqueActor(actors []xmltv.Actor, actor xmltv.Actor) []xmltv.Actor {
+	for idx, existing := range actors {
+		if existing.Value != actor.Value {
+			continue
+		}
+		if existing.Role == "" {
+			actors[idx].Role = actor.Role
+			return actors
+		}
+		if actor.Role == "" || strings.EqualFold(existing.Role, actor.Role) {
+			return actors
+		}
+	}
+	return append(actors, actor)
+}
+
+func billingOrder(person schedulesdirect.Person) int {
+	order, orderErr := strconv.Atoi(person.BillingOrder)
+	if orderErr != nil {
+		return math.MaxInt32
+	}
+	return order
+}
+
+func appendUniqueString(s []string, e string) []string {
+	if contains(s, e) {
+		return s
+	}
+	return append(s, e)
+}
+
+func sortedDescriptionKeys(m map[string][]schedulesdirect.Description) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeywordKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
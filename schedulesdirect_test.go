@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	schedulesdirect "github.com/tellytv/go.schedulesdirect"
+	"github.com/tellytv/telly/internal/providers"
+	"github.com/tellytv/telly/internal/xmltv"
+)
+
+// testSDServer fakes the Schedules Direct API with a single lineup of two stations and one scheduled episode on KERA.
+func testSDServer(t *testing.T) (*httptest.Server, *[]string) {
+	added := make([]string, 0)
+
+	mux := http.NewServeMux()
+	api := "/" + schedulesdirect.APIVersion
+	mux.HandleFunc(api+"/lineups", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"lineups": [{"lineup": "USA-OTA-90210"}]}`)
+	})
+	mux.HandleFunc(api+"/lineups/", func(w http.ResponseWriter, r *http.Request) {
+		lineupID := r.URL.Path[len(api+"/lineups/"):]
+		if r.Method == http.MethodPut {
+			added = append(added, lineupID)
+			fmt.Fprint(w, `{"code": 0}`)
+			return
+		}
+		fmt.Fprint(w, `{
+			"map": [{"stationID": "10436", "channel": "13"}, {"stationID": "10437", "channel": "4"}],
+			"stations": [
+				{"stationID": "10436", "callsign": "KERA", "name": "KERA PBS", "affiliate": "PBS", "logo": {"URL": "https://example.com/kera.png", "width": 100, "height": 50}},
+				{"stationID": "10437", "callsign": "KDFW", "name": "KDFW FOX", "affiliate": "FOX"}
+			]
+		}`)
+	})
+	mux.HandleFunc(api+"/schedules", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"stationID": "10436", "programs": [
+			{"programID": "EP012345670001", "airDateTime": "2018-08-01T20:00:00Z", "duration": 1800, "md5": "abc", "new": true},
+			{"programID": "SH000000000000", "airDateTime": "2018-08-01T20:30:00Z", "duration": 1800, "md5": "def"}
+		]}]`)
+	})
+	mux.HandleFunc(api+"/programs", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"programID": "EP012345670001", "titles": [{"title120": "Nature"}], "episodeTitle150": "Pilot"}]`)
+	})
+	mux.HandleFunc(api+"/metadata/programs", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+
+	server := httptest.NewServer(mux)
+	return server, &added
+}
+
+func TestGetSchedulesDirectXMLTV(t *testing.T) {
+	server, added := testSDServer(t)
+	defer server.Close()
+
+	lineup := &lineup{sd: &sdSession{client: &schedulesdirect.Client{BaseURL: server.URL + "/", HTTP: server.Client(), Token: "token"}}}
+
+	epg, epgErr := lineup.getSchedulesDirectXMLTV(&providers.SchedulesDirectConfiguration{
+		Lineups:  []string{"USA-OTA-90210", "USA-OTA-12345"},
+		Stations: []string{"KERA"},
+		Days:     1,
+	})
+	if epgErr != nil {
+		t.Fatal(epgErr)
+	}
+
+	if len(*added) != 1 || (*added)[0] != "USA-OTA-12345" {
+		t.Errorf("expected only the missing lineup to be added, added %v", *added)
+	}
+
+	if len(epg.Channels) != 1 {
+		t.Fatalf("expected only KERA, got %d channels", len(epg.Channels))
+	}
+
+	channel := epg.Channels[0]
+	if channel.ID != "I10436.json.schedulesdirect.org" {
+		t.Errorf("expected the station ID in the channel ID, got %s", channel.ID)
+	}
+	if names := fmt.Sprint(channel.DisplayNames); names != fmt.Sprint([]xmltv.CommonElement{{Value: "KERA"}, {Value: "KERA PBS"}}) {
+		t.Errorf("expected only the call sign and the station name as display names, got %s", names)
+	}
+	if channel.LCN != 0 {
+		t.Errorf("expected the lcn to be left to the lineup, got %d", channel.LCN)
+	}
+	if len(channel.Icons) != 1 || channel.Icons[0].Source != "https://example.com/kera.png" {
+		t.Errorf("expected the station logo, got %v", channel.Icons)
+	}
+
+	if len(epg.Programmes) != 1 {
+		t.Fatalf("expected the airing without program information to be skipped, got %d programmes", len(epg.Programmes))
+	}
+
+	programme := epg.Programmes[0]
+	start := time.Date(2018, time.August, 1, 20, 0, 0, 0, time.UTC)
+	if programme.Channel != channel.ID || !programme.Start.Time.Equal(start) || !programme.Stop.Time.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("expected the airing on %s from %s for 30 minutes, got %s from %s to %s", channel.ID, start, programme.Channel, programme.Start.Time, programme.Stop.Time)
+	}
+	if programme.ID != "" {
+		t.Errorf("expected the TMS ID to be cleared from the programme ID, got %s", programme.ID)
+	}
+	if len(programme.Titles) != 1 || programme.Titles[0].Value != "Nature" {
+		t.Errorf("expected the title from the program information, got %v", programme.Titles)
+	}
+	if len(programme.SecondaryTitles) != 1 || programme.SecondaryTitles[0].Value != "Pilot" {
+		t.Errorf("expected the episode title as sub-title, got %v", programme.SecondaryTitles)
+	}
+	if len(programme.EpisodeNums) == 0 || programme.EpisodeNums[0].System != "dd_progid" || programme.EpisodeNums[0].Value != "EP01234567.0001" {
+		t.Errorf("expected a dd_progid episode number, got %v", programme.EpisodeNums)
+	}
+	if programme.New == nil {
+		t.Errorf("expected the airing to be marked new")
+	}
+}
+
+func TestGetSchedulesDirectXMLTVWithoutCredentials(t *testing.T) {
+	if _, epgErr := (&lineup{}).getSchedulesDirectXMLTV(&providers.SchedulesDirectConfiguration{Lineups: []string{"USA-OTA-90210"}}); epgErr == nil {
+		t.Errorf("expected an error without a Schedules Direct client")
+	}
+}
+
+func TestSchedulesDirectBackoff(t *testing.T) {
+	lineup := &lineup{sd: &sdSession{client: &schedulesdirect.Client{}}}
+
+	expected := []time.Duration{5 * time.Minute, 10 * time.Minute, 20 * time.Minute, 40 * time.Minute, 80 * time.Minute, 160 * time.Minute, 320 * time.Minute, 6 * time.Hour, 6 * time.Hour}
+	for idx, backoff := range expected {
+		lineup.sdFailed(fmt.Errorf("failure %d", idx+1))
+
+		status := lineup.SchedulesDirectStatus
+		if status.ConsecutiveFailures != idx+1 || status.LastError != fmt.Sprintf("failure %d", idx+1) {
+			t.Errorf("expected failure %d to be recorded, got %+v", idx+1, status)
+		}
+		if actual := status.RetryAfter.Sub(status.LastFailure); actual != backoff {
+			t.Errorf("failure %d: expected to back off for %s, got %s", idx+1, backoff, actual)
+		}
+		if lineup.sdReady() {
+			t.Errorf("failure %d: expected Schedules Direct not to be ready while backing off", idx+1)
+		}
+	}
+
+	// Every scan works on a copy of the lineup, which must still back off.
+	if lineup.clone().sdReady() {
+		t.Errorf("expected the next scan to keep backing off")
+	}
+
+	lineup.sdSucceeded()
+	if !lineup.sdReady() || lineup.SchedulesDirectStatus.ConsecutiveFailures != 0 {
+		t.Errorf("expected a success to clear the backoff, got %+v", lineup.SchedulesDirectStatus)
+	}
+
+	lineup.sd = nil
+	if lineup.sdReady() {
+		t.Errorf("expected Schedules Direct not to be ready without a client")
+	}
+}
+
+func TestGetSchedulesDirectXMLTVBacksOff(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	lineup := &lineup{sd: &sdSession{client: &schedulesdirect.Client{BaseURL: server.URL + "/", HTTP: server.Client(), Token: "token"}}}
+	config := &providers.SchedulesDirectConfiguration{Lineups: []string{"USA-OTA-90210"}}
+
+	if _, epgErr := lineup.getSchedulesDirectXMLTV(config); epgErr == nil {
+		t.Fatal("expected an error while Schedules Direct is down")
+	}
+	if lineup.SchedulesDirectStatus.ConsecutiveFailures != 1 {
+		t.Errorf("expected the failure to be recorded, got %+v", lineup.SchedulesDirectStatus)
+	}
+
+	if _, epgErr := lineup.getSchedulesDirectXMLTV(config); epgErr == nil {
+		t.Fatal("expected an error while backing off")
+	}
+	if requests != 1 {
+		t.Errorf("expected no requests while backing off, got %d", requests)
+	}
+}
+
+func TestPrepareEPGKeepsLastSchedulesDirectGuide(t *testing.T) {
+	sdServer, _ := testSDServer(t)
+	defer sdServer.Close()
+
+	down := false
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		sdServer.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	provider := testProvider(t, providers.Configuration{
+		Name:            "sd",
+		SchedulesDirect: &providers.SchedulesDirectConfiguration{Lineups: []string{"USA-OTA-90210"}, Stations: []string{"KERA"}},
+	})
+	scanned := testLineup(provider)
+	scanned.sd = &sdSession{client: &schedulesdirect.Client{BaseURL: server.URL + "/", HTTP: server.Client(), Token: "token"}}
+
+	if channels, programmes, epgErr := scanned.clone().prepareEPG(provider, false); epgErr != nil || len(channels) != 1 || len(programmes) != 1 {
+		t.Fatalf("expected the guide from Schedules Direct, got %d channels and %d programmes (%v)", len(channels), len(programmes), epgErr)
+	}
+
+	down = true
+	upRequests := requests
+	for scan := 1; scan <= 2; scan++ {
+		channels, programmes, epgErr := scanned.clone().prepareEPG(provider, false)
+		if epgErr != nil || len(channels) != 1 || len(programmes["I10436.json.schedulesdirect.org"]) != 1 {
+			t.Errorf("scan %d: expected the last guide while Schedules Direct is down, got %d channels and %v (%v)", scan, len(channels), programmes, epgErr)
+		}
+	}
+
+	if status := scanned.sd.currentStatus(); status.ConsecutiveFailures != 1 {
+		t.Errorf("expected the failure to be recorded across scans, got %+v", status)
+	}
+	if failedRequests := requests - upRequests; failedRequests != 1 {
+		t.Errorf("expected no requests while backing off, got %d", failedRequests-1)
+	}
+}
+
+func TestGetSchedulesDirectProgramsWithoutArtwork(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + schedulesdirect.APIVersion + "/programs":
+			fmt.Fprint(w, `[{"programID": "EP012345670001", "hasImageArtwork": true}, {"programID": "EP012345670002"}]`)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	dir, dirErr := ioutil.TempDir("", "telly-sdcache")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+
+	lineup := &lineup{
+		sd:      &sdSession{client: &schedulesdirect.Client{BaseURL: server.URL + "/", HTTP: server.Client(), Token: "token"}},
+		sdCache: loadSDProgramCache(filepath.Join(dir, "schedulesdirect-programs.json"), time.Hour),
+	}
+
+	programs, artworkMap, programsErr := lineup.getSchedulesDirectPrograms([]string{"EP012345670001", "EP012345670002"}, nil)
+	if programsErr != nil {
+		t.Fatalf("expected program information without artwork, got %s", programsErr)
+	}
+	if len(programs) != 2 || len(artworkMap) != 0 {
+		t.Errorf("expected 2 programs and no artwork, got %d and %d", len(programs), len(artworkMap))
+	}
+
+	if _, cached := lineup.sdCache.Programs["EP012345670001"]; cached {
+		t.Errorf("expected the program missing its artwork not to be cached")
+	}
+	if _, cached := lineup.sdCache.Programs["EP012345670002"]; !cached {
+		t.Errorf("expected the program without artwork to be cached")
+	}
+	if lineup.SchedulesDirectStatus.ConsecutiveFailures != 1 {
+		t.Errorf("expected the artwork failure to be recorded, got %+v", lineup.SchedulesDirectStatus)
+	}
+}
+
+func TestMergeSchedulesDirectCredits(t *testing.T) {
+	existing := &xmltv.Credits{Actors: []xmltv.Actor{{Value: "Jane Doe"}}}
+	cast := []schedulesdirect.Person{
+		{Name: "John Roe", Role: "Actor", CharacterName: "Bob", BillingOrder: "02"},
+		{Name: "Jane Doe", Role: "Actor", CharacterName: "Alice", BillingOrder: "01"},
+		{Name: "John Roe", Role: "Actor", CharacterName: "Bob", BillingOrder: "02"},
+		{Name: "John Roe", Role: "Actor", CharacterName: "Bob's Twin", BillingOrder: "03"},
+		{Name: "Sam Poe", Role: "Guest Star", BillingOrder: "04"},
+	}
+	crew := []schedulesdirect.Person{
+		{Name: "Ann Director", Role: "Director", BillingOrder: "01"},
+		{Name: "Ann Director", Role: "Director", BillingOrder: "01"},
+		{Name: "Carl Grip", Role: "Key Grip", BillingOrder: "02"},
+		{Name: "Sam Poe", Role: "Writer", BillingOrder: "03"},
+	}
+
+	credits := mergeSchedulesDirectCredits(existing, cast, crew)
+
+	tests := []struct {
+		name     string
+		got      interface{}
+		expected string
+	}{
+		{"actors", credits.Actors, "[{Alice Jane Doe} {Bob John Roe} {Bob's Twin John Roe}]"},
+		{"directors", credits.Directors, "[Ann Director]"},
+		{"writers", credits.Writers, "[Sam Poe]"},
+		{"guests", credits.Guests, "[Sam Poe]"},
+	}
+
+	for _, tt := range tests {
+		if got := fmt.Sprint(tt.got); got != tt.expected {
+			t.Errorf("expected %s to be %s, got %s", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestMergeSchedulesDirectLanguages(t *testing.T) {
+	programme := &xmltv.Programme{
+		Titles:       []xmltv.CommonElement{{Value: "Le Journal"}},
+		Descriptions: []xmltv.CommonElement{{Value: "Les informations."}},
+	}
+	sdProgram := schedulesdirect.ProgramInfo{
+		Titles: []schedulesdirect.Title{{Title120: "The News"}, {Title120: "le journal"}},
+		Descriptions: map[string][]schedulesdirect.Description{
+			"description1000": {{Description: "The news of the day.", Language: "en"}},
+		},
+	}
+
+	merged := MergeSchedulesDirectAndXMLTVProgramme(programme, sdProgram, nil, sdMergeOptions{})
+
+	if got := fmt.Sprint(merged.Titles); got != "[{ Le Journal} {en The News}]" {
+		t.Errorf("expected only the Schedules Direct title to be tagged as English, got %s", got)
+	}
+	if got := fmt.Sprint(merged.Descriptions); got != "[{ Les informations.} {en The news of the day.}]" {
+		t.Errorf("expected only the Schedules Direct description to be tagged as English, got %s", got)
+	}
+}
+
+func TestPickDescriptions(t *testing.T) {
+	descriptions := []xmltv.CommonElement{
+		{Value: "The news of the day. "},
+		{Lang: "fr", Value: "Les informations."},
+		{Lang: "en", Value: "The news of the day."},
+		{Lang: "en", Value: "News."},
+		{Lang: "en", Value: "the news of the day."},
+		{Value: "Headlines."},
+		{Lang: "de", Value: ""},
+	}
+
+	tests := []struct {
+		longest  bool
+		expected string
+	}{
+		{true, "[{fr Les informations.} {en The news of the day.} { Headlines.}]"},
+		{false, "[{fr Les informations.} {en News.} { Headlines.}]"},
+	}
+
+	for _, test := range tests {
+		if actual := fmt.Sprint(pickDescriptions(descriptions, test.longest)); actual != test.expected {
+			t.Errorf("longest %t: expected %s, got %s", test.longest, test.expected, actual)
+		}
+	}
+}
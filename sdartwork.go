@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	schedulesdirect "github.com/tellytv/go.schedulesdirect"
+	"github.com/tellytv/telly/internal/xmltv"
+)
+
+// sdImageProxyPath is where the Schedules Direct image proxy is mounted.
+const sdImageProxyPath = "/sd/image/"
+
+// sdDefaultArtworkCount is how many icons a programme gets when the config doesn't say, programmes can have dozens.
+const sdDefaultArtworkCount = 3
+
+// sdArtworkPolicy decides which Schedules Direct artwork ends up as programme icons.
+// Empty preference lists accept everything, otherwise only listed values are used, preferring those listed first.
+type sdArtworkPolicy struct {
+	Categories []string
+	Aspects    []string
+	Sizes      []string
+	// MaxCount limits the number of icons per programme, zero means unlimited.
+	MaxCount int
+	// ProxyBaseURL, if set, makes icons point at telly's image proxy instead of Schedules Direct.
+	ProxyBaseURL string
+}
+
+// newSDArtworkPolicy reads the artwork policy from the schedulesdirect.artwork config section.
+func newSDArtworkPolicy() sdArtworkPolicy {
+	policy := sdArtworkPolicy{
+		Categories: viper.GetStringSlice("schedulesdirect.artwork.categories"),
+		Aspects:    viper.GetStringSlice("schedulesdirect.artwork.aspects"),
+		Sizes:      viper.GetStringSlice("schedulesdirect.artwork.sizes"),
+		MaxCount:   sdDefaultArtworkCount,
+	}
+
+	if viper.IsSet("schedulesdirect.artwork.max-count") {
+		policy.MaxCount = viper.GetInt("schedulesdirect.artwork.max-count")
+	}
+
+	if viper.GetBool("schedulesdirect.artwork.proxy") {
+		policy.ProxyBaseURL = fmt.Sprintf("http://%s%s", viper.GetString("web.base-address"), sdImageProxyPath)
+	}
+
+	return policy
+}
+
+// preferenceRank returns the position of value in prefs, or false if prefs is not empty and doesn't contain value.
+func preferenceRank(prefs []string, value string) (int, bool) {
+	if len(prefs) == 0 {
+		return 0, true
+	}
+	for idx, pref := range prefs {
+		if strings.EqualFold(pref, value) {
+			return idx, true
+		}
+	}
+	return len(prefs), false
+}
+
+// selectArtwork returns the artwork allowed by the policy, best match first and limited to MaxCount.
+func (p sdArtworkPolicy) selectArtwork(artworks []schedulesdirect.ProgramArtwork) []schedulesdirect.ProgramArtwork {
+	type rankedArtwork struct {
+		artwork schedulesdirect.ProgramArtwork
+		ranks   [3]int
+	}
+
+	ranked := make([]rankedArtwork, 0, len(artworks))
+	seen := make(map[string]bool)
+
+	for _, artwork := range artworks {
+		if artwork.URI == "" || seen[artwork.URI] {
+			continue
+		}
+
+		categoryRank, categoryOK := preferenceRank(p.Categories, artwork.Category)
+		aspectRank, aspectOK := preferenceRank(p.Aspects, artwork.Aspect)
+		sizeRank, sizeOK := preferenceRank(p.Sizes, artwork.Size)
+		if !categoryOK || !aspectOK || !sizeOK {
+			continue
+		}
+
+		seen[artwork.URI] = true
+		ranked = append(ranked, rankedArtwork{artwork, [3]int{categoryRank, aspectRank, sizeRank}})
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		for idx := range ranked[i].ranks {
+			if ranked[i].ranks[idx] != ranked[j].ranks[idx] {
+				return ranked[i].ranks[idx] < ranked[j].ranks[idx]
+			}
+		}
+		// Schedules Direct marks the artwork it thinks is most representative as primary.
+		return ranked[i].artwork.Primary == "true" && ranked[j].artwork.Primary != "true"
+	})
+
+	if p.MaxCount > 0 && len(ranked) > p.MaxCount {
+		ranked = ranked[:p.MaxCount]
+	}
+
+	selected := make([]schedulesdirect.ProgramArtwork, 0, len(ranked))
+	for _, entry := range ranked {
+		selected = append(selected, entry.artwork)
+	}
+
+	return selected
+}
+
+// icons returns the XMLTV icons for the artwork selected by the policy.
+func (p sdArtworkPolicy) icons(artworks []schedulesdirect.ProgramArtwork) []xmltv.Icon {
+	icons := make([]xmltv.Icon, 0)
+	for _, artwork := range p.selectArtwork(artworks) {
+		icons = append(icons, xmltv.Icon{
+			Source: p.imageURL(artwork.URI),
+			Width:  artwork.Width,
+			Height: artwork.Height,
+		})
+	}
+	return icons
+}
+
+// imageURL returns the URL clients should use to fetch the image.
+func (p sdArtworkPolicy) imageURL(imageURI string) string {
+	// Images hosted outside of Schedules Direct don't need a token.
+	if p.ProxyBaseURL == "" || strings.HasPrefix(imageURI, "https://") {
+		return getImageURL(imageURI)
+	}
+	return p.ProxyBaseURL + imageURI
+}
+
+// sdImageProxy fetches Schedules Direct images on behalf of clients, attaching the session token.
+func sdImageProxy(live *liveLineup) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lineup := live.load()
+		if lineup.sd == nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		imageURI := strings.TrimPrefix(c.Param("image"), "/")
+		if imageURI == "" || strings.Contains(imageURI, "..") || strings.Contains(imageURI, ":") {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("%s is not a valid Schedules Direct image", imageURI))
+			return
+		}
+
+		resp, fetchErr := fetchSDImage(lineup.sd, getImageURL(imageURI))
+		if fetchErr != nil {
+			log.WithError(fetchErr).Errorf("error fetching Schedules Direct image %s", imageURI)
+			c.AbortWithStatus(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		for _, header := range []string{"Content-Type", "Content-Length", "Cache-Control", "Last-Modified", "ETag"} {
+			if value := resp.Header.Get(header); value != "" {
+				c.Header(header, value)
+			}
+		}
+
+		c.Status(resp.StatusCode)
+		if _, copyErr := io.Copy(c.Writer, resp.Body); copyErr != nil {
+			log.WithError(copyErr).Debugf("error sending Schedules Direct image %s", imageURI)
+		}
+	}
+}
+
+// fetchSDImage requests an image with the session's token, which is renewed if Schedules Direct rejects it.
+func fetchSDImage(session *sdSession, imageURL string) (*http.Response, error) {
+	var resp *http.Response
+	fetchErr := session.do(func(client *schedulesdirect.Client) error {
+		req, reqErr := http.NewRequest(http.MethodGet, imageURL, nil)
+		if reqErr != nil {
+			return reqErr
+		}
+		req.Header.Set("token", client.Token)
+
+		var doErr error
+		if resp, doErr = client.HTTP.Do(req); doErr != nil {
+			return doErr
+		}
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			resp.Body.Close()
+			return errSDTokenRejected
+		}
+		return nil
+	})
+	return resp, fetchErr
+}
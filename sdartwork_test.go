@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+	schedulesdirect "github.com/tellytv/go.schedulesdirect"
+)
+
+// TestFetchSDImageRenewsToken is meant to be run with -race, concurrent image requests with an expired token must
+// log in again only once.
+func TestFetchSDImageRenewsToken(t *testing.T) {
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/%s/token", schedulesdirect.APIVersion):
+			atomic.AddInt32(&logins, 1)
+			fmt.Fprint(w, `{"code": 0, "token": "fresh"}`)
+		case "/image/test.jpg":
+			if r.Header.Get("token") != "fresh" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, "image")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	session := &sdSession{
+		client: &schedulesdirect.Client{
+			BaseURL: server.URL + "/",
+			HTTP:    server.Client(),
+			Token:   "expired",
+		},
+		username: "user",
+		password: "pass",
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, fetchErr := fetchSDImage(session, server.URL+"/image/test.jpg")
+			if fetchErr != nil {
+				t.Error(fetchErr)
+				return
+			}
+			defer resp.Body.Close()
+			if body, _ := ioutil.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "image" {
+				t.Errorf("expected the image, got %d: %s", resp.StatusCode, body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if logins != 1 {
+		t.Errorf("expected to log in once, logged in %d times", logins)
+	}
+}
+
+func TestSelectArtworkMaxCount(t *testing.T) {
+	artworks := []schedulesdirect.ProgramArtwork{
+		{URI: "a.jpg", Category: "Iconic"},
+		{URI: "b.jpg", Category: "Banner-L1"},
+		{URI: "c.jpg", Category: "Iconic", Primary: "true"},
+		{URI: "c.jpg", Category: "Iconic"},
+	}
+
+	tests := []struct {
+		name     string
+		policy   sdArtworkPolicy
+		expected []string
+	}{
+		{"unlimited", sdArtworkPolicy{}, []string{"c.jpg", "a.jpg", "b.jpg"}},
+		{"preferred category first", sdArtworkPolicy{Categories: []string{"banner-l1", "iconic"}}, []string{"b.jpg", "c.jpg", "a.jpg"}},
+		{"only the best match", sdArtworkPolicy{Categories: []string{"Banner-L1", "Iconic"}, MaxCount: 1}, []string{"b.jpg"}},
+		{"the best two", sdArtworkPolicy{MaxCount: 2}, []string{"c.jpg", "a.jpg"}},
+		{"unlisted categories dropped", sdArtworkPolicy{Categories: []string{"Iconic"}}, []string{"c.jpg", "a.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := tt.policy.selectArtwork(artworks)
+			uris := make([]string, 0, len(selected))
+			for _, artwork := range selected {
+				uris = append(uris, artwork.URI)
+			}
+			if fmt.Sprint(uris) != fmt.Sprint(tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, uris)
+			}
+		})
+	}
+}
+
+func TestNewSDArtworkPolicyMaxCount(t *testing.T) {
+	defer viper.Reset()
+
+	tests := []struct {
+		name     string
+		maxCount interface{}
+		expected int
+	}{
+		{"a few by default", nil, sdDefaultArtworkCount},
+		{"configured", 1, 1},
+		{"unlimited", 0, 0},
+	}
+
+	for _, tt := range tests {
+		viper.Reset()
+		if tt.maxCount != nil {
+			viper.Set("schedulesdirect.artwork.max-count", tt.maxCount)
+		}
+		if actual := newSDArtworkPolicy().MaxCount; actual != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	schedulesdirect "github.com/tellytv/go.schedulesdirect"
+)
+
+// sdDefaultCacheExpiry is how long cached program information is used when the config doesn't say.
+const sdDefaultCacheExpiry = 7 * 24 * time.Hour
+
+// sdUnverifiedCacheExpiry is how long cached program information is used when there is no MD5 to tell whether it
+// changed, unless the cache expiry is shorter.
+const sdUnverifiedCacheExpiry = 24 * time.Hour
+
+// sdCachedProgram is the program information and artwork Schedules Direct returned for a single program.
+type sdCachedProgram struct {
+	MD5     string
+	Fetched time.Time
+	Info    schedulesdirect.ProgramInfo
+	Artwork []schedulesdirect.ProgramArtwork `json:",omitempty"`
+}
+
+// sdProgramCache persists Schedules Direct program responses between scans so that only new or changed programs are requested.
+// A nil cache is valid and never has any hits.
+type sdProgramCache struct {
+	path   string
+	expiry time.Duration
+
+	Programs map[string]sdCachedProgram
+
+	hits   int
+	misses int
+}
+
+// loadSDProgramCache reads the cache at path, starting an empty cache if there is none yet.
+func loadSDProgramCache(path string, expiry time.Duration) *sdProgramCache {
+	cache := &sdProgramCache{
+		path:     path,
+		expiry:   expiry,
+		Programs: make(map[string]sdCachedProgram),
+	}
+
+	data, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		if !os.IsNotExist(readErr) {
+			log.WithError(readErr).Warnf("unable to read Schedules Direct cache %s, starting with an empty cache", path)
+		}
+		return cache
+	}
+
+	if unmarshalErr := json.Unmarshal(data, cache); unmarshalErr != nil {
+		log.WithError(unmarshalErr).Warnf("unable to parse Schedules Direct cache %s, starting with an empty cache", path)
+		cache.Programs = make(map[string]sdCachedProgram)
+	}
+
+	return cache
+}
+
+// get returns the cached program if it hasn't expired and, when md5 is given, hasn't changed since. Without an md5
+// the program expires after sdUnverifiedCacheExpiry at most.
+func (c *sdProgramCache) get(programID, md5 string) (sdCachedProgram, bool) {
+	if c == nil {
+		return sdCachedProgram{}, false
+	}
+
+	expiry := c.expiry
+	if md5 == "" && expiry > sdUnverifiedCacheExpiry {
+		expiry = sdUnverifiedCacheExpiry
+	}
+
+	cached, ok := c.Programs[programID]
+	if !ok || time.Since(cached.Fetched) > expiry || (md5 != "" && md5 != cached.MD5) {
+		c.misses++
+		return sdCachedProgram{}, false
+	}
+
+	c.hits++
+	return cached, true
+}
+
+// put stores freshly fetched program information and artwork.
+func (c *sdProgramCache) put(info schedulesdirect.ProgramInfo, artwork []schedulesdirect.ProgramArtwork) {
+	if c == nil {
+		return
+	}
+
+	c.Programs[info.ProgramID] = sdCachedProgram{
+		MD5:     info.MD5,
+		Fetched: time.Now(),
+		Info:    info,
+		Artwork: artwork,
+	}
+}
+
+// stats returns the hits and misses since the last call and resets them.
+func (c *sdProgramCache) stats() (int, int) {
+	if c == nil {
+		return 0, 0
+	}
+
+	hits, misses := c.hits, c.misses
+	c.hits, c.misses = 0, 0
+	return hits, misses
+}
+
+// save drops expired entries and writes the cache to disk.
+func (c *sdProgramCache) save() error {
+	if c == nil {
+		return nil
+	}
+
+	for programID, cached := range c.Programs {
+		if time.Since(cached.Fetched) > c.expiry {
+			delete(c.Programs, programID)
+		}
+	}
+
+	data, marshalErr := json.Marshal(c)
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	if mkdirErr := os.MkdirAll(filepath.Dir(c.path), 0755); mkdirErr != nil {
+		return mkdirErr
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated cache behind.
+	tmpPath := c.path + ".tmp"
+	if writeErr := ioutil.WriteFile(tmpPath, data, 0644); writeErr != nil {
+		return writeErr
+	}
+
+	return os.Rename(tmpPath, c.path)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	schedulesdirect "github.com/tellytv/go.schedulesdirect"
+	"github.com/tellytv/telly/internal/xmltv"
+)
+
+func TestSDProgramCache(t *testing.T) {
+	cache := &sdProgramCache{expiry: time.Hour, Programs: make(map[string]sdCachedProgram)}
+	cache.put(schedulesdirect.ProgramInfo{ProgramID: "EP012345670001", MD5: "abc"}, []schedulesdirect.ProgramArtwork{{URI: "a.jpg"}})
+	cache.Programs["EP012345670002"] = sdCachedProgram{MD5: "def", Fetched: time.Now().Add(-2 * time.Hour)}
+
+	weekCache := &sdProgramCache{expiry: 7 * 24 * time.Hour, Programs: map[string]sdCachedProgram{
+		"EP012345670004": {
+			MD5:     "ghi",
+			Fetched: time.Now().Add(-2 * sdUnverifiedCacheExpiry),
+			Info:    schedulesdirect.ProgramInfo{ProgramID: "EP012345670004"},
+			Artwork: []schedulesdirect.ProgramArtwork{{URI: "a.jpg"}},
+		},
+	}}
+
+	tests := []struct {
+		name      string
+		programID string
+		md5       string
+		week      bool
+		hit       bool
+	}{
+		{"unchanged", "EP012345670001", "abc", false, true},
+		{"md5 unknown", "EP012345670001", "", false, true},
+		{"changed", "EP012345670001", "xyz", false, false},
+		{"expired", "EP012345670002", "def", false, false},
+		{"not cached", "EP012345670003", "", false, false},
+		{"unchanged for longer than a day", "EP012345670004", "ghi", true, true},
+		{"md5 unknown for longer than a day", "EP012345670004", "", true, false},
+	}
+
+	for _, test := range tests {
+		cache := cache
+		if test.week {
+			cache = weekCache
+		}
+		cached, hit := cache.get(test.programID, test.md5)
+		if hit != test.hit {
+			t.Errorf("%s: expected hit %t, got %t", test.name, test.hit, hit)
+		}
+		if hit && (cached.Info.ProgramID != test.programID || len(cached.Artwork) != 1) {
+			t.Errorf("%s: expected the cached program and its artwork, got %+v", test.name, cached)
+		}
+	}
+
+	if hits, misses := cache.stats(); hits != 2 || misses != 3 {
+		t.Errorf("expected 2 hits and 3 misses, got %d and %d", hits, misses)
+	}
+	if hits, misses := cache.stats(); hits != 0 || misses != 0 {
+		t.Errorf("expected stats to reset, got %d hits and %d misses", hits, misses)
+	}
+
+	var nilCache *sdProgramCache
+	nilCache.put(schedulesdirect.ProgramInfo{ProgramID: "EP012345670001"}, nil)
+	if _, hit := nilCache.get("EP012345670001", ""); hit {
+		t.Errorf("expected a nil cache to never hit")
+	}
+	if saveErr := nilCache.save(); saveErr != nil {
+		t.Errorf("expected saving a nil cache to do nothing, got %s", saveErr)
+	}
+}
+
+func TestSDProgramCacheSave(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "telly-sdcache")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "telly", "schedulesdirect-programs.json")
+
+	cache := loadSDProgramCache(path, time.Hour)
+	cache.put(schedulesdirect.ProgramInfo{ProgramID: "EP012345670001", MD5: "abc"}, nil)
+	cache.Programs["EP012345670002"] = sdCachedProgram{MD5: "def", Fetched: time.Now().Add(-2 * time.Hour)}
+
+	if saveErr := cache.save(); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+
+	loaded := loadSDProgramCache(path, time.Hour)
+	if len(loaded.Programs) != 1 {
+		t.Errorf("expected the expired program to be dropped, got %d programs", len(loaded.Programs))
+	}
+	if _, hit := loaded.get("EP012345670001", "abc"); !hit {
+		t.Errorf("expected the saved program to be loaded")
+	}
+
+	if writeErr := ioutil.WriteFile(path, []byte("{"), 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	if broken := loadSDProgramCache(path, time.Hour); broken.Programs == nil || len(broken.Programs) != 0 {
+		t.Errorf("expected a broken cache to start empty, got %v", broken.Programs)
+	}
+}
+
+func TestGetSchedulesDirectProgramsUsesCache(t *testing.T) {
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + schedulesdirect.APIVersion + "/programs":
+			var programIDs []string
+			if decodeErr := json.NewDecoder(r.Body).Decode(&programIDs); decodeErr != nil {
+				t.Error(decodeErr)
+			}
+			requested = append(requested, programIDs...)
+
+			programs := make([]schedulesdirect.ProgramInfo, 0, len(programIDs))
+			for _, programID := range programIDs {
+				programs = append(programs, schedulesdirect.ProgramInfo{ProgramID: programID, MD5: "new"})
+			}
+			json.NewEncoder(w).Encode(programs)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	defer server.Close()
+
+	dir, dirErr := ioutil.TempDir("", "telly-sdcache")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := loadSDProgramCache(filepath.Join(dir, "schedulesdirect-programs.json"), time.Hour)
+	cache.put(schedulesdirect.ProgramInfo{ProgramID: "EP012345670001", MD5: "old"}, nil)
+	cache.put(schedulesdirect.ProgramInfo{ProgramID: "EP012345670002", MD5: "old"}, nil)
+
+	lineup := &lineup{
+		sd:      &sdSession{client: &schedulesdirect.Client{BaseURL: server.URL + "/", HTTP: server.Client(), Token: "token"}},
+		sdCache: cache,
+	}
+
+	programs, _, programsErr := lineup.getSchedulesDirectPrograms(
+		[]string{"EP012345670001", "EP012345670002", "EP012345670003"},
+		map[string]string{"EP012345670001": "old", "EP012345670002": "new"},
+	)
+	if programsErr != nil {
+		t.Fatal(programsErr)
+	}
+
+	if len(programs) != 3 {
+		t.Errorf("expected information for all 3 programs, got %d", len(programs))
+	}
+	if len(requested) != 2 || requested[0] != "EP012345670002" || requested[1] != "EP012345670003" {
+		t.Errorf("expected only the changed and the uncached program to be requested, requested %v", requested)
+	}
+	if cached, hit := cache.get("EP012345670002", "new"); !hit || cached.MD5 != "new" {
+		t.Errorf("expected the changed program to be cached again")
+	}
+}
+
+func TestGetSchedulesDirectMD5s(t *testing.T) {
+	server, _ := testSDServer(t)
+	defer server.Close()
+
+	lineup := &lineup{sd: &sdSession{client: &schedulesdirect.Client{BaseURL: server.URL + "/", HTTP: server.Client(), Token: "token"}}}
+
+	md5s, md5sErr := lineup.getSchedulesDirectMD5s([]string{"10436"}, []string{"2018-08-01"})
+	if md5sErr != nil {
+		t.Fatal(md5sErr)
+	}
+	if len(md5s) != 2 || md5s["EP012345670001"] != "abc" || md5s["SH000000000000"] != "def" {
+		t.Errorf("expected the MD5 of every scheduled program, got %v", md5s)
+	}
+
+	server.Close()
+	if md5s, md5sErr := lineup.getSchedulesDirectMD5s(nil, []string{"2018-08-01"}); md5sErr != nil || len(md5s) != 0 {
+		t.Errorf("expected nothing to be requested without stations, got %v and %v", md5s, md5sErr)
+	}
+}
+
+func TestSDScheduleDates(t *testing.T) {
+	now := time.Date(2018, time.August, 1, 20, 0, 0, 0, time.UTC)
+	airing := func(start time.Time) xmltv.Programme {
+		return xmltv.Programme{Start: &xmltv.Time{Time: start}}
+	}
+
+	programmes := map[string][]xmltv.Programme{
+		"EP012345670001": {airing(now.AddDate(0, 0, 1)), airing(now.AddDate(0, 0, -1))},
+		// 21:00 in Chicago is already the next day in UTC.
+		"EP012345670002": {airing(time.Date(2018, time.August, 1, 21, 0, 0, 0, time.FixedZone("CDT", -5*60*60))), airing(now), {}},
+	}
+
+	if dates := sdScheduleDates(programmes, now); fmt.Sprint(dates) != "[2018-08-01 2018-08-02]" {
+		t.Errorf("expected today and tomorrow, got %v", dates)
+	}
+}
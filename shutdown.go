@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// How long stopped streams get to kill ffmpeg and release their tuner once the drain period is over.
+const streamStopTimeout = 5 * time.Second
+
+// runServer serves until SIGINT or SIGTERM, then shuts down gracefully. SIGHUP reloads the configuration and rescans.
+func runServer(server *http.Server, live *liveLineup, advertiser *ssdpAdvertiser, discoveryConn net.PacketConn) {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case err := <-serverErr:
+			if advertiser != nil {
+				advertiser.Close()
+			}
+			log.WithError(err).Panicln("Error starting up web server")
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				log.Infoln("Reloading the configuration and rescanning the lineup")
+				if reloadErr := live.reload(); reloadErr != nil {
+					log.WithError(reloadErr).Errorln("error reloading, keeping the current configuration and lineup")
+				}
+				continue
+			}
+
+			log.Infof("Received %s, telly is going off the air", sig)
+			shutdown(server, live.load().tuners, advertiser, discoveryConn)
+			return
+		}
+	}
+}
+
+// shutdown says goodbye over SSDP, stops answering HDHomeRun discovery and accepting requests, then gives running streams web.drain-period to
+// finish before they are cut and their ffmpeg processes killed.
+func shutdown(server *http.Server, tuners *tunerPool, advertiser *ssdpAdvertiser, discoveryConn net.PacketConn) {
+	if advertiser != nil {
+		advertiser.Close()
+	}
+	if discoveryConn != nil {
+		if closeErr := discoveryConn.Close(); closeErr != nil {
+			log.WithError(closeErr).Errorln("error closing HDHomeRun discovery connection")
+		}
+	}
+
+	drainPeriod := viper.GetDuration("web.drain-period")
+	log.Debugf("Waiting up to %s for streams to finish", drainPeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainPeriod)
+	defer cancel()
+
+	if shutdownErr := server.Shutdown(ctx); shutdownErr != nil {
+		log.WithError(shutdownErr).Warnln("streams are still running after the drain period, stopping them")
+		tuners.stop()
+		if closeErr := server.Close(); closeErr != nil {
+			log.WithError(closeErr).Errorln("error closing web server")
+		}
+	}
+
+	if !tuners.wait(streamStopTimeout) {
+		log.Warnln("some streams didn't stop in time, their ffmpeg processes may be left running")
+	}
+}
+
+// reload re-reads the configuration file and swaps in a lineup scanned from it. The file is checked before it
+// replaces the running configuration, and if it is invalid or the scan fails the current lineup is kept. The web
+// server, discovery and the tuners, along with the streams they are serving, are only set up at start.
+func (live *liveLineup) reload() error {
+	return live.swap(func() (fresh *lineup, reloadErr error) {
+		if configFile := viper.ConfigFileUsed(); configFile != "" {
+			contents, readErr := ioutil.ReadFile(configFile)
+			if readErr != nil {
+				return nil, readErr
+			}
+
+			config := viper.New()
+			config.SetConfigFile(configFile)
+			if parseErr := config.ReadConfig(bytes.NewReader(contents)); parseErr != nil {
+				return nil, parseErr
+			}
+			if configErr := checkLineupConfig(config); configErr != nil {
+				return nil, fmt.Errorf("invalid configuration: %s", configErr)
+			}
+
+			if applyErr := viper.ReadConfig(bytes.NewReader(contents)); applyErr != nil {
+				return nil, applyErr
+			}
+		}
+
+		// Anything else newLineup can't set up, like logging into Schedules Direct, makes it panic, which mustn't take
+		// the running lineup down with it.
+		defer func() {
+			if r := recover(); r != nil {
+				fresh, reloadErr = nil, fmt.Errorf("unable to set up the lineup: %v", r)
+			}
+		}()
+
+		fresh = newLineup()
+		fresh.tuners = live.load().tuners
+		// The same Schedules Direct account keeps its session, along with the failures it is backed off for and the
+		// guides it last built.
+		if current := live.load().sd; current != nil && fresh.sd != nil && current.username == fresh.sd.username && current.password == fresh.sd.password {
+			fresh.sd = current
+		}
+		if scanErr := fresh.Scan(); scanErr != nil {
+			return nil, scanErr
+		}
+
+		if level, parseLevelErr := logrus.ParseLevel(viper.GetString("log.level")); parseLevelErr == nil {
+			log.SetLevel(level)
+		}
+		return fresh, nil
+	})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/tellytv/telly/internal/providers"
+)
+
+// TestReloadWhileServing is meant to be run with -race, it reloads and rescans while the lineup is being served.
+func TestReloadWhileServing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir, dirErr := ioutil.TempDir("", "telly")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	configPath := filepath.Join(dir, "telly.config.toml")
+	if writeErr := ioutil.WriteFile(configPath, []byte("[IPTV]\n  State-File = \"\"\n"), 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	viper.SetConfigFile(configPath)
+	defer viper.Reset()
+
+	provider := testProvider(t, providers.Configuration{Name: "test"})
+	initial := testLineup(provider)
+	initial.channels = initial.assignChannelNumbers([]pendingChannel{
+		{provider: provider, channel: testChannel("BBC One", "bbc1", "1")},
+		{provider: provider, channel: testChannel("BBC Two", "bbc2", "2")},
+	})
+	live := newLiveLineup(initial)
+
+	router := gin.New()
+	router.GET("/lineup.json", serveLineup(live, false))
+	router.GET("/status.json", serveTunerStatus(live))
+	browse := contentDirectory(live)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			if reloadErr := live.reload(); reloadErr != nil {
+				t.Error(reloadErr)
+			}
+			if rescanErr := live.rescan(); rescanErr != nil {
+				t.Error(rescanErr)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/lineup.json", nil))
+			var channels []hdHomeRunLineupItem
+			if recorder.Code != http.StatusOK || json.Unmarshal(recorder.Body.Bytes(), &channels) != nil {
+				t.Errorf("expected a lineup, got %d: %s", recorder.Code, recorder.Body)
+			}
+			if _, browseErr := browse("Browse", map[string]string{"ObjectID": cdRootID, "BrowseFlag": "BrowseDirectChildren"}); browseErr != nil {
+				t.Errorf("expected the root to be browsable, got %s", browseErr.Description)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if live.scanning() {
+		t.Errorf("expected no scan to be running")
+	}
+	if len(live.load().channels) != 0 {
+		t.Errorf("expected the reloaded lineup, which has no sources, to have no channels")
+	}
+	if live.load().tuners != initial.tuners {
+		t.Errorf("expected the tuners to be kept across reloads")
+	}
+}
+
+func TestTunerPoolStop(t *testing.T) {
+	pool := newTunerPool(2, time.Hour)
+	channel := hdHomeRunLineupItem{GuideNumber: "5"}
+
+	streaming, streamErr := pool.acquire(context.Background(), autoTuner, channel, "10.0.0.1")
+	if streamErr != nil {
+		t.Fatal(streamErr)
+	}
+	if _, holdErr := pool.hold(autoTuner, channel, "10.0.0.2"); holdErr != nil {
+		t.Fatal(holdErr)
+	}
+
+	// A stream ends once its context is done, like the ffmpeg stream does.
+	go func() {
+		<-streaming.ctx.Done()
+		streaming.release()
+	}()
+
+	if pool.wait(10 * time.Millisecond) {
+		t.Fatalf("expected the streams to still be running")
+	}
+
+	pool.stop()
+	if !pool.wait(time.Second) {
+		t.Fatalf("expected stopping to end every stream")
+	}
+	if _, acquireErr := pool.acquire(context.Background(), autoTuner, channel, "10.0.0.3"); acquireErr != errAllTunersInUse {
+		t.Errorf("expected a stopped pool to refuse streams, got %v", acquireErr)
+	}
+}
+
+func TestShutdownStopsStreams(t *testing.T) {
+	viper.Set("web.drain-period", 50*time.Millisecond)
+	defer viper.Reset()
+
+	pool := newTunerPool(1, time.Hour)
+	streaming := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, acquireErr := pool.acquire(r.Context(), autoTuner, hdHomeRunLineupItem{GuideNumber: "5"}, targetIP(r.RemoteAddr))
+		if acquireErr != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		defer session.release()
+		close(streaming)
+		<-session.ctx.Done()
+	})}
+
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatal(listenErr)
+	}
+	go server.Serve(listener)
+
+	go func() {
+		if resp, getErr := http.Get("http://" + listener.Addr().String()); getErr == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-streaming
+
+	discoveryConn, discoveryErr := net.ListenPacket("udp4", "127.0.0.1:0")
+	if discoveryErr != nil {
+		t.Fatal(discoveryErr)
+	}
+
+	started := time.Now()
+	shutdown(server, pool, nil, discoveryConn)
+
+	if elapsed := time.Since(started); elapsed > streamStopTimeout {
+		t.Errorf("expected the stream to be stopped after the drain period, shutting down took %s", elapsed)
+	}
+	if status := pool.status(); status[0].VctNumber != "" {
+		t.Errorf("expected every tuner to be released, got %+v", status)
+	}
+	if _, _, readErr := discoveryConn.ReadFrom(make([]byte, 1)); readErr == nil {
+		t.Errorf("expected the HDHomeRun discovery connection to be closed")
+	}
+}
+
+func TestReloadChecksConfigurationFirst(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "telly")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "telly.config.toml")
+	if writeErr := ioutil.WriteFile(configPath, []byte("[IPTV]\n  State-File = \"\"\n  Starting-Channel = 500\n"), 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	viper.SetConfigFile(configPath)
+	defer viper.Reset()
+	if readErr := viper.ReadInConfig(); readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	initial := testLineup()
+	live := newLiveLineup(initial)
+	level := log.GetLevel()
+	defer log.SetLevel(level)
+
+	invalid := []string{
+		"[IPTV]\n  Starting-Channel = 600\n  Channel-Collision = \"bump\"\n",
+		"[Log]\n  Level = \"loud\"\n[IPTV]\n  Starting-Channel = 600\n",
+		"[EPG]\n  Timezone = \"Mars/Olympus_Mons\"\n",
+		"[[Source]]\n  Name = \"broken\"\n  [[Source]\n",
+		"[[Source]]\n  Name = \"broken\"\n  [[Source.FilterRules]]\n    Include = \"name ==\"\n",
+	}
+
+	for _, config := range invalid {
+		if writeErr := ioutil.WriteFile(configPath, []byte(config), 0644); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		if reloadErr := live.reload(); reloadErr == nil {
+			t.Errorf("%q: expected the reload to fail", config)
+		}
+		if live.load() != initial {
+			t.Errorf("%q: expected the running lineup to be kept", config)
+		}
+		if viper.GetInt("iptv.starting-channel") != 500 || log.GetLevel() != level {
+			t.Errorf("%q: expected the running configuration to be kept", config)
+		}
+	}
+
+	if writeErr := ioutil.WriteFile(configPath, []byte("[IPTV]\n  State-File = \"\"\n  Starting-Channel = 600\n"), 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	if reloadErr := live.reload(); reloadErr != nil {
+		t.Fatal(reloadErr)
+	}
+	if live.load().startingChannelNumber != 600 || viper.GetInt("iptv.starting-channel") != 600 {
+		t.Errorf("expected the valid configuration to be applied")
+	}
+}
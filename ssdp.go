@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	ssdp "github.com/koron/go-ssdp"
+	"github.com/prometheus/common/version"
+	"github.com/spf13/viper"
+)
+
+const (
+	ssdpMaxAge = 1800
+	// Alive messages are repeated well within the max age so clients never forget telly.
+	ssdpAliveInterval = 15 * time.Second
+)
+
+// ssdpNotification is one of the types a device is advertised as, with its unique service name.
+type ssdpNotification struct {
+	nt  string
+	usn string
+}
+
+// ssdpNotifications returns everything a media server with a ConnectionManager and ContentDirectory is found by.
+func ssdpNotifications(deviceUUID string) []ssdpNotification {
+	udn := fmt.Sprintf("uuid:%s", deviceUUID)
+	notifications := []ssdpNotification{
+		{nt: "upnp:rootdevice", usn: fmt.Sprintf("%s::upnp:rootdevice", udn)},
+		{nt: udn, usn: udn},
+	}
+	for _, nt := range []string{upnpMediaServerType, upnpConnectionManagerType, upnpContentDirectoryType} {
+		notifications = append(notifications, ssdpNotification{nt: nt, usn: fmt.Sprintf("%s::%s", udn, nt)})
+	}
+	return notifications
+}
+
+// ssdpAdvertiser advertises devices over SSDP and answers M-SEARCH requests for them until it is closed.
+type ssdpAdvertiser struct {
+	notifications []ssdpNotification
+	advertisers   []*ssdp.Advertiser
+	quit          chan struct{}
+	closeOnce     sync.Once
+}
+
+// setupSSDP advertises the devices on the interfaces given by discovery.ssdp-interfaces, or all of them.
+func setupSSDP(devices ...DiscoveryData) (*ssdpAdvertiser, error) {
+	if names := viper.GetStringSlice("discovery.ssdp-interfaces"); len(names) > 0 {
+		interfaces := make([]net.Interface, 0, len(names))
+		for _, name := range names {
+			ifi, ifiErr := net.InterfaceByName(strings.TrimSpace(name))
+			if ifiErr != nil {
+				return nil, fmt.Errorf("unknown ssdp interface %s: %s", name, ifiErr)
+			}
+			interfaces = append(interfaces, *ifi)
+		}
+		ssdp.Interfaces = interfaces
+	}
+
+	server := fmt.Sprintf("%s/%s UPnP/1.0 %s/%s", runtime.GOOS, runtime.Version(), namespace, version.Version)
+
+	a := &ssdpAdvertiser{quit: make(chan struct{})}
+
+	for _, device := range devices {
+		log.Debugf("Advertising telly as %s (%s)", device.FriendlyName, device.DeviceUUID)
+		location := fmt.Sprintf("%s/device.xml", device.BaseURL)
+
+		for _, notification := range ssdpNotifications(device.DeviceUUID) {
+			adv, advErr := ssdp.Advertise(notification.nt, notification.usn, location, server, ssdpMaxAge)
+			if advErr != nil {
+				a.Close()
+				return nil, advErr
+			}
+			a.notifications = append(a.notifications, notification)
+			a.advertisers = append(a.advertisers, adv)
+		}
+	}
+
+	go a.heartbeat()
+
+	return a, nil
+}
+
+func (a *ssdpAdvertiser) heartbeat() {
+	ticker := time.NewTicker(ssdpAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, adv := range a.advertisers {
+				if aliveErr := adv.Alive(); aliveErr != nil {
+					log.WithError(aliveErr).Errorln("error when sending ssdp heartbeat")
+				}
+			}
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+// Close says byebye for every advertised type and stops answering searches.
+func (a *ssdpAdvertiser) Close() {
+	a.closeOnce.Do(func() {
+		close(a.quit)
+		// The advertisers send asynchronously and may be closed before a queued byebye goes out, so send them directly.
+		for _, notification := range a.notifications {
+			if byeErr := ssdp.AnnounceBye(notification.nt, notification.usn, ""); byeErr != nil {
+				log.WithError(byeErr).Warnln("error when sending ssdp byebye")
+			}
+		}
+		for _, adv := range a.advertisers {
+			adv.Close()
+		}
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+
+	"github.com/spf13/viper"
 )
 
 // DiscoveryData contains data about telly to expose in the HDHomeRun format for Plex detection.
@@ -18,6 +20,9 @@ type DiscoveryData struct {
 	DeviceAuth      string
 	BaseURL         string
 	LineupURL       string
+
+	// DeviceUUID identifies the device over UPnP and SSDP, it isn't part of the HDHomeRun discovery data.
+	DeviceUUID string `json:"-"`
 }
 
 // UPNP returns the UPNP representation of the DiscoveryData.
@@ -28,12 +33,19 @@ func (d *DiscoveryData) UPNP() UPNP {
 		},
 		URLBase: d.BaseURL,
 		Device: upnpDevice{
-			DeviceType:   "urn:schemas-upnp-org:device:MediaServer:1",
-			FriendlyName: d.FriendlyName,
-			Manufacturer: d.Manufacturer,
-			ModelName:    d.ModelNumber,
-			ModelNumber:  d.ModelNumber,
-			UDN:          fmt.Sprintf("uuid:%s", d.DeviceID),
+			DeviceType:       upnpMediaServerType,
+			FriendlyName:     d.FriendlyName,
+			Manufacturer:     d.Manufacturer,
+			ManufacturerURL:  "https://github.com/tellytv/telly",
+			ModelDescription: fmt.Sprintf("%s %s", d.FirmwareName, d.FirmwareVersion),
+			ModelName:        d.ModelNumber,
+			ModelNumber:      d.ModelNumber,
+			ModelURL:         "https://github.com/tellytv/telly",
+			SerialNumber:     d.DeviceID,
+			UDN:              fmt.Sprintf("uuid:%s", d.DeviceUUID),
+			PresentationURL:  fmt.Sprintf("http://%s/manage/", viper.GetString("web.base-address")),
+			Icons:            upnpIcons(),
+			Services:         upnpServices(),
 		},
 	}
 }
@@ -54,13 +66,35 @@ type upnpVersion struct {
 }
 
 type upnpDevice struct {
-	DeviceType   string `xml:"deviceType"`
-	FriendlyName string `xml:"friendlyName"`
-	Manufacturer string `xml:"manufacturer"`
-	ModelName    string `xml:"modelName"`
-	ModelNumber  string `xml:"modelNumber"`
-	SerialNumber string `xml:"serialNumber"`
-	UDN          string `xml:"UDN"`
+	DeviceType       string        `xml:"deviceType"`
+	FriendlyName     string        `xml:"friendlyName"`
+	Manufacturer     string        `xml:"manufacturer"`
+	ManufacturerURL  string        `xml:"manufacturerURL,omitempty"`
+	ModelDescription string        `xml:"modelDescription,omitempty"`
+	ModelName        string        `xml:"modelName"`
+	ModelNumber      string        `xml:"modelNumber"`
+	ModelURL         string        `xml:"modelURL,omitempty"`
+	SerialNumber     string        `xml:"serialNumber"`
+	UDN              string        `xml:"UDN"`
+	Icons            []upnpIcon    `xml:"iconList>icon"`
+	Services         []upnpService `xml:"serviceList>service"`
+	PresentationURL  string        `xml:"presentationURL,omitempty"`
+}
+
+type upnpIcon struct {
+	Mimetype string `xml:"mimetype"`
+	Width    int    `xml:"width"`
+	Height   int    `xml:"height"`
+	Depth    int    `xml:"depth"`
+	URL      string `xml:"url"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceID   string `xml:"serviceId"`
+	SCPDURL     string `xml:"SCPDURL"`
+	ControlURL  string `xml:"controlURL"`
+	EventSubURL string `xml:"eventSubURL"`
 }
 
 // UPNP describes the UPNP/SSDP XML.
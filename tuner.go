@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Errors returned when a stream asks for a tuner that isn't free, with the codes a real HDHomeRun sends in the
+// X-HDHomeRun-Error header.
+var (
+	errTunerInUse     = errors.New("804 Tuner In Use")
+	errAllTunersInUse = errors.New("805 All Tuners In Use")
+)
+
+// autoTuner asks for whichever tuner is free.
+const autoTuner = -1
+
+// tunerPool tracks what each of the virtual tuners, as many as iptv.streams, is streaming.
+type tunerPool struct {
+	mu     sync.Mutex
+	tuners []*tunerSession
+	// How long a redirected stream occupies its tuner, since telly can't see when the client stops watching.
+	redirectHold time.Duration
+	// Once stopped, no tuner can be acquired.
+	stopped bool
+	active  sync.WaitGroup
+}
+
+// tunerSession is a stream occupying a tuner.
+type tunerSession struct {
+	pool    *tunerPool
+	index   int
+	channel hdHomeRunLineupItem
+	target  string
+	started time.Time
+	bytes   int64
+	// ctx is done when the stream must stop, because its request ended or the pool was stopped.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// tunerStatus is an entry of status.json, fields other than Resource are only set while the tuner is streaming.
+type tunerStatus struct {
+	Resource              string
+	VctNumber             string `json:",omitempty"`
+	VctName               string `json:",omitempty"`
+	SignalStrengthPercent int    `json:",omitempty"`
+	SignalQualityPercent  int    `json:",omitempty"`
+	SymbolQualityPercent  int    `json:",omitempty"`
+	NetworkRate           int64  `json:",omitempty"`
+	TargetIP              string `json:",omitempty"`
+}
+
+func newTunerPool(count int, redirectHold time.Duration) *tunerPool {
+	if count < 1 {
+		count = 1
+	}
+	return &tunerPool{tuners: make([]*tunerSession, count), redirectHold: redirectHold}
+}
+
+// count returns the number of tuners.
+func (p *tunerPool) count() int {
+	return len(p.tuners)
+}
+
+// acquire occupies the tuner with the index, or the first free one for autoTuner, until the session is released.
+func (p *tunerPool) acquire(ctx context.Context, index int, channel hdHomeRunLineupItem, target string) (*tunerSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return nil, errAllTunersInUse
+	}
+
+	if index == autoTuner {
+		for idx, session := range p.tuners {
+			if session == nil {
+				index = idx
+				break
+			}
+		}
+		if index == autoTuner {
+			return nil, errAllTunersInUse
+		}
+	} else if p.tuners[index] != nil {
+		return nil, errTunerInUse
+	}
+
+	session := &tunerSession{
+		pool:    p,
+		index:   index,
+		channel: channel,
+		target:  target,
+		started: time.Now(),
+	}
+	session.ctx, session.cancel = context.WithCancel(ctx)
+	p.tuners[index] = session
+	p.active.Add(1)
+	return session, nil
+}
+
+// hold occupies the tuner for a redirected stream, which is released once the redirect hold is over.
+func (p *tunerPool) hold(index int, channel hdHomeRunLineupItem, target string) (*tunerSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.redirectHold)
+	session, acquireErr := p.acquire(ctx, index, channel, target)
+	if acquireErr != nil {
+		cancel()
+		return nil, acquireErr
+	}
+
+	go func() {
+		<-session.ctx.Done()
+		cancel()
+		session.release()
+	}()
+
+	return session, nil
+}
+
+// release frees the tuner.
+func (s *tunerSession) release() {
+	s.pool.mu.Lock()
+	defer s.pool.mu.Unlock()
+	if s.pool.tuners[s.index] == s {
+		s.pool.tuners[s.index] = nil
+		s.cancel()
+		s.pool.active.Done()
+	}
+}
+
+// stop ends every stream and refuses new ones.
+func (p *tunerPool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopped = true
+	for _, session := range p.tuners {
+		if session != nil {
+			session.cancel()
+		}
+	}
+}
+
+// wait waits up to the timeout for every tuner to be released, and reports whether they were.
+func (p *tunerPool) wait(timeout time.Duration) bool {
+	released := make(chan struct{})
+	go func() {
+		p.active.Wait()
+		close(released)
+	}()
+
+	select {
+	case <-released:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Write counts the bytes streamed, for the network rate in status.json.
+func (s *tunerSession) Write(p []byte) (int, error) {
+	atomic.AddInt64(&s.bytes, int64(len(p)))
+	return len(p), nil
+}
+
+// status describes every tuner.
+func (p *tunerPool) status() []tunerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make([]tunerStatus, 0, len(p.tuners))
+	for idx, session := range p.tuners {
+		status := tunerStatus{Resource: fmt.Sprintf("tuner%d", idx)}
+		if session != nil {
+			status.VctNumber = session.channel.GuideNumber
+			status.VctName = session.channel.GuideName
+			// IPTV has no signal to measure, so a tuner that streams has a perfect one.
+			status.SignalStrengthPercent = 100
+			status.SignalQualityPercent = 100
+			status.SymbolQualityPercent = 100
+			if elapsed := time.Since(session.started).Seconds(); elapsed > 0 {
+				status.NetworkRate = int64(float64(atomic.LoadInt64(&session.bytes)*8) / elapsed)
+			}
+			status.TargetIP = session.target
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// targetIP returns the IP address of a request's remote address.
+func targetIP(remoteAddr string) string {
+	if host, _, splitErr := net.SplitHostPort(remoteAddr); splitErr == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// transcodeProfile is one of the HDHomeRun EXTEND transcode profiles, asked for with the transcode parameter.
+type transcodeProfile struct {
+	// height is the maximum height of the picture, zero keeps the source's.
+	height  int
+	bitrate string
+}
+
+const transcodeNone = "none"
+
+var transcodeProfiles = map[string]transcodeProfile{
+	"heavy":       {height: 0, bitrate: "8000k"},
+	"mobile":      {height: 720, bitrate: "2000k"},
+	"internet720": {height: 720, bitrate: "1500k"},
+	"internet540": {height: 540, bitrate: "1000k"},
+	"internet480": {height: 480, bitrate: "800k"},
+	"internet360": {height: 360, bitrate: "500k"},
+	"internet240": {height: 240, bitrate: "300k"},
+}
+
+// streamOptions are the duration and transcode parameters of a stream URL.
+type streamOptions struct {
+	duration  time.Duration
+	transcode string
+}
+
+// parseStreamOptions parses the duration, in seconds, and transcode profile of a stream request.
+func parseStreamOptions(duration, transcode string) (streamOptions, error) {
+	options := streamOptions{transcode: strings.ToLower(strings.TrimSpace(transcode))}
+
+	if options.transcode == "" {
+		options.transcode = transcodeNone
+	}
+	if _, ok := transcodeProfiles[options.transcode]; !ok && options.transcode != transcodeNone {
+		profiles := make([]string, 0, len(transcodeProfiles))
+		for name := range transcodeProfiles {
+			profiles = append(profiles, name)
+		}
+		sort.Strings(profiles)
+		return options, fmt.Errorf("unknown transcode profile %s, must be none or one of %s", transcode, strings.Join(profiles, ", "))
+	}
+
+	if duration = strings.TrimSpace(duration); duration != "" {
+		seconds, parseErr := strconv.Atoi(duration)
+		if parseErr != nil || seconds < 0 {
+			return options, fmt.Errorf("duration %s must be a number of seconds", duration)
+		}
+		options.duration = time.Duration(seconds) * time.Second
+	}
+
+	return options, nil
+}
+
+// isSet reports whether the request asked for anything that only ffmpeg can do.
+func (o streamOptions) isSet() bool {
+	return o.duration > 0 || o.transcode != transcodeNone
+}
+
+// ffmpegArgs returns the arguments to remux, or transcode, the input into an MPEG-TS on stdout.
+func (o streamOptions) ffmpegArgs(input string) []string {
+	args := []string{"-i", input}
+
+	if o.duration > 0 {
+		args = append(args, "-t", strconv.Itoa(int(o.duration.Seconds())))
+	}
+
+	if profile, ok := transcodeProfiles[o.transcode]; ok {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-b:v", profile.bitrate, "-maxrate", profile.bitrate)
+		if profile.height > 0 {
+			args = append(args, "-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", profile.height))
+		}
+		args = append(args, "-c:a", "aac", "-b:a", "128k")
+	} else {
+		args = append(args, "-c:v", "copy")
+	}
+
+	return append(args, "-f", "mpegts", "pipe:1")
+}
+
+// copyStream copies the stream to the client, counting what the tuner sends.
+func (s *tunerSession) copyStream(w io.Writer, r io.Reader) (int64, error) {
+	return io.Copy(io.MultiWriter(w, s), r)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTunerPoolAcquire(t *testing.T) {
+	pool := newTunerPool(2, time.Minute)
+	channel := hdHomeRunLineupItem{GuideNumber: "5", GuideName: "BBC One"}
+
+	first, firstErr := pool.acquire(context.Background(), 1, channel, "10.0.0.1")
+	if firstErr != nil || first.index != 1 {
+		t.Fatalf("expected tuner1, got %v", firstErr)
+	}
+
+	if _, inUseErr := pool.acquire(context.Background(), 1, channel, "10.0.0.2"); inUseErr != errTunerInUse {
+		t.Errorf("expected %s, got %v", errTunerInUse, inUseErr)
+	}
+
+	auto, autoErr := pool.acquire(context.Background(), autoTuner, channel, "10.0.0.2")
+	if autoErr != nil || auto.index != 0 {
+		t.Fatalf("expected the free tuner0, got %v", autoErr)
+	}
+
+	if _, allInUseErr := pool.acquire(context.Background(), autoTuner, channel, "10.0.0.3"); allInUseErr != errAllTunersInUse {
+		t.Errorf("expected %s, got %v", errAllTunersInUse, allInUseErr)
+	}
+
+	statuses := pool.status()
+	if len(statuses) != 2 || statuses[1].VctNumber != "5" || statuses[1].TargetIP != "10.0.0.1" || statuses[0].TargetIP != "10.0.0.2" {
+		t.Errorf("expected both tuners to be streaming, got %+v", statuses)
+	}
+
+	first.release()
+	first.release()
+	if first.ctx.Err() == nil {
+		t.Errorf("expected a released session to be done")
+	}
+
+	again, againErr := pool.acquire(context.Background(), autoTuner, channel, "10.0.0.3")
+	if againErr != nil || again.index != 1 {
+		t.Fatalf("expected the released tuner1, got %v", againErr)
+	}
+
+	// Releasing an old session must not free the tuner for the one that took it over.
+	first.release()
+	if _, inUseErr := pool.acquire(context.Background(), 1, channel, "10.0.0.4"); inUseErr != errTunerInUse {
+		t.Errorf("expected tuner1 to still be in use, got %v", inUseErr)
+	}
+
+	if statuses := pool.status(); statuses[1].TargetIP != "10.0.0.3" {
+		t.Errorf("expected tuner1 to stream to the new client, got %+v", statuses[1])
+	}
+}
+
+func TestTunerPoolHold(t *testing.T) {
+	pool := newTunerPool(1, 20*time.Millisecond)
+	channel := hdHomeRunLineupItem{GuideNumber: "5"}
+
+	if _, holdErr := pool.hold(autoTuner, channel, "10.0.0.1"); holdErr != nil {
+		t.Fatal(holdErr)
+	}
+	if _, inUseErr := pool.hold(autoTuner, channel, "10.0.0.2"); inUseErr != errAllTunersInUse {
+		t.Errorf("expected the tuner to be held, got %v", inUseErr)
+	}
+
+	if !pool.wait(time.Second) {
+		t.Fatalf("expected the tuner to be released once the hold is over")
+	}
+	if _, holdErr := pool.hold(0, channel, "10.0.0.2"); holdErr != nil {
+		t.Errorf("expected the tuner to be free again, got %v", holdErr)
+	}
+}
+
+func TestParseStreamOptions(t *testing.T) {
+	tests := []struct {
+		duration  string
+		transcode string
+		expected  streamOptions
+		set       bool
+		err       string
+	}{
+		{"", "", streamOptions{transcode: transcodeNone}, false, ""},
+		{"", "none", streamOptions{transcode: transcodeNone}, false, ""},
+		{"0", "", streamOptions{transcode: transcodeNone}, false, ""},
+		{"60", "", streamOptions{duration: time.Minute, transcode: transcodeNone}, true, ""},
+		{" 90 ", " Mobile ", streamOptions{duration: 90 * time.Second, transcode: "mobile"}, true, ""},
+		{"", "internet240", streamOptions{transcode: "internet240"}, true, ""},
+		{"-1", "", streamOptions{}, false, "duration -1 must be a number of seconds"},
+		{"1m", "", streamOptions{}, false, "duration 1m must be a number of seconds"},
+		{"", "ultra", streamOptions{}, false, "unknown transcode profile ultra, must be none or one of heavy, internet240, internet360, internet480, internet540, internet720, mobile"},
+	}
+
+	for _, test := range tests {
+		options, parseErr := parseStreamOptions(test.duration, test.transcode)
+		if test.err != "" {
+			if parseErr == nil || parseErr.Error() != test.err {
+				t.Errorf("%q %q: expected error %q, got %v", test.duration, test.transcode, test.err, parseErr)
+			}
+			continue
+		}
+		if parseErr != nil || options != test.expected || options.isSet() != test.set {
+			t.Errorf("%q %q: expected %+v, got %+v (%v)", test.duration, test.transcode, test.expected, options, parseErr)
+		}
+	}
+}
+
+func TestFFmpegArgs(t *testing.T) {
+	tests := []struct {
+		options  streamOptions
+		expected string
+	}{
+		{streamOptions{transcode: transcodeNone}, "-i in -c:v copy -f mpegts pipe:1"},
+		{streamOptions{duration: 90 * time.Second, transcode: transcodeNone}, "-i in -t 90 -c:v copy -f mpegts pipe:1"},
+		{streamOptions{transcode: "heavy"}, "-i in -c:v libx264 -preset veryfast -b:v 8000k -maxrate 8000k -c:a aac -b:a 128k -f mpegts pipe:1"},
+		{streamOptions{duration: time.Minute, transcode: "internet540"}, "-i in -t 60 -c:v libx264 -preset veryfast -b:v 1000k -maxrate 1000k -vf scale=-2:'min(540,ih)' -c:a aac -b:a 128k -f mpegts pipe:1"},
+	}
+
+	for _, test := range tests {
+		if actual := strings.Join(test.options.ffmpegArgs("in"), " "); actual != test.expected {
+			t.Errorf("%+v: expected %q, got %q", test.options, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	upnpMediaServerType        = "urn:schemas-upnp-org:device:MediaServer:1"
+	upnpContentDirectoryType   = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	upnpConnectionManagerType  = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	upnpContentDirectoryName   = "ContentDirectory"
+	upnpConnectionManagerName  = "ConnectionManager"
+	upnpPath                   = "/upnp"
+	upnpSubscriptionTimeoutSec = 1800
+)
+
+// upnpIconSizes are the sizes of the icons in the device description.
+var upnpIconSizes = []int{48, 120}
+
+func upnpIcons() []upnpIcon {
+	icons := make([]upnpIcon, 0, len(upnpIconSizes))
+	for _, size := range upnpIconSizes {
+		icons = append(icons, upnpIcon{
+			Mimetype: "image/png",
+			Width:    size,
+			Height:   size,
+			Depth:    24,
+			URL:      fmt.Sprintf("%s/icon-%d.png", upnpPath, size),
+		})
+	}
+	return icons
+}
+
+func upnpServices() []upnpService {
+	services := make([]upnpService, 0, 2)
+	for _, service := range []struct{ serviceType, name string }{
+		{upnpConnectionManagerType, upnpConnectionManagerName},
+		{upnpContentDirectoryType, upnpContentDirectoryName},
+	} {
+		services = append(services, upnpService{
+			ServiceType: service.serviceType,
+			ServiceID:   fmt.Sprintf("urn:upnp-org:serviceId:%s", service.name),
+			SCPDURL:     fmt.Sprintf("%s/%s.xml", upnpPath, service.name),
+			ControlURL:  fmt.Sprintf("%s/control/%s", upnpPath, service.name),
+			EventSubURL: fmt.Sprintf("%s/event/%s", upnpPath, service.name),
+		})
+	}
+	return services
+}
+
+// upnpArg is a named argument of a SOAP action, in the order it is sent.
+type upnpArg struct {
+	Name  string
+	Value string
+}
+
+// upnpError is a UPnP error returned as a SOAP fault.
+type upnpError struct {
+	Code        int
+	Description string
+}
+
+var (
+	upnpInvalidAction = &upnpError{401, "Invalid Action"}
+	upnpInvalidArgs   = &upnpError{402, "Invalid Args"}
+	upnpActionFailed  = &upnpError{501, "Action Failed"}
+	upnpNoSuchObject  = &upnpError{701, "No such object"}
+)
+
+// upnpActionHandler answers the actions of a service.
+type upnpActionHandler func(action string, args map[string]string) ([]upnpArg, *upnpError)
+
+// registerUPNP adds the service descriptions, control and event URLs and icons to the router.
+func registerUPNP(router *gin.Engine, live *liveLineup) {
+	handlers := map[string]upnpActionHandler{
+		upnpConnectionManagerName: connectionManager,
+		upnpContentDirectoryName:  contentDirectory(live),
+	}
+
+	router.GET(upnpPath+"/"+upnpConnectionManagerName+".xml", serveSCPD(connectionManagerSCPD))
+	router.GET(upnpPath+"/"+upnpContentDirectoryName+".xml", serveSCPD(contentDirectorySCPD))
+
+	services := map[string]string{
+		upnpConnectionManagerName: upnpConnectionManagerType,
+		upnpContentDirectoryName:  upnpContentDirectoryType,
+	}
+	for name, serviceType := range services {
+		router.POST(fmt.Sprintf("%s/control/%s", upnpPath, name), upnpControl(serviceType, handlers[name]))
+		router.Handle("SUBSCRIBE", fmt.Sprintf("%s/event/%s", upnpPath, name), upnpSubscribe)
+		router.Handle("UNSUBSCRIBE", fmt.Sprintf("%s/event/%s", upnpPath, name), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+	}
+
+	for _, size := range upnpIconSizes {
+		icon := drawUPNPIcon(size)
+		router.GET(fmt.Sprintf("%s/icon-%d.png", upnpPath, size), func(c *gin.Context) {
+			c.Data(http.StatusOK, "image/png", icon)
+		})
+	}
+}
+
+func serveSCPD(scpd string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, `text/xml; charset="utf-8"`, []byte(xml.Header+scpd))
+	}
+}
+
+// upnpSubscribe accepts event subscriptions. telly never sends events, its state variables don't change on their own.
+func upnpSubscribe(c *gin.Context) {
+	sid := c.GetHeader("SID")
+	if sid == "" {
+		id := make([]byte, 16)
+		if _, randErr := rand.Read(id); randErr != nil {
+			c.AbortWithError(http.StatusInternalServerError, randErr)
+			return
+		}
+		sid = fmt.Sprintf("uuid:%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+	}
+	c.Header("SID", sid)
+	c.Header("TIMEOUT", fmt.Sprintf("Second-%d", upnpSubscriptionTimeoutSec))
+	c.Status(http.StatusOK)
+}
+
+// upnpControl decodes SOAP requests for a service, passes them to the handler and encodes the response.
+func upnpControl(serviceType string, handler upnpActionHandler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The header looks like "urn:schemas-upnp-org:service:ContentDirectory:1#Browse", quotes included.
+		soapAction := strings.Trim(c.GetHeader("SOAPACTION"), `"`)
+		hash := strings.LastIndex(soapAction, "#")
+		if hash == -1 || soapAction[:hash] != serviceType {
+			writeUPNPFault(c, upnpInvalidAction)
+			return
+		}
+		action := soapAction[hash+1:]
+
+		args, parseErr := parseSOAPArgs(c.Request.Body)
+		if parseErr != nil {
+			log.WithError(parseErr).Debugf("unable to parse UPnP %s request", action)
+			writeUPNPFault(c, upnpInvalidArgs)
+			return
+		}
+
+		if handler == nil {
+			writeUPNPFault(c, upnpInvalidAction)
+			return
+		}
+
+		result, actionErr := handler(action, args)
+		if actionErr != nil {
+			writeUPNPFault(c, actionErr)
+			return
+		}
+
+		var b bytes.Buffer
+		b.WriteString(xml.Header)
+		b.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+		fmt.Fprintf(&b, `<u:%sResponse xmlns:u="%s">`, action, serviceType)
+		for _, arg := range result {
+			fmt.Fprintf(&b, "<%s>", arg.Name)
+			xml.EscapeText(&b, []byte(arg.Value))
+			fmt.Fprintf(&b, "</%s>", arg.Name)
+		}
+		fmt.Fprintf(&b, `</u:%sResponse>`, action)
+		b.WriteString(`</s:Body></s:Envelope>`)
+
+		c.Header("EXT", "")
+		c.Data(http.StatusOK, `text/xml; charset="utf-8"`, b.Bytes())
+	}
+}
+
+// parseSOAPArgs returns the arguments of the action in a SOAP envelope, which are the children of the only element
+// in the body.
+func parseSOAPArgs(body io.Reader) (map[string]string, error) {
+	args := make(map[string]string)
+	decoder := xml.NewDecoder(body)
+
+	depth := 0
+	name := ""
+	var value strings.Builder
+
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr == io.EOF {
+			return args, nil
+		}
+		if tokenErr != nil {
+			return nil, tokenErr
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			// Envelope, Body, action, argument.
+			if depth == 4 {
+				name = t.Name.Local
+				value.Reset()
+			}
+		case xml.CharData:
+			if depth == 4 {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 4 {
+				args[name] = value.String()
+			}
+			depth--
+		}
+	}
+}
+
+func writeUPNPFault(c *gin.Context, upnpErr *upnpError) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><s:Fault>`)
+	b.WriteString(`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0">`)
+	fmt.Fprintf(&b, `<errorCode>%d</errorCode><errorDescription>%s</errorDescription>`, upnpErr.Code, upnpErr.Description)
+	b.WriteString(`</UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+	c.Data(http.StatusInternalServerError, `text/xml; charset="utf-8"`, b.Bytes())
+}
+
+// connectionManager answers the ConnectionManager actions. telly only serves HTTP streams and doesn't track
+// connections, so there is only ever the default connection 0.
+func connectionManager(action string, args map[string]string) ([]upnpArg, *upnpError) {
+	switch action {
+	case "GetProtocolInfo":
+		return []upnpArg{{"Source", "http-get:*:video/mpeg:*,http-get:*:video/mp2t:*"}, {"Sink", ""}}, nil
+	case "GetCurrentConnectionIDs":
+		return []upnpArg{{"ConnectionIDs", "0"}}, nil
+	case "GetCurrentConnectionInfo":
+		if args["ConnectionID"] != "0" {
+			return nil, &upnpError{706, "Invalid connection reference"}
+		}
+		return []upnpArg{
+			{"RcsID", "-1"},
+			{"AVTransportID", "-1"},
+			{"ProtocolInfo", ""},
+			{"PeerConnectionManager", ""},
+			{"PeerConnectionID", "-1"},
+			{"Direction", "Output"},
+			{"Status", "OK"},
+		}, nil
+	}
+	return nil, upnpInvalidAction
+}
+
+// drawUPNPIcon draws telly's icon, a white television on a purple background, as a PNG of the given size.
+func drawUPNPIcon(size int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0x5E, 0x35, 0xB1, 0xFF}}, image.Point{}, draw.Src)
+
+	white := &image.Uniform{color.White}
+	unit := size / 12
+	// Screen outline, then the screen itself in the background color, then the stand.
+	draw.Draw(img, image.Rect(2*unit, 3*unit, 10*unit, 8*unit), white, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(3*unit, 4*unit, 9*unit, 7*unit), &image.Uniform{color.RGBA{0x5E, 0x35, 0xB1, 0xFF}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(5*unit, 8*unit, 7*unit, 9*unit), white, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(4*unit, 9*unit, 8*unit, 10*unit), white, image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	if encodeErr := png.Encode(&buf, img); encodeErr != nil {
+		log.WithError(encodeErr).Errorln("error encoding UPnP icon")
+	}
+	return buf.Bytes()
+}
+
+const connectionManagerSCPD = `<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action>
+      <name>GetProtocolInfo</name>
+      <argumentList>
+        <argument><name>Source</name><direction>out</direction><relatedStateVariable>SourceProtocolInfo</relatedStateVariable></argument>
+        <argument><name>Sink</name><direction>out</direction><relatedStateVariable>SinkProtocolInfo</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetCurrentConnectionIDs</name>
+      <argumentList>
+        <argument><name>ConnectionIDs</name><direction>out</direction><relatedStateVariable>CurrentConnectionIDs</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetCurrentConnectionInfo</name>
+      <argumentList>
+        <argument><name>ConnectionID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+        <argument><name>RcsID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_RcsID</relatedStateVariable></argument>
+        <argument><name>AVTransportID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_AVTransportID</relatedStateVariable></argument>
+        <argument><name>ProtocolInfo</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ProtocolInfo</relatedStateVariable></argument>
+        <argument><name>PeerConnectionManager</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionManager</relatedStateVariable></argument>
+        <argument><name>PeerConnectionID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+        <argument><name>Direction</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Direction</relatedStateVariable></argument>
+        <argument><name>Status</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionStatus</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="yes"><name>SourceProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SinkProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>CurrentConnectionIDs</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionStatus</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>OK</allowedValue><allowedValue>ContentFormatMismatch</allowedValue><allowedValue>InsufficientBandwidth</allowedValue><allowedValue>UnreliableChannel</allowedValue><allowedValue>Unknown</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionManager</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Direction</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>Input</allowedValue><allowedValue>Output</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionID</name><dataType>i4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_AVTransportID</name><dataType>i4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_RcsID</name><dataType>i4</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>`
+
+const contentDirectorySCPD = `<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action>
+      <name>GetSearchCapabilities</name>
+      <argumentList>
+        <argument><name>SearchCaps</name><direction>out</direction><relatedStateVariable>SearchCapabilities</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetSortCapabilities</name>
+      <argumentList>
+        <argument><name>SortCaps</name><direction>out</direction><relatedStateVariable>SortCapabilities</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetSystemUpdateID</name>
+      <argumentList>
+        <argument><name>Id</name><direction>out</direction><relatedStateVariable>SystemUpdateID</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>Browse</name>
+      <argumentList>
+        <argument><name>ObjectID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+        <argument><name>BrowseFlag</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_BrowseFlag</relatedStateVariable></argument>
+        <argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+        <argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+        <argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+        <argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+        <argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ObjectID</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Result</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_BrowseFlag</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>BrowseMetadata</allowedValue><allowedValue>BrowseDirectChildren</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Filter</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_SortCriteria</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Index</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Count</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_UpdateID</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SearchCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SortCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SystemUpdateID</name><dataType>ui4</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>`
@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/spf13/viper"
+	"github.com/tellytv/telly/internal/hdhomerun"
 )
 
 func getTCPAddr(key string) *net.TCPAddr {
@@ -27,5 +29,51 @@ func getDiscoveryData() DiscoveryData {
 		DeviceAuth:      viper.GetString("discovery.device-auth"),
 		BaseURL:         fmt.Sprintf("http://%s", viper.GetString("web.base-address")),
 		LineupURL:       fmt.Sprintf("http://%s/lineup.json", viper.GetString("web.base-address")),
+		DeviceUUID:      viper.GetString("discovery.device-uuid"),
 	}
 }
+
+// deviceUUID returns the UUID telly uses for the device with the given ID.
+func deviceUUID(deviceID string) string {
+	return fmt.Sprintf("%s-AE2A-4E54-BBC9-33AF7D5D6A92", deviceID)
+}
+
+// favoritesPath is where the favorites-only tuner is served.
+const favoritesPath = "/favorites"
+
+// getFavoritesDiscoveryData describes the favorites-only tuner, which needs its own device ID to be told apart.
+func getFavoritesDiscoveryData() DiscoveryData {
+	data := getDiscoveryData()
+
+	data.FriendlyName = fmt.Sprintf("%s Favorites", data.FriendlyName)
+	data.BaseURL = fmt.Sprintf("http://%s%s", viper.GetString("web.base-address"), favoritesPath)
+	data.LineupURL = fmt.Sprintf("%s/lineup.json", data.BaseURL)
+
+	if viper.IsSet("iptv.favorites-device-id") {
+		data.DeviceID = viper.GetString("iptv.favorites-device-id")
+	} else {
+		data.DeviceID = favoritesDeviceID(data.DeviceID)
+	}
+	data.DeviceUUID = deviceUUID(data.DeviceID)
+
+	return data
+}
+
+// favoritesDeviceID derives the device ID of the favorites tuner from the main one.
+// HDHomeRun IDs get a different second to last digit and a recomputed checksum, so they stay valid for clients that
+// check it. Other IDs get a different last character, keeping their length.
+func favoritesDeviceID(deviceID string) string {
+	if id, parseErr := hdhomerun.ParseDeviceID(deviceID); parseErr == nil {
+		return fmt.Sprintf("%08X", hdhomerun.WithValidChecksum(id^0x80))
+	}
+
+	last := len(deviceID) - 1
+	if last < 0 {
+		return deviceID
+	}
+	replacement := "F"
+	if strings.EqualFold(deviceID[last:], "F") {
+		replacement = "E"
+	}
+	return deviceID[:last] + replacement
+}
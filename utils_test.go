@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tellytv/telly/internal/hdhomerun"
+)
+
+func TestFavoritesDeviceID(t *testing.T) {
+	tests := []struct {
+		deviceID string
+		valid    bool
+	}{
+		{"1234ABC7", true},
+		{"12345678", true},
+		{"FFFFFFF8", true},
+		{"telly123", false},
+		{"ABCDEF", false},
+	}
+
+	for _, test := range tests {
+		favorites := favoritesDeviceID(test.deviceID)
+		if favorites == test.deviceID || len(favorites) != len(test.deviceID) {
+			t.Errorf("%s: expected a different ID of the same length, got %s", test.deviceID, favorites)
+		}
+		if !test.valid {
+			continue
+		}
+		id, parseErr := hdhomerun.ParseDeviceID(favorites)
+		if parseErr != nil {
+			t.Fatalf("%s: %s", test.deviceID, parseErr)
+		}
+		if !hdhomerun.ValidDeviceID(id) {
+			t.Errorf("%s: expected %s to have a valid checksum", test.deviceID, favorites)
+		}
+	}
+}